@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/api/handlers"
+	"github.com/alexcolls/now.ink/backend/internal/idempotency"
+	"github.com/alexcolls/now.ink/backend/internal/ratelimit"
+	"github.com/alexcolls/now.ink/backend/internal/scheduler"
+)
+
+// maintenanceJobs builds the periodic jobs run by every API instance
+func maintenanceJobs(h *handlers.Handlers, rateLimitStore *ratelimit.PostgresStore, idempotencyStore *idempotency.Store) []scheduler.Job {
+	jobs := []scheduler.Job{
+		{
+			Name:     "session-cleanup",
+			Interval: parseDuration("JOB_SESSION_CLEANUP_INTERVAL", time.Hour),
+			Jitter:   5 * time.Minute,
+			Timeout:  time.Minute,
+			Run: func(ctx context.Context) (string, error) {
+				if err := h.UserService.CleanExpiredSessions(); err != nil {
+					return "", fmt.Errorf("sessions: %w", err)
+				}
+				if err := h.UserService.CleanExpiredRefreshTokens(); err != nil {
+					return "", fmt.Errorf("refresh tokens: %w", err)
+				}
+				return "expired nonces and refresh tokens removed", nil
+			},
+		},
+		{
+			Name:     "abandoned-stream-reaper",
+			Interval: parseDuration("JOB_STREAM_REAPER_INTERVAL", 30*time.Second),
+			Jitter:   10 * time.Second,
+			Timeout:  time.Minute,
+			Run: func(ctx context.Context) (string, error) {
+				n, err := h.StreamService.ReapAbandonedStreams(ctx)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%d stream(s) ended", n), nil
+			},
+		},
+		{
+			Name:     "scheduled-stream-expiry",
+			Interval: parseDuration("JOB_SCHEDULE_EXPIRY_INTERVAL", 5*time.Minute),
+			Jitter:   30 * time.Second,
+			Timeout:  time.Minute,
+			Run: func(ctx context.Context) (string, error) {
+				n, err := h.StreamService.ExpireScheduledStreams(ctx)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%d scheduled stream(s) expired", n), nil
+			},
+		},
+		{
+			Name:     "upload-cleanup",
+			Interval: parseDuration("JOB_UPLOAD_CLEANUP_INTERVAL", time.Hour),
+			Jitter:   5 * time.Minute,
+			Timeout:  5 * time.Minute,
+			Run: func(ctx context.Context) (string, error) {
+				n, err := h.UploadService.CleanExpired(ctx)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%d upload(s) removed", n), nil
+			},
+		},
+		{
+			Name:     "idempotency-key-cleanup",
+			Interval: parseDuration("JOB_IDEMPOTENCY_CLEANUP_INTERVAL", time.Hour),
+			Jitter:   5 * time.Minute,
+			Timeout:  time.Minute,
+			Run: func(ctx context.Context) (string, error) {
+				// Clients may retry with the same key for a day
+				n, err := idempotencyStore.Sweep(ctx, time.Now().Add(-24*time.Hour))
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%d idempotency key(s) removed", n), nil
+			},
+		},
+		{
+			Name:     "mint-reconciliation",
+			Interval: parseDuration("JOB_MINT_RECONCILE_INTERVAL", 10*time.Minute),
+			Jitter:   time.Minute,
+			Timeout:  2 * time.Minute,
+			Run: func(ctx context.Context) (string, error) {
+				n, err := h.NFTService.ReconcileMintStatus(ctx)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%d stream(s) updated", n), nil
+			},
+		},
+		{
+			Name:     "mint-confirmation-watcher",
+			Interval: parseDuration("JOB_MINT_CONFIRMATION_INTERVAL", 15*time.Second),
+			Jitter:   5 * time.Second,
+			Timeout:  time.Minute,
+			Run: func(ctx context.Context) (string, error) {
+				result, err := h.NFTService.WatchConfirmations(ctx)
+				if err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("%d mint(s) checked, %d finalized, %d requeued", result.Checked, result.Finalized, result.Requeued), nil
+			},
+		},
+	}
+
+	if rateLimitStore != nil {
+		jobs = append(jobs, scheduler.Job{
+			Name:     "rate-limit-sweep",
+			Interval: 10 * time.Minute,
+			Jitter:   time.Minute,
+			Timeout:  time.Minute,
+			Run: func(ctx context.Context) (string, error) {
+				// The longest rate limit window is a day
+				return "old counters removed", rateLimitStore.Sweep(ctx, time.Now().Add(-25*time.Hour))
+			},
+		})
+	}
+
+	return jobs
+}
+
+func parseDuration(key string, fallback time.Duration) time.Duration {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("⚠️  Invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/alexcolls/now.ink/backend/internal/api/handlers"
+	"github.com/alexcolls/now.ink/backend/internal/api/middleware"
 	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/alexcolls/now.ink/backend/internal/idempotency"
+	"github.com/alexcolls/now.ink/backend/internal/ratelimit"
+	"github.com/alexcolls/now.ink/backend/internal/scheduler"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -19,33 +27,65 @@ func main() {
 		log.Println("⚠️  No .env file found, using environment variables")
 	}
 
+	// Load JWT signing keys (refuses to start in production without one)
+	if err := middleware.LoadSigningKeys(); err != nil {
+		log.Fatal("❌ Failed to load JWT signing keys:", err)
+	}
+
 	// Connect to database
 	if err := db.Connect(); err != nil {
 		log.Fatal("❌ Failed to connect to database:", err)
 	}
 	defer db.Close()
 
+	// Share rate limit counters between instances when configured
+	var rateLimitStore *ratelimit.PostgresStore
+	if getEnv("RATE_LIMIT_STORE", "memory") == "postgres" {
+		rateLimitStore = ratelimit.NewPostgresStore(db.DB)
+		middleware.SetRateLimitStore(rateLimitStore)
+		log.Println("✅ Using shared Postgres rate limit store")
+	}
+
+	// Keep responses to requests sent with an Idempotency-Key
+	idempotencyStore := idempotency.NewStore(db.DB)
+	middleware.SetIdempotencyStore(idempotencyStore)
+
 	// Ensure video storage directory exists
 	videoDir := "/tmp/nowink-videos"
 	if err := os.MkdirAll(videoDir, 0755); err != nil {
 		log.Fatal("❌ Failed to create video storage directory:", err)
 	}
 
-	// Create Fiber app
+	// Initialize handlers
+	handlers := handlers.NewHandlers()
+	if rateLimitStore != nil {
+		handlers.ChatService.SetRateLimitStore(rateLimitStore)
+	}
+
+	// Create Fiber app; upload chunks arrive as whole request bodies, so the
+	// body limit follows the chunk size
 	app := fiber.New(fiber.Config{
 		AppName:      "now.ink API v0.1.0",
 		ServerHeader: "now.ink",
+		BodyLimit:    bodyLimit(handlers.UploadService.Config().MaxChunkSize),
+		// Behind nginx the client address comes from X-Forwarded-For, which
+		// is only believed when the request comes from TRUSTED_PROXIES
+		ProxyHeader:             fiber.HeaderXForwardedFor,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          trustedProxies(),
+		EnableIPValidation:      true,
 	})
 
 	// Middleware
 	app.Use(recover.New())
 	app.Use(logger.New(logger.Config{
-		Format: "[${time}] ${status} - ${latency} ${method} ${path}\n",
+		Format: "[${time}] ${status} - ${latency} ${method} ${path} ${locals:api_key}\n",
 	}))
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     getEnv("CORS_ALLOWED_ORIGINS", "http://localhost:3000"),
-		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
-		AllowMethods:     "GET, POST, PUT, DELETE, OPTIONS",
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, X-API-Key, Idempotency-Key, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata, Upload-Checksum",
+		ExposeHeaders:    "RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy, Retry-After, Location, Idempotent-Replayed, Tus-Resumable, Upload-Offset, Upload-Length, Upload-Expires",
+		AllowMethods:     "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS",
 		AllowCredentials: true,
 	}))
 
@@ -58,6 +98,9 @@ func main() {
 		})
 	})
 
+	// Public keys for verifying access tokens
+	app.Get("/.well-known/jwks.json", middleware.JWKS)
+
 	// API routes
 	api := app.Group("/api/v1")
 
@@ -70,10 +113,38 @@ func main() {
 		})
 	})
 
-	// Initialize handlers
-	handlers := handlers.NewHandlers()
 	handlers.RegisterRoutes(api)
 
+	// Stop background work and the server on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start background maintenance jobs
+	if getEnv("SCHEDULER_ENABLED", "true") == "true" {
+		handlers.Scheduler = scheduler.NewScheduler(maintenanceJobs(handlers, rateLimitStore, idempotencyStore)...)
+		handlers.Scheduler.Start(ctx)
+	}
+
+	// Write viewer counts back in batches
+	viewersFlushed := make(chan struct{})
+	go func() {
+		handlers.StreamService.RunViewerFlusher(ctx)
+		close(viewersFlushed)
+	}()
+
+	// Work through queued mints; interrupted jobs are handed back on shutdown
+	mintsStopped := make(chan struct{})
+	go func() {
+		handlers.NFTService.RunMintWorkers(ctx)
+		close(mintsStopped)
+	}()
+
+	go func() {
+		<-ctx.Done()
+		log.Println("🛑 Shutting down...")
+		app.Shutdown()
+	}()
+
 	// Start server
 	port := getEnv("PORT", "8080")
 	log.Printf("🚀 now.ink API starting on port %s", port)
@@ -83,6 +154,12 @@ func main() {
 	if err := app.Listen(":" + port); err != nil {
 		log.Fatal("❌ Server failed to start:", err)
 	}
+
+	if handlers.Scheduler != nil {
+		handlers.Scheduler.Wait()
+	}
+	<-viewersFlushed
+	<-mintsStopped
 }
 
 func getEnv(key, fallback string) string {
@@ -91,3 +168,21 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// bodyLimit is the largest request body the server reads: a full upload
+// chunk, and never less than Fiber's default
+func bodyLimit(maxChunkSize int64) int {
+	return int(max(maxChunkSize, fiber.DefaultBodyLimit))
+}
+
+// trustedProxies lists the addresses (or CIDR ranges) of the reverse proxies
+// in front of the API; without any, X-Forwarded-For is ignored
+func trustedProxies() []string {
+	proxies := []string{}
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
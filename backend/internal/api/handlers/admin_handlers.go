@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+
+	"github.com/alexcolls/now.ink/backend/internal/models"
+	"github.com/alexcolls/now.ink/backend/internal/scheduler"
+	"github.com/alexcolls/now.ink/backend/internal/services/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleAdminEndStream force-ends a live stream
+func (h *Handlers) HandleAdminEndStream(c *fiber.Ctx) error {
+	streamID := c.Params("id")
+
+	stream, err := h.StreamService.AdminEndStream(c.Context(), streamID)
+	if err != nil {
+		return streamErrorResponse(c, err)
+	}
+
+	return c.JSON(stream)
+}
+
+// HandleAdminHideStream removes a stream from public listings
+func (h *Handlers) HandleAdminHideStream(c *fiber.Ctx) error {
+	streamID := c.Params("id")
+
+	if err := h.StreamService.SetStreamVisibility(c.Context(), streamID, false); err != nil {
+		return streamErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"stream_id": streamID,
+		"is_public": false,
+	})
+}
+
+// HandleAdminSetUserRole changes a user's role
+func (h *Handlers) HandleAdminSetUserRole(c *fiber.Ctx) error {
+	userID := c.Params("user_id")
+	if userID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "user_id required"})
+	}
+
+	var req models.UpdateUserRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+	}
+
+	if !models.IsValidRole(req.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "role must be user, moderator or admin"})
+	}
+
+	err := h.UserService.SetUserRole(c.Context(), userID, req.Role)
+	switch {
+	case errors.Is(err, user.ErrInvalidUserID):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, user.ErrUserNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		log.Printf("❌ Failed to set role of user %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to update role"})
+	}
+
+	return c.JSON(fiber.Map{
+		"user_id": userID,
+		"role":    req.Role,
+	})
+}
+
+// HandleAdminListJobs shows the background jobs and their recent runs
+func (h *Handlers) HandleAdminListJobs(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	runs, err := scheduler.RecentRuns(c.Context(), c.Query("job"), limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	jobs := []scheduler.JobInfo{}
+	if h.Scheduler != nil {
+		jobs = h.Scheduler.Jobs()
+	}
+
+	return c.JSON(fiber.Map{
+		"enabled": h.Scheduler != nil,
+		"jobs":    jobs,
+		"runs":    runs,
+	})
+}
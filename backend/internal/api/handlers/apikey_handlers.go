@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alexcolls/now.ink/backend/internal/api/middleware"
+	"github.com/alexcolls/now.ink/backend/internal/models"
+	"github.com/alexcolls/now.ink/backend/internal/services/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleCreateAPIKey creates a named, scoped API key for the current user
+func (h *Handlers) HandleCreateAPIKey(c *fiber.Ctx) error {
+	walletAddress, ok := c.Locals("wallet_address").(string)
+	if !ok || walletAddress == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	// Keys can only be minted from a wallet login, not by another key
+	if keyID, _ := c.Locals("api_key_id").(string); keyID != "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "API keys cannot create API keys"})
+	}
+
+	account, err := h.UserService.GetUserByWallet(walletAddress)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	var req models.CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+	}
+
+	if req.Name == "" || len(req.Name) > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name required (max 100 characters)"})
+	}
+
+	key, apiKey, err := h.UserService.CreateAPIKey(c.Context(), account, &req)
+	switch {
+	case errors.Is(err, user.ErrScopeNotAllowed):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create API key"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(models.CreateAPIKeyResponse{
+		Key:    key,
+		APIKey: *apiKey,
+	})
+}
+
+// HandleListAPIKeys lists the current user's API keys
+func (h *Handlers) HandleListAPIKeys(c *fiber.Ctx) error {
+	walletAddress, ok := c.Locals("wallet_address").(string)
+	if !ok || walletAddress == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	account, err := h.UserService.GetUserByWallet(walletAddress)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	keys, err := h.UserService.ListAPIKeys(c.Context(), account.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"api_keys": keys,
+		"count":    len(keys),
+	})
+}
+
+// HandleRevokeAPIKey revokes one of the current user's API keys
+func (h *Handlers) HandleRevokeAPIKey(c *fiber.Ctx) error {
+	walletAddress, ok := c.Locals("wallet_address").(string)
+	if !ok || walletAddress == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	account, err := h.UserService.GetUserByWallet(walletAddress)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	err = h.UserService.RevokeAPIKey(c.Context(), account.ID, c.Params("id"))
+	switch {
+	case errors.Is(err, user.ErrAPIKeyNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to revoke API key"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "API key revoked",
+	})
+}
+
+// lookupAPIKey adapts the user service to middleware.APIKeyLookup
+func (h *Handlers) lookupAPIKey(ctx context.Context, key string) (*middleware.APIKeyIdentity, error) {
+	owner, err := h.UserService.AuthenticateAPIKey(ctx, key)
+	if errors.Is(err, user.ErrInvalidAPIKey) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &middleware.APIKeyIdentity{
+		KeyID:              owner.Key.ID.String(),
+		Prefix:             owner.Key.Prefix,
+		UserID:             owner.Key.UserID.String(),
+		WalletAddress:      owner.WalletAddress,
+		Role:               owner.Role,
+		Scopes:             owner.Key.Scopes,
+		RateLimitPerMinute: owner.Key.RateLimitPerMinute,
+	}, nil
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/api/middleware"
+	"github.com/alexcolls/now.ink/backend/internal/models"
+	"github.com/alexcolls/now.ink/backend/internal/services/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleRefresh exchanges a refresh token for a new access and refresh token
+func (h *Handlers) HandleRefresh(c *fiber.Ctx) error {
+	var req models.RefreshTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+	}
+
+	if req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "refresh_token required"})
+	}
+
+	refreshToken, refresh, err := h.UserService.RotateRefreshToken(c.Context(), req.RefreshToken)
+	switch {
+	case errors.Is(err, user.ErrRefreshTokenReused):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "refresh token reuse detected, session revoked"})
+	case errors.Is(err, user.ErrInvalidRefreshToken):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid or expired refresh token"})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to refresh token"})
+	}
+
+	// Re-read the user so role changes apply from the next refresh
+	account, err := h.UserService.GetUserByID(c.Context(), refresh.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	token, expiresAt, err := issueAccessToken(account, refresh.WalletAddress, refresh.FamilyID.String())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to generate token"})
+	}
+
+	return c.JSON(models.TokenResponse{
+		Token:            token,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refresh.ExpiresAt,
+	})
+}
+
+// HandleLogout revokes the current login session
+func (h *Handlers) HandleLogout(c *fiber.Ctx) error {
+	sessionID, ok := c.Locals("session_id").(string)
+	if !ok || sessionID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	if err := h.UserService.RevokeTokenFamily(c.Context(), sessionID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to log out"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "logged out",
+	})
+}
+
+// HandleLogoutAll revokes every login session of the current user
+func (h *Handlers) HandleLogoutAll(c *fiber.Ctx) error {
+	walletAddress, ok := c.Locals("wallet_address").(string)
+	if !ok || walletAddress == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	user, err := h.UserService.GetUserByWallet(walletAddress)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	if err := h.UserService.RevokeAllTokens(c.Context(), user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to log out"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "logged out of all sessions",
+	})
+}
+
+// HandleListSessions lists the devices the current user is logged in on
+func (h *Handlers) HandleListSessions(c *fiber.Ctx) error {
+	walletAddress, ok := c.Locals("wallet_address").(string)
+	if !ok || walletAddress == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	account, err := h.UserService.GetUserByWallet(walletAddress)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	sessions, err := h.UserService.ListSessions(c.Context(), account.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to list sessions"})
+	}
+
+	currentID, _ := c.Locals("session_id").(string)
+	for _, session := range sessions {
+		session.Current = session.ID.String() == currentID
+	}
+
+	return c.JSON(fiber.Map{
+		"sessions": sessions,
+	})
+}
+
+// HandleRevokeSession logs one of the current user's sessions out
+func (h *Handlers) HandleRevokeSession(c *fiber.Ctx) error {
+	walletAddress, ok := c.Locals("wallet_address").(string)
+	if !ok || walletAddress == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	account, err := h.UserService.GetUserByWallet(walletAddress)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	sessionID := c.Params("id")
+	err = h.UserService.RevokeSession(c.Context(), account.ID, sessionID)
+	switch {
+	case errors.Is(err, user.ErrSessionNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to revoke session"})
+	}
+
+	return c.JSON(fiber.Map{
+		"session_id": sessionID,
+		"revoked":    true,
+	})
+}
+
+// deviceInfo describes the device a login request comes from
+func deviceInfo(c *fiber.Ctx, deviceName string) user.DeviceInfo {
+	return user.DeviceInfo{
+		Name:      deviceName,
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IPAddress: c.IP(),
+	}
+}
+
+// issueAccessToken signs an access token for a wallet login with the scopes
+// of the user's role
+func issueAccessToken(account *models.User, walletAddress, sessionID string) (string, time.Time, error) {
+	return middleware.GenerateToken(middleware.TokenSubject{
+		UserID:        account.ID.String(),
+		WalletAddress: walletAddress,
+		SessionID:     sessionID,
+		Role:          account.Role,
+		Scopes:        models.DefaultScopes(account.Role),
+	})
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"slices"
+	"strconv"
+
+	"github.com/alexcolls/now.ink/backend/internal/chathub"
+	"github.com/alexcolls/now.ink/backend/internal/models"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleChatUpgrade checks a WebSocket handshake for a live stream's chat.
+// The stream's owner joins as the broadcaster and can moderate; banned
+// users are turned away.
+func (h *Handlers) HandleChatUpgrade(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{"error": "websocket upgrade required"})
+	}
+
+	streamID := c.Params("id")
+	userID, _ := c.Locals("user_id").(string)
+
+	stream, err := h.StreamService.GetStream(c.Context(), streamID, userID)
+	if err != nil {
+		return streamErrorResponse(c, err)
+	}
+
+	if !stream.IsLive {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "stream is not live"})
+	}
+
+	role := chathub.RoleViewer
+	if stream.UserID == userID {
+		scopes, _ := c.Locals("scopes").([]string)
+		if slices.Contains(scopes, models.ScopeStreamsWrite) {
+			role = chathub.RoleBroadcaster
+		}
+	} else {
+		banned, err := h.ChatService.IsBanned(c.Context(), stream.ID, userID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to check chat ban"})
+		}
+		if banned {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "banned from this chat"})
+		}
+	}
+
+	c.Locals("stream_id", stream.ID)
+	c.Locals("chat_role", role)
+	return c.Next()
+}
+
+// HandleGetChatHistory pages back through a stream's chat, oldest first.
+// Pass the id of the oldest message seen as ?before= to get earlier ones.
+func (h *Handlers) HandleGetChatHistory(c *fiber.Ctx) error {
+	streamID := c.Params("id")
+	userID, _ := c.Locals("user_id").(string)
+
+	stream, err := h.StreamService.GetStream(c.Context(), streamID, userID)
+	if err != nil {
+		return streamErrorResponse(c, err)
+	}
+
+	before, err := strconv.ParseInt(c.Query("before", "0"), 10, 64)
+	if err != nil || before < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid before"})
+	}
+
+	limit := parseInt(c.Query("limit", "50"), 50)
+	if limit == 0 || limit > 200 {
+		limit = 50
+	}
+
+	messages, err := h.ChatService.History(c.Context(), stream.ID, before, limit)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"messages": messages,
+		"count":    len(messages),
+	})
+}
@@ -1,59 +1,153 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 
 	"github.com/alexcolls/now.ink/backend/internal/api/middleware"
+	"github.com/alexcolls/now.ink/backend/internal/chathub"
 	"github.com/alexcolls/now.ink/backend/internal/models"
+	"github.com/alexcolls/now.ink/backend/internal/scheduler"
+	"github.com/alexcolls/now.ink/backend/internal/services/chat"
 	"github.com/alexcolls/now.ink/backend/internal/services/nft"
+	"github.com/alexcolls/now.ink/backend/internal/services/segment"
 	"github.com/alexcolls/now.ink/backend/internal/services/stream"
+	"github.com/alexcolls/now.ink/backend/internal/services/upload"
 	"github.com/alexcolls/now.ink/backend/internal/services/user"
+	"github.com/alexcolls/now.ink/backend/internal/signaling"
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 )
 
 // Handlers holds all service dependencies
 type Handlers struct {
-	StreamService *stream.Service
-	NFTService    *nft.Service
-	UserService   *user.Service
+	StreamService  *stream.Service
+	NFTService     *nft.Service
+	UserService    *user.Service
+	UploadService  *upload.Service
+	SegmentService *segment.Service
+	ChatService    *chat.Service
+	Signaling      *signaling.Hub
+	Chat           *chathub.Hub
+	// Scheduler runs background jobs; set by main when enabled
+	Scheduler *scheduler.Scheduler
 }
 
 // NewHandlers creates new handlers with services
 func NewHandlers() *Handlers {
+	chatService := chat.NewService()
 	return &Handlers{
-		StreamService: stream.NewService(),
-		NFTService:    nft.NewService(),
-		UserService:   user.NewService(),
+		StreamService:  stream.NewService(),
+		NFTService:     nft.NewService(),
+		UserService:    user.NewService(),
+		UploadService:  upload.NewService(),
+		SegmentService: segment.NewService(),
+		ChatService:    chatService,
+		Signaling:      signaling.NewHub(),
+		Chat:           chathub.NewHub(chatService),
 	}
 }
 
 // RegisterRoutes registers all API routes
 func (h *Handlers) RegisterRoutes(api fiber.Router) {
+	limits := loadRateLimits()
+
+	// Reject access tokens whose session was revoked and track last-seen
+	middleware.SetRevocationCheck(h.UserService.TouchSession)
+
+	// Disconnect signaling and chat peers when their stream ends
+	h.StreamService.OnStreamEnded(h.Signaling.CloseStream)
+	h.StreamService.OnStreamEnded(h.Chat.CloseStream)
+
+	// Assemble the recording from segments pushed while live
+	h.StreamService.OnStreamEnded(h.assembleRecording)
+
+	// Count viewers from their signaling connections
+	h.Signaling.OnViewerJoined(h.StreamService.ViewerJoined)
+	h.Signaling.OnViewerLeft(h.StreamService.ViewerLeft)
+
+	// Accept API keys anywhere a JWT is accepted
+	middleware.SetAPIKeyLookup(h.lookupAPIKey)
+	api.Use(middleware.APIKeyAuth(), middleware.RateLimit(limits.Global))
+
 	// Auth routes
 	auth := api.Group("/auth")
-	auth.Post("/nonce", h.HandleNonce)
-	auth.Post("/verify", h.HandleVerify)
+	auth.Post("/nonce", middleware.RateLimit(limits.Nonce), middleware.RateLimit(limits.NonceWallet), h.HandleNonce)
+	auth.Post("/verify", middleware.RateLimit(limits.Auth), h.HandleVerify)
+	auth.Post("/refresh", middleware.RateLimit(limits.Auth), h.HandleRefresh)
+	auth.Post("/logout", middleware.AuthRequired(), h.HandleLogout)
+	auth.Post("/logout-all", middleware.AuthRequired(), middleware.RequireSession(), h.HandleLogoutAll)
+	auth.Get("/sessions", middleware.AuthRequired(), h.HandleListSessions)
+	auth.Delete("/sessions/:id", middleware.AuthRequired(), middleware.RequireSession(), h.HandleRevokeSession)
 
 	// Stream routes (authenticated)
 	streams := api.Group("/streams", middleware.AuthRequired())
-	streams.Post("/start", h.HandleStartStream)
-	streams.Post("/:id/end", h.HandleEndStream)
-	streams.Post("/:id/save", h.HandleSaveStream)
+	streams.Post("/start", middleware.RequireScope(models.ScopeStreamsWrite), middleware.RateLimit(limits.StreamStart), h.HandleStartStream)
+	streams.Post("/schedule", middleware.RequireScope(models.ScopeStreamsWrite), middleware.RateLimit(limits.Write), h.HandleScheduleStream)
+	streams.Post("/:id/heartbeat", middleware.RequireScope(models.ScopeStreamsWrite), h.HandleStreamHeartbeat)
+	streams.Post("/:id/end", middleware.RequireScope(models.ScopeStreamsWrite), middleware.RateLimit(limits.Write), h.HandleEndStream)
+	streams.Post("/:id/save", middleware.RequireScope(models.ScopeStreamsWrite, models.ScopeNFTsMint), middleware.Idempotency(), middleware.RateLimit(limits.Mint), h.HandleSaveStream)
+	streams.Post("/:id/uploads", middleware.RequireScope(models.ScopeStreamsWrite), middleware.RateLimit(limits.Write), h.HandleCreateUpload)
+	streams.Head("/:id/uploads/:upload_id", h.HandleUploadOffset)
+	streams.Get("/:id/uploads/:upload_id", h.HandleGetUpload)
+	streams.Patch("/:id/uploads/:upload_id", middleware.RequireScope(models.ScopeStreamsWrite), h.HandleUploadChunk)
+	streams.Post("/:id/track", middleware.RequireScope(models.ScopeStreamsWrite), h.HandleAppendTrack)
+	streams.Put("/:id/segments/:seq", middleware.RequireScope(models.ScopeStreamsWrite), h.HandleIngestSegment)
+	streams.Get("/:id/chat", h.HandleGetChatHistory)
 	streams.Get("/live", h.HandleListLiveStreams)
 	streams.Get("/:id", h.HandleGetStream)
 
+	// WebRTC signaling for live streams
+	ws := api.Group("/ws")
+	ws.Get("/stream/:id", middleware.WebSocketAuth(), h.HandleSignalingUpgrade, websocket.New(h.Signaling.Serve))
+	ws.Get("/chat/:id", middleware.WebSocketAuth(), h.HandleChatUpgrade, websocket.New(h.Chat.Serve))
+
 	// NFT routes
 	nfts := api.Group("/nfts")
 	nfts.Get("/", h.HandleListNFTs)
 	nfts.Get("/:mint_address", h.HandleGetNFT)
 	nfts.Get("/:mint_address/playback", h.HandleGetPlayback)
+	nfts.Get("/:mint_address/confirmation", h.HandleGetConfirmation)
+
+	// Mint job routes (authenticated)
+	mints := api.Group("/mints", middleware.AuthRequired())
+	mints.Get("/:job_id", h.HandleGetMintJob)
 
 	// Social routes (authenticated)
 	social := api.Group("/social", middleware.AuthRequired())
-	social.Post("/follow/:user_id", h.HandleFollowUser)
-	social.Delete("/follow/:user_id", h.HandleUnfollowUser)
+	social.Post("/follow/:user_id", middleware.RequireScope(models.ScopeSocialWrite), middleware.RateLimit(limits.Write), h.HandleFollowUser)
+	social.Delete("/follow/:user_id", middleware.RequireScope(models.ScopeSocialWrite), middleware.RateLimit(limits.Write), h.HandleUnfollowUser)
 	social.Get("/following/:user_id/check", h.HandleCheckFollowing)
 	social.Get("/feed", h.HandleGetFeed)
+	social.Get("/upcoming", h.HandleGetUpcomingStreams)
+
+	// Admin routes (moderators and admins)
+	admin := api.Group("/admin", middleware.AuthRequired(), middleware.RequireRole(models.RoleModerator, models.RoleAdmin))
+
+	moderation := admin.Group("/moderation", middleware.RequireScope(models.ScopeAdminModeration))
+	moderation.Post("/streams/:id/end", h.HandleAdminEndStream)
+	moderation.Post("/streams/:id/hide", h.HandleAdminHideStream)
+
+	ops := admin.Group("/ops", middleware.RequireRole(models.RoleAdmin), middleware.RequireScope(models.ScopeAdminOps))
+	ops.Put("/users/:user_id/role", h.HandleAdminSetUserRole)
+	ops.Get("/jobs", h.HandleAdminListJobs)
+
+	// Linked wallet routes (wallet login only, registered before /users/:user_id)
+	wallets := api.Group("/users/me/wallets", middleware.AuthRequired(), middleware.RequireSession())
+	wallets.Get("/", h.HandleListWallets)
+	wallets.Post("/nonce", middleware.RateLimit(limits.Nonce), h.HandleLinkWalletNonce)
+	wallets.Post("/", middleware.RateLimit(limits.Auth), h.HandleLinkWallet)
+	wallets.Put("/:wallet_address/primary", h.HandleSetPrimaryWallet)
+	wallets.Delete("/:wallet_address", h.HandleUnlinkWallet)
+
+	// API key routes (authenticated)
+	apiKeys := api.Group("/users/me/api-keys", middleware.AuthRequired())
+	apiKeys.Get("/", h.HandleListAPIKeys)
+	apiKeys.Post("/", middleware.RequireSession(), middleware.RateLimit(limits.Write), h.HandleCreateAPIKey)
+	apiKeys.Delete("/:id", middleware.RequireSession(), h.HandleRevokeAPIKey)
 
 	// User routes
 	users := api.Group("/users")
@@ -74,13 +168,16 @@ func (h *Handlers) HandleNonce(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "wallet_address required"})
 	}
 
-	nonce, err := h.UserService.GenerateNonce(req.WalletAddress)
+	session, message, err := h.UserService.GenerateNonce(req.WalletAddress)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to generate nonce"})
 	}
 
 	return c.JSON(models.GetNonceResponse{
-		Nonce: nonce,
+		Nonce:     session.Nonce,
+		Message:   session.Message,
+		Fields:    *message,
+		ExpiresAt: session.ExpiresAt,
 	})
 }
 
@@ -91,38 +188,47 @@ func (h *Handlers) HandleVerify(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
 	}
 
-	if req.WalletAddress == "" || req.Signature == "" || req.Nonce == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "wallet_address, signature, and nonce required"})
+	if req.WalletAddress == "" || req.Signature == "" || (req.Nonce == "" && req.Message == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "wallet_address, signature, and nonce or message required"})
 	}
 
-	// Validate nonce
-	valid, err := h.UserService.ValidateNonce(req.WalletAddress, req.Nonce)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to validate nonce"})
-	}
-
-	if !valid {
+	// Validate nonce and verify the wallet signed the issued SIWS message
+	err := h.UserService.VerifyLogin(req.WalletAddress, req.Nonce, req.Message, req.Signature)
+	switch {
+	case errors.Is(err, user.ErrInvalidNonce):
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid or expired nonce"})
+	case errors.Is(err, user.ErrInvalidMessage):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, user.ErrInvalidSignature):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid signature"})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to validate nonce"})
 	}
 
-	// TODO: Verify Solana signature (req.Signature)
-	// For MVP, we trust the nonce validation
-
 	// Get or create user
 	user, err := h.UserService.GetOrCreateUser(req.WalletAddress)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create user"})
 	}
 
+	// Start a token family for this login
+	refreshToken, refresh, err := h.UserService.CreateRefreshToken(c.Context(), user, req.WalletAddress, deviceInfo(c, req.DeviceName))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to generate token"})
+	}
+
 	// Generate JWT token
-	token, err := middleware.GenerateToken(req.WalletAddress)
+	token, expiresAt, err := issueAccessToken(user, req.WalletAddress, refresh.FamilyID.String())
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to generate token"})
 	}
 
 	return c.JSON(models.VerifyWalletResponse{
-		Token: token,
-		User:  *user,
+		Token:            token,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refresh.ExpiresAt,
+		User:             *user,
 	})
 }
 
@@ -149,110 +255,330 @@ func (h *Handlers) HandleStartStream(c *fiber.Ctx) error {
 
 	stream, err := h.StreamService.StartStream(c.Context(), &req)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		return streamErrorResponse(c, err)
 	}
 
 	return c.JSON(stream)
 }
 
-// HandleEndStream ends a live stream
+// HandleScheduleStream announces a stream ahead of time. It goes live when
+// started with its stream_id and expires if it never is.
+func (h *Handlers) HandleScheduleStream(c *fiber.Ctx) error {
+	var req stream.ScheduleStreamRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+	}
+
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+	req.UserID = userID
+
+	scheduled, err := h.StreamService.ScheduleStream(c.Context(), &req)
+	if err != nil {
+		return streamErrorResponse(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(scheduled)
+}
+
+// HandleEndStream ends one of the user's live streams
 func (h *Handlers) HandleEndStream(c *fiber.Ctx) error {
 	streamID := c.Params("id")
-	
-	stream, err := h.StreamService.EndStream(c.Context(), streamID)
+	userID, _ := c.Locals("user_id").(string)
+
+	stream, err := h.StreamService.EndStream(c.Context(), streamID, userID)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		return streamErrorResponse(c, err)
 	}
 
 	return c.JSON(stream)
 }
 
-// HandleSaveStream saves stream as NFT (triggers minting)
-func (h *Handlers) HandleSaveStream(c *fiber.Ctx) error {
+// streamErrorResponse maps stream service errors to HTTP statuses
+func streamErrorResponse(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, stream.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, stream.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, stream.ErrAlreadyEnded), errors.Is(err, stream.ErrTrackFull),
+		errors.Is(err, stream.ErrNotStarted), errors.Is(err, stream.ErrScheduleExpired), errors.Is(err, stream.ErrNotScheduled):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, stream.ErrInvalidTrack), errors.Is(err, stream.ErrInvalidSchedule):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+}
+
+// HandleStreamHeartbeat keeps a live stream alive; streams that stop sending
+// heartbeats are ended by the scheduler
+func (h *Handlers) HandleStreamHeartbeat(c *fiber.Ctx) error {
 	streamID := c.Params("id")
 
-	// Get wallet address from JWT
-	walletAddress, ok := c.Locals("wallet_address").(string)
-	if !ok || walletAddress == "" {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
 	}
 
-	// Parse multipart form
-	file, err := c.FormFile("video")
+	lastHeartbeatAt, err := h.StreamService.Heartbeat(c.Context(), streamID, userID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "video file required"})
+		return streamErrorResponse(c, err)
 	}
 
-	// Validate file size (max 100MB for MVP)
-	if file.Size > 100*1024*1024 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "video file too large (max 100MB)"})
+	return c.JSON(fiber.Map{
+		"stream_id":         streamID,
+		"last_heartbeat_at": lastHeartbeatAt,
+		"timeout_seconds":   int(h.StreamService.HeartbeatTimeout().Seconds()),
+	})
+}
+
+// AppendTrackRequest carries GPS samples taken while streaming
+type AppendTrackRequest struct {
+	Samples []stream.TrackSample `json:"samples"`
+}
+
+// HandleAppendTrack appends GPS samples to one of the user's live streams.
+// Clients can batch samples and retry; repeated timestamps are ignored.
+func (h *Handlers) HandleAppendTrack(c *fiber.Ctx) error {
+	streamID := c.Params("id")
+
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
 	}
 
-	// Validate file type
-	contentType := file.Header.Get("Content-Type")
-	if contentType != "video/mp4" && contentType != "video/quicktime" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "only mp4 and mov videos supported"})
+	var req AppendTrackRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
 	}
 
-	// Fetch stream details from database
-	stream, err := h.StreamService.GetStream(c.Context(), streamID)
+	points, length, err := h.StreamService.AppendTrack(c.Context(), streamID, userID, req.Samples)
 	if err != nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "stream not found"})
+		return streamErrorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"stream_id":     streamID,
+		"points":        points,
+		"length_meters": length,
+	})
+}
+
+// HandleSaveStream saves stream as NFT (triggers minting). The recording is
+// a finished resumable upload (upload_id), a multipart video file, or else
+// the segments pushed while the stream was live. With include_chat the live
+// chat is archived alongside the video. Minting runs in the background;
+// the response points at the mint job to poll.
+func (h *Handlers) HandleSaveStream(c *fiber.Ctx) error {
+	streamID := c.Params("id")
+
+	// Get wallet address from JWT
+	walletAddress, ok := c.Locals("wallet_address").(string)
+	if !ok || walletAddress == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
 	}
 
-	// Verify ownership
 	user, err := h.UserService.GetUserByWallet(walletAddress)
-	if err != nil || stream.UserID != user.ID.String() {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "not authorized to save this stream"})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user not found"})
 	}
 
-	// Save video file to temporary storage
-	// In production, this would go to S3 or similar
-	videoPath := fmt.Sprintf("/tmp/nowink-videos/%s.mp4", streamID)
-	if err := c.SaveFile(file, videoPath); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to save video"})
+	// Fetch stream details from database and verify ownership
+	recording, err := h.StreamService.GetStream(c.Context(), streamID, user.ID.String())
+	if err != nil {
+		return streamErrorResponse(c, err)
+	}
+	if recording.UserID != user.ID.String() {
+		return streamErrorResponse(c, stream.ErrForbidden)
+	}
+	if err := recording.CheckStarted(); err != nil {
+		return streamErrorResponse(c, err)
+	}
+
+	// A stream is minted once; saving it again returns the existing mint
+	if done, err := h.existingMintResponse(c, recording); done {
+		return err
+	}
+
+	var videoPath string
+	var completedUpload *upload.Upload
+
+	uploadID := c.FormValue("upload_id")
+	includeChat := c.FormValue("include_chat") == "true"
+	if uploadID == "" && c.Is("json") {
+		var body struct {
+			UploadID    string `json:"upload_id"`
+			IncludeChat bool   `json:"include_chat"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+		}
+		uploadID = body.UploadID
+		includeChat = body.IncludeChat
+	}
+
+	if uploadID != "" {
+		// Use a finished resumable upload
+		completedUpload, err = h.UploadService.CompletedFile(c.Context(), uploadID, recording.ID, user.ID.String())
+		if err != nil {
+			return uploadErrorResponse(c, err)
+		}
+		videoPath = completedUpload.FilePath
+	} else if file, err := c.FormFile("video"); err == nil {
+		// Validate file size (max 100MB for MVP)
+		if file.Size > 100*1024*1024 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "video file too large (max 100MB, use a resumable upload)"})
+		}
+
+		// Validate file type
+		contentType := file.Header.Get("Content-Type")
+		if contentType != "video/mp4" && contentType != "video/quicktime" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "only mp4 and mov videos supported"})
+		}
+
+		// Save video file to temporary storage
+		// In production, this would go to S3 or similar
+		videoPath = fmt.Sprintf("/tmp/nowink-videos/%s.mp4", streamID)
+		if err := c.SaveFile(file, videoPath); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to save video"})
+		}
+	} else {
+		// Use the segments pushed while live, which are assembled once the
+		// stream has ended
+		if recording.IsLive {
+			recording, err = h.StreamService.EndStream(c.Context(), streamID, user.ID.String())
+			if err != nil {
+				return streamErrorResponse(c, err)
+			}
+		}
+
+		assembled, err := h.SegmentService.Assemble(c.Context(), recording.ID)
+		if errors.Is(err, segment.ErrNoSegments) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "video file, upload_id or live segments required"})
+		}
+		if err != nil {
+			return segmentErrorResponse(c, err)
+		}
+		videoPath = assembled.FilePath
 	}
 
 	videoURL := videoPath // Local path, will be uploaded to Arweave during minting
 
-	// End the stream
-	_, err = h.StreamService.EndStream(c.Context(), streamID)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to end stream"})
+	// End the stream unless the user already did
+	if recording.IsLive {
+		recording, err = h.StreamService.EndStream(c.Context(), streamID, user.ID.String())
+		if err != nil {
+			return streamErrorResponse(c, err)
+		}
 	}
 
-	// Prepare minting request
+	// Prepare minting request (NFTs go to the user's primary wallet)
 	mintReq := &nft.MintRequest{
+		StreamID:   streamID,
 		VideoURL:   videoURL,
-		Title:      stream.Title,
-		UserWallet: walletAddress,
-		Latitude:   stream.Latitude,
-		Longitude:  stream.Longitude,
-		Duration:   calculateDuration(stream),
-		Timestamp:  stream.StartedAt,
+		Title:      recording.Title,
+		UserWallet: user.WalletAddress,
+		Latitude:   recording.Latitude,
+		Longitude:  recording.Longitude,
+		Duration:   calculateDuration(recording),
+		Timestamp:  recording.StartedAt,
+	}
+
+	// Archive the live chat with the recording if asked to
+	if includeChat {
+		transcript, err := h.ChatService.Export(c.Context(), recording.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to export chat"})
+		}
+		mintReq.ChatTranscript = transcript
 	}
 
-	// Mint NFT
-	mintResp, err := h.NFTService.Mint(c.Context(), mintReq)
+	// Record the route if the broadcaster moved while live
+	track, err := h.StreamService.GetTrackSummary(c.Context(), streamID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+		fmt.Printf("⚠️  Failed to load location track for stream %s: %v\n", streamID, err)
+	} else if track != nil {
+		mintReq.Path = &nft.Path{
+			StartLatitude:  track.StartLatitude,
+			StartLongitude: track.StartLongitude,
+			EndLatitude:    track.EndLatitude,
+			EndLongitude:   track.EndLongitude,
+			LengthMeters:   track.LengthMeters,
+		}
+	}
+
+	// Queue the mint; the upload and minting run in the background
+	job, err := h.NFTService.EnqueueMint(c.Context(), mintReq, user.ID.String())
+	if errors.Is(err, nft.ErrMintExists) {
+		// A concurrent save got there first
+		if done, err := h.existingMintResponse(c, recording); done {
+			return err
+		}
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to queue mint"})
 	}
 
-	// Update stream with mint info
-	err = h.StreamService.UpdateStreamMintInfo(c.Context(), streamID, mintResp.MintAddress, mintResp.ArweaveHash)
+	// The upload's file now belongs to the mint job
+	if completedUpload != nil {
+		if err := h.UploadService.MarkConsumed(c.Context(), completedUpload.ID); err != nil {
+			fmt.Printf("⚠️  Failed to mark upload %s consumed: %v\n", completedUpload.ID, err)
+		}
+	}
+
+	return mintJobResponse(c, fiber.StatusAccepted, job, "NFT minting queued")
+}
+
+// existingMintResponse answers a save for a stream that was already minted
+// or has a mint under way. It reports false if there is neither.
+func (h *Handlers) existingMintResponse(c *fiber.Ctx, recording *stream.Stream) (bool, error) {
+	job, err := h.NFTService.StreamMintJob(c.Context(), recording.ID)
 	if err != nil {
-		// Log error but don't fail - NFT was already minted
-		fmt.Printf("⚠️  Failed to update stream with mint info: %v\n", err)
+		return true, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to check for an existing mint"})
 	}
 
-	return c.JSON(fiber.Map{
-		"stream_id":    streamID,
-		"mint_address": mintResp.MintAddress,
-		"arweave_tx":   mintResp.ArweaveHash,
-		"metadata_uri":  mintResp.MetadataURI,
-		"status":       "minted",
-		"message":      "NFT minted successfully!",
-	})
+	if job != nil {
+		message := "NFT minting already in progress"
+		if job.Status == nft.JobMinted {
+			message = "NFT already minted"
+		}
+		return true, mintJobResponse(c, fiber.StatusOK, job, message)
+	}
+
+	// Minted before mints were queued as jobs
+	if recording.MintAddress != "" {
+		return true, c.JSON(fiber.Map{
+			"stream_id":    recording.ID,
+			"mint_address": recording.MintAddress,
+			"arweave_tx":   recording.ArweaveHash,
+			"status":       nft.JobMinted,
+			"message":      "NFT already minted",
+		})
+	}
+
+	return false, nil
+}
+
+// mintJobResponse describes a mint job and where to poll it
+func mintJobResponse(c *fiber.Ctx, status int, job *nft.MintJob, message string) error {
+	statusURL := "/api/v1/mints/" + job.ID
+	c.Location(statusURL)
+
+	resp := fiber.Map{
+		"job_id":     job.ID,
+		"stream_id":  job.StreamID,
+		"status":     job.Status,
+		"status_url": statusURL,
+		"message":    message,
+	}
+	if job.MintAddress != "" {
+		resp["mint_address"] = job.MintAddress
+	}
+
+	return c.Status(status).JSON(resp)
 }
 
 func calculateDuration(s *stream.Stream) int {
@@ -262,26 +588,87 @@ func calculateDuration(s *stream.Stream) int {
 	return int(s.EndedAt.Sub(s.StartedAt).Seconds())
 }
 
-// HandleListLiveStreams lists currently live streams
+// HandleListLiveStreams lists currently live streams, optionally near a
+// point (lat, lng, radius_km) or inside a map viewport
+// (bbox=min_lng,min_lat,max_lng,max_lat), sorted by distance or recency
 func (h *Handlers) HandleListLiveStreams(c *fiber.Ctx) error {
-	streams, err := h.StreamService.ListLiveStreams(c.Context(), 50, 0)
+	filters := &stream.LiveStreamFilters{
+		Sort:   c.Query("sort"),
+		Limit:  parseInt(c.Query("limit", "50"), 50),
+		Offset: parseInt(c.Query("offset", "0"), 0),
+	}
+	if filters.Limit == 0 || filters.Limit > 200 {
+		filters.Limit = 50
+	}
+
+	var err error
+	if filters.Latitude, err = parseFloatQuery(c, "lat"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if filters.Longitude, err = parseFloatQuery(c, "lng"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if radius, err := parseFloatQuery(c, "radius_km"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	} else if radius != nil {
+		filters.RadiusKm = *radius
+	}
+
+	if bbox := c.Query("bbox"); bbox != "" {
+		parts := strings.Split(bbox, ",")
+		coords := make([]float64, len(parts))
+		for i, part := range parts {
+			if coords[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+				break
+			}
+		}
+		if len(parts) != 4 || err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "bbox must be min_lng,min_lat,max_lng,max_lat"})
+		}
+		filters.BBox = &stream.BBox{
+			MinLongitude: coords[0],
+			MinLatitude:  coords[1],
+			MaxLongitude: coords[2],
+			MaxLatitude:  coords[3],
+		}
+	}
+
+	streams, err := h.StreamService.ListLiveStreams(c.Context(), filters)
 	if err != nil {
+		if errors.Is(err, stream.ErrInvalidFilter) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
 
 	return c.JSON(fiber.Map{
 		"streams": streams,
 		"total":   len(streams),
+		"sort":    filters.Sort,
 	})
 }
 
+// parseFloatQuery parses an optional numeric query parameter
+func parseFloatQuery(c *fiber.Ctx, key string) (*float64, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("invalid %s", key)
+	}
+	return &f, nil
+}
+
 // HandleGetStream gets a specific stream
 func (h *Handlers) HandleGetStream(c *fiber.Ctx) error {
 	streamID := c.Params("id")
-	
-	stream, err := h.StreamService.GetStream(c.Context(), streamID)
+	userID, _ := c.Locals("user_id").(string)
+
+	stream, err := h.StreamService.GetStream(c.Context(), streamID, userID)
 	if err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "stream not found"})
+		return streamErrorResponse(c, err)
 	}
 
 	return c.JSON(stream)
@@ -289,10 +676,12 @@ func (h *Handlers) HandleGetStream(c *fiber.Ctx) error {
 
 // HandleListNFTs lists NFTs with filters
 func (h *Handlers) HandleListNFTs(c *fiber.Ctx) error {
-	// TODO: Parse query parameters for filters
+	// TODO: Parse remaining query parameters for filters
 	filters := &nft.NFTFilters{
-		Limit:  50,
-		Offset: 0,
+		Creator:       c.Query("creator"),
+		CreatorUserID: c.Query("user_id"),
+		Limit:         parseInt(c.Query("limit", "50"), 50),
+		Offset:        parseInt(c.Query("offset", "0"), 0),
 	}
 
 	nfts, err := h.NFTService.ListNFTs(c.Context(), filters)
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/alexcolls/now.ink/backend/internal/services/nft"
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleGetMintJob reports the progress of one of the user's mint jobs.
+// Poll it after saving a stream until the status is minted or failed.
+func (h *Handlers) HandleGetMintJob(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	job, err := h.NFTService.GetMintJob(c.Context(), c.Params("job_id"), userID)
+	if errors.Is(err, nft.ErrJobNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get mint job"})
+	}
+
+	return c.JSON(job)
+}
+
+// HandleGetConfirmation reports how far an NFT's mint transaction got on
+// chain. The status moves from confirmed to finalized; final is set once it
+// won't change anymore.
+func (h *Handlers) HandleGetConfirmation(c *fiber.Ctx) error {
+	confirmation, err := h.NFTService.GetConfirmation(c.Context(), c.Params("mint_address"))
+	if errors.Is(err, nft.ErrNFTNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "nft not found"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to get confirmation"})
+	}
+
+	return c.JSON(confirmation)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/api/middleware"
+	"github.com/alexcolls/now.ink/backend/internal/models"
+	"github.com/gofiber/fiber/v2"
+)
+
+// rateLimits holds the rate limit policy for each route group
+type rateLimits struct {
+	Global      middleware.RateLimitPolicy
+	Nonce       middleware.RateLimitPolicy
+	NonceWallet middleware.RateLimitPolicy
+	Auth        middleware.RateLimitPolicy
+	StreamStart middleware.RateLimitPolicy
+	Mint        middleware.RateLimitPolicy
+	Write       middleware.RateLimitPolicy
+}
+
+// loadRateLimits builds the policies, with the main knobs read from env
+func loadRateLimits() rateLimits {
+	return rateLimits{
+		Global: middleware.RateLimitPolicy{
+			Name:   "global",
+			Limit:  getEnvInt("RATE_LIMIT_REQUESTS_PER_SECOND", 10) * 60,
+			Window: time.Minute,
+		},
+		// Every nonce inserts a sessions row, so throttle per IP and per wallet
+		Nonce: middleware.RateLimitPolicy{
+			Name:   "auth-nonce",
+			Limit:  getEnvInt("RATE_LIMIT_NONCES_PER_MINUTE", 10),
+			Window: time.Minute,
+		},
+		NonceWallet: middleware.RateLimitPolicy{
+			Name:   "auth-nonce-wallet",
+			Limit:  getEnvInt("RATE_LIMIT_NONCES_PER_MINUTE", 10),
+			Window: time.Minute,
+			Key:    nonceWalletKey,
+		},
+		Auth: middleware.RateLimitPolicy{
+			Name:   "auth",
+			Limit:  30,
+			Window: time.Minute,
+		},
+		StreamStart: middleware.RateLimitPolicy{
+			Name:   "stream-start",
+			Limit:  getEnvInt("RATE_LIMIT_STREAMS_PER_HOUR", 30),
+			Window: time.Hour,
+		},
+		Mint: middleware.RateLimitPolicy{
+			Name:   "mint",
+			Limit:  getEnvInt("RATE_LIMIT_FREE_MINTS_PER_DAY", 100),
+			Window: 24 * time.Hour,
+		},
+		Write: middleware.RateLimitPolicy{
+			Name:   "write",
+			Limit:  120,
+			Window: time.Minute,
+		},
+	}
+}
+
+// nonceWalletKey keys nonce requests by the wallet they are requested for
+func nonceWalletKey(c *fiber.Ctx) string {
+	var req models.GetNonceRequest
+	if err := c.BodyParser(&req); err != nil || req.WalletAddress == "" {
+		return ""
+	}
+	return "wallet:" + req.WalletAddress
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value := os.Getenv(key); value != "" {
+		if intVal, err := strconv.Atoi(value); err == nil {
+			return intVal
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/services/segment"
+	"github.com/alexcolls/now.ink/backend/internal/services/stream"
+	"github.com/gofiber/fiber/v2"
+)
+
+// segmentContentTypes are the accepted fMP4 segment content types
+var segmentContentTypes = map[string]bool{
+	"video/mp4":                true,
+	"video/iso.segment":        true,
+	"application/octet-stream": true,
+}
+
+// HandleIngestSegment stores one fMP4 segment of a live stream. The seq
+// parameter is "init" for the init segment or the segment's sequence
+// number; an optional X-Segment-Duration header gives its length in seconds
+// like an HLS #EXTINF tag. Re-sending a segment replaces it.
+func (h *Handlers) HandleIngestSegment(c *fiber.Ctx) error {
+	streamID := c.Params("id")
+	userID, _ := c.Locals("user_id").(string)
+
+	seq := segment.InitSeq
+	if param := c.Params("seq"); param != "init" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "seq must be \"init\" or a non-negative integer"})
+		}
+		seq = n
+	}
+
+	contentType, _, _ := strings.Cut(c.Get(fiber.HeaderContentType), ";")
+	if !segmentContentTypes[strings.TrimSpace(contentType)] {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": "only fMP4 segments supported"})
+	}
+
+	var durationMs int
+	if header := c.Get("X-Segment-Duration"); header != "" {
+		seconds, err := strconv.ParseFloat(header, 64)
+		if err != nil || seconds < 0 || seconds > 3600 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid X-Segment-Duration"})
+		}
+		durationMs = int(math.Round(seconds * 1000))
+	}
+
+	recording, err := h.StreamService.GetStream(c.Context(), streamID, userID)
+	if err != nil {
+		return streamErrorResponse(c, err)
+	}
+	if recording.UserID != userID {
+		return streamErrorResponse(c, stream.ErrForbidden)
+	}
+	if err := recording.CheckStarted(); err != nil {
+		return streamErrorResponse(c, err)
+	}
+	if !recording.IsLive {
+		return streamErrorResponse(c, stream.ErrAlreadyEnded)
+	}
+
+	if err := h.SegmentService.Store(c.Context(), recording.ID, seq, durationMs, c.Body()); err != nil {
+		return segmentErrorResponse(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// assembleRecording builds the recording from a stream's segments once it
+// ends. It runs in the background so ending a stream stays fast; saving the
+// stream while it is still running is answered with 503 and Retry-After.
+func (h *Handlers) assembleRecording(streamID, reason string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		defer cancel()
+
+		recording, err := h.SegmentService.Assemble(ctx, streamID)
+		if errors.Is(err, segment.ErrNoSegments) || errors.Is(err, segment.ErrAssembling) {
+			return
+		}
+		if err != nil {
+			fmt.Printf("⚠️  Failed to assemble recording for stream %s: %v\n", streamID, err)
+			return
+		}
+
+		fmt.Printf("✅ Assembled recording for stream %s (%d segments, %d missing, ended: %s)\n",
+			streamID, recording.SegmentCount, recording.MissingSegments, reason)
+	}()
+}
+
+// segmentErrorResponse maps segment service errors to HTTP statuses
+func segmentErrorResponse(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, segment.ErrInvalidSegment):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, segment.ErrStreamTooLarge):
+		status = fiber.StatusRequestEntityTooLarge
+	case errors.Is(err, segment.ErrAlreadyAssembled):
+		status = fiber.StatusConflict
+	case errors.Is(err, segment.ErrNoSegments), errors.Is(err, segment.ErrMissingInit):
+		status = fiber.StatusUnprocessableEntity
+	case errors.Is(err, segment.ErrAssembling):
+		// Not stored as an idempotent response, so the retry goes through
+		c.Set(fiber.HeaderRetryAfter, "5")
+		status = fiber.StatusServiceUnavailable
+	}
+
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
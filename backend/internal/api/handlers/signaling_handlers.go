@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"slices"
+
+	"github.com/alexcolls/now.ink/backend/internal/models"
+	"github.com/alexcolls/now.ink/backend/internal/signaling"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleSignalingUpgrade checks a WebSocket handshake for a stream's
+// signaling channel. The stream's owner joins as the broadcaster (unless they
+// ask for ?role=viewer); everyone else joins as a viewer.
+func (h *Handlers) HandleSignalingUpgrade(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{"error": "websocket upgrade required"})
+	}
+
+	streamID := c.Params("id")
+	userID, _ := c.Locals("user_id").(string)
+
+	stream, err := h.StreamService.GetStream(c.Context(), streamID, userID)
+	if err != nil {
+		return streamErrorResponse(c, err)
+	}
+
+	if !stream.IsLive {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "stream is not live"})
+	}
+
+	isOwner := stream.UserID == userID
+
+	role := signaling.RoleViewer
+	if isOwner && c.Query("role") != signaling.RoleViewer {
+		scopes, _ := c.Locals("scopes").([]string)
+		if !slices.Contains(scopes, models.ScopeStreamsWrite) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":          "Insufficient scope",
+				"required_scope": models.ScopeStreamsWrite,
+			})
+		}
+		if h.Signaling.HasBroadcaster(stream.ID) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "stream already has a broadcaster"})
+		}
+		role = signaling.RoleBroadcaster
+	}
+
+	c.Locals("stream_id", stream.ID)
+	c.Locals("signaling_role", role)
+	return c.Next()
+}
@@ -149,6 +149,28 @@ func (h *Handlers) HandleGetFeed(c *fiber.Ctx) error {
 	})
 }
 
+// HandleGetUpcomingStreams lists scheduled streams from followed users,
+// soonest first
+func (h *Handlers) HandleGetUpcomingStreams(c *fiber.Ctx) error {
+	userID, ok := c.Locals("user_id").(string)
+	if !ok || userID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	limit := parseInt(c.Query("limit", "20"), 20)
+	offset := parseInt(c.Query("offset", "0"), 0)
+
+	upcoming, err := h.StreamService.ListUpcomingStreams(c.Context(), userID, limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"streams": upcoming,
+		"count":   len(upcoming),
+	})
+}
+
 // HandleSearchUsers searches for users by username or wallet
 func (h *Handlers) HandleSearchUsers(c *fiber.Ctx) error {
 	query := c.Query("q")
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/services/stream"
+	"github.com/alexcolls/now.ink/backend/internal/services/upload"
+	"github.com/gofiber/fiber/v2"
+)
+
+// tusVersion is the tus protocol version the upload endpoints follow
+const tusVersion = "1.0.0"
+
+// statusChecksumMismatch is the status tus uses for a failed chunk checksum
+const statusChecksumMismatch = 460
+
+// CreateUploadRequest starts a resumable upload. tus clients send the same
+// through the Upload-Length and Upload-Metadata headers instead.
+type CreateUploadRequest struct {
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+// HandleCreateUpload starts a resumable upload of a stream's recording
+func (h *Handlers) HandleCreateUpload(c *fiber.Ctx) error {
+	streamID := c.Params("id")
+	userID, _ := c.Locals("user_id").(string)
+
+	recording, err := h.StreamService.GetStream(c.Context(), streamID, userID)
+	if err != nil {
+		return streamErrorResponse(c, err)
+	}
+	if recording.UserID != userID {
+		return streamErrorResponse(c, stream.ErrForbidden)
+	}
+	if err := recording.CheckStarted(); err != nil {
+		return streamErrorResponse(c, err)
+	}
+
+	var req CreateUploadRequest
+	if length := c.Get("Upload-Length"); length != "" {
+		size, err := strconv.ParseInt(length, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid Upload-Length"})
+		}
+		req.Size = size
+		req.ContentType = uploadMetadata(c.Get("Upload-Metadata"))["filetype"]
+	} else if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+	}
+
+	created, err := h.UploadService.Create(c.Context(), recording.ID, userID, req.Size, req.ContentType)
+	if err != nil {
+		return uploadErrorResponse(c, err)
+	}
+
+	setUploadHeaders(c, created)
+	c.Location(c.Path() + "/" + created.ID.String())
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"upload":         created,
+		"max_chunk_size": h.UploadService.Config().MaxChunkSize,
+	})
+}
+
+// HandleUploadOffset reports how much of an upload is stored (tus HEAD)
+func (h *Handlers) HandleUploadOffset(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	found, err := h.UploadService.Get(c.Context(), c.Params("upload_id"), c.Params("id"), userID)
+	if err != nil {
+		return uploadErrorResponse(c, err)
+	}
+
+	setUploadHeaders(c, found)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// HandleGetUpload returns an upload's state
+func (h *Handlers) HandleGetUpload(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	found, err := h.UploadService.Get(c.Context(), c.Params("upload_id"), c.Params("id"), userID)
+	if err != nil {
+		return uploadErrorResponse(c, err)
+	}
+
+	setUploadHeaders(c, found)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	return c.JSON(found)
+}
+
+// HandleUploadChunk stores the next chunk of an upload (tus PATCH). The
+// Upload-Offset header must match the stored offset; an Upload-Checksum
+// header ("sha256 <base64>") is verified before anything is written.
+func (h *Handlers) HandleUploadChunk(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Upload-Offset header required"})
+	}
+
+	updated, err := h.UploadService.WriteChunk(
+		c.Context(),
+		c.Params("upload_id"),
+		c.Params("id"),
+		userID,
+		offset,
+		c.Body(),
+		c.Get("Upload-Checksum"),
+	)
+	if err != nil {
+		return uploadErrorResponse(c, err)
+	}
+
+	setUploadHeaders(c, updated)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// setUploadHeaders sets the tus headers describing an upload
+func setUploadHeaders(c *fiber.Ctx, u *upload.Upload) {
+	c.Set("Tus-Resumable", tusVersion)
+	c.Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	c.Set("Upload-Length", strconv.FormatInt(u.Size, 10))
+	c.Set("Upload-Expires", u.ExpiresAt.UTC().Format(time.RFC1123))
+}
+
+// uploadMetadata decodes a tus Upload-Metadata header ("key base64,key2 base64")
+func uploadMetadata(header string) map[string]string {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(value)
+	}
+	return metadata
+}
+
+// uploadErrorResponse maps upload service errors to HTTP statuses
+func uploadErrorResponse(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	switch {
+	case errors.Is(err, upload.ErrNotFound):
+		status = fiber.StatusNotFound
+	case errors.Is(err, upload.ErrExpired):
+		status = fiber.StatusGone
+	case errors.Is(err, upload.ErrInvalidUpload), errors.Is(err, upload.ErrUnsupportedHash):
+		status = fiber.StatusBadRequest
+	case errors.Is(err, upload.ErrUnsupportedContent):
+		status = fiber.StatusUnsupportedMediaType
+	case errors.Is(err, upload.ErrTooLarge), errors.Is(err, upload.ErrChunkTooLarge):
+		status = fiber.StatusRequestEntityTooLarge
+	case errors.Is(err, upload.ErrOffsetMismatch), errors.Is(err, upload.ErrNotComplete), errors.Is(err, upload.ErrAlreadyConsumed):
+		status = fiber.StatusConflict
+	case errors.Is(err, upload.ErrChecksumMismatch):
+		status = statusChecksumMismatch
+	}
+
+	c.Set("Tus-Resumable", tusVersion)
+	return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+}
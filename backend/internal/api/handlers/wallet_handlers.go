@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/alexcolls/now.ink/backend/internal/models"
+	"github.com/alexcolls/now.ink/backend/internal/services/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+// HandleLinkWalletNonce issues a message for another wallet to sign so it can
+// be linked to the current account
+func (h *Handlers) HandleLinkWalletNonce(c *fiber.Ctx) error {
+	walletAddress, ok := c.Locals("wallet_address").(string)
+	if !ok || walletAddress == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	account, err := h.UserService.GetUserByWallet(walletAddress)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	var req models.GetNonceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+	}
+
+	if req.WalletAddress == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "wallet_address required"})
+	}
+
+	session, message, err := h.UserService.GenerateLinkNonce(account.ID, req.WalletAddress)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to generate nonce"})
+	}
+
+	return c.JSON(models.GetNonceResponse{
+		Nonce:     session.Nonce,
+		Message:   session.Message,
+		Fields:    *message,
+		ExpiresAt: session.ExpiresAt,
+	})
+}
+
+// HandleLinkWallet links another wallet after verifying its signature
+func (h *Handlers) HandleLinkWallet(c *fiber.Ctx) error {
+	walletAddress, ok := c.Locals("wallet_address").(string)
+	if !ok || walletAddress == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	account, err := h.UserService.GetUserByWallet(walletAddress)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	var req models.LinkWalletRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request"})
+	}
+
+	if req.WalletAddress == "" || req.Signature == "" || (req.Nonce == "" && req.Message == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "wallet_address, signature, and nonce or message required"})
+	}
+
+	wallet, err := h.UserService.LinkWallet(c.Context(), account.ID, &req)
+	switch {
+	case errors.Is(err, user.ErrInvalidNonce):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid or expired nonce"})
+	case errors.Is(err, user.ErrInvalidMessage):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, user.ErrInvalidSignature):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid signature"})
+	case errors.Is(err, user.ErrWalletLinkedElsewhere):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to link wallet"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(wallet)
+}
+
+// HandleListWallets lists the current user's linked wallets
+func (h *Handlers) HandleListWallets(c *fiber.Ctx) error {
+	walletAddress, ok := c.Locals("wallet_address").(string)
+	if !ok || walletAddress == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	account, err := h.UserService.GetUserByWallet(walletAddress)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	wallets, err := h.UserService.ListWallets(c.Context(), account.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"wallets": wallets,
+		"count":   len(wallets),
+	})
+}
+
+// HandleSetPrimaryWallet chooses which linked wallet NFTs are minted to
+func (h *Handlers) HandleSetPrimaryWallet(c *fiber.Ctx) error {
+	walletAddress, ok := c.Locals("wallet_address").(string)
+	if !ok || walletAddress == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	account, err := h.UserService.GetUserByWallet(walletAddress)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	target := c.Params("wallet_address")
+	err = h.UserService.SetPrimaryWallet(c.Context(), account.ID, target)
+	switch {
+	case errors.Is(err, user.ErrWalletNotLinked):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to set primary wallet"})
+	}
+
+	return c.JSON(fiber.Map{
+		"wallet_address": target,
+		"is_primary":     true,
+	})
+}
+
+// HandleUnlinkWallet removes a linked wallet from the current account
+func (h *Handlers) HandleUnlinkWallet(c *fiber.Ctx) error {
+	walletAddress, ok := c.Locals("wallet_address").(string)
+	if !ok || walletAddress == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "unauthorized"})
+	}
+
+	account, err := h.UserService.GetUserByWallet(walletAddress)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "user not found"})
+	}
+
+	target := c.Params("wallet_address")
+	err = h.UserService.UnlinkWallet(c.Context(), account.ID, target)
+	switch {
+	case errors.Is(err, user.ErrWalletNotLinked):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, user.ErrPrimaryWallet):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to unlink wallet"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "wallet unlinked",
+	})
+}
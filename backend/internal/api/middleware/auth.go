@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
@@ -8,56 +9,96 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 // JWTClaims represents the JWT token claims
 type JWTClaims struct {
-	WalletAddress string `json:"wallet_address"`
+	WalletAddress string   `json:"wallet_address"`
+	SessionID     string   `json:"sid"`
+	Role          string   `json:"role"`
+	Scopes        []string `json:"scopes"`
 	jwt.RegisteredClaims
 }
 
-// JWTSecret returns the JWT secret from environment
-func JWTSecret() string {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		return "change_this_jwt_secret_in_production"
-	}
-	return secret
+// TokenSubject describes who an access token is issued to and what it may do
+type TokenSubject struct {
+	UserID        string
+	WalletAddress string
+	SessionID     string
+	Role          string
+	Scopes        []string
+}
+
+// RevocationCheck reports whether the login session behind a token was
+// revoked. It runs on every authenticated request, so implementations may also
+// record the session as recently seen.
+type RevocationCheck func(ctx context.Context, sessionID string) (bool, error)
+
+var revocationCheck RevocationCheck
+
+// SetRevocationCheck installs the check AuthRequired runs on every request
+func SetRevocationCheck(check RevocationCheck) {
+	revocationCheck = check
 }
 
-// GenerateToken creates a new JWT token for a wallet address
-func GenerateToken(walletAddress string) (string, error) {
+// GenerateToken creates a new short-lived access token for a wallet login,
+// bound to the login session (refresh token family) it was issued for
+func GenerateToken(subject TokenSubject) (string, time.Time, error) {
 	expiryStr := os.Getenv("JWT_EXPIRY")
 	if expiryStr == "" {
-		expiryStr = "24h"
+		expiryStr = "15m"
 	}
-	
+
 	expiry, err := time.ParseDuration(expiryStr)
 	if err != nil {
-		expiry = 24 * time.Hour
+		expiry = 15 * time.Minute
 	}
 
+	now := time.Now()
+	expiresAt := now.Add(expiry)
+
 	claims := JWTClaims{
-		WalletAddress: walletAddress,
+		WalletAddress: subject.WalletAddress,
+		SessionID:     subject.SessionID,
+		Role:          subject.Role,
+		Scopes:        subject.Scopes,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        uuid.New().String(),
+			Subject:   subject.UserID,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
 			Issuer:    "now.ink",
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(JWTSecret()))
+	if keys == nil {
+		return "", time.Time{}, fmt.Errorf("signing keys not loaded")
+	}
+
+	token := jwt.NewWithClaims(keys.SigningKey.Method, claims)
+	token.Header["kid"] = keys.SigningKey.ID
+	signed, err := token.SignedString(keys.Private)
+	return signed, expiresAt, err
 }
 
 // ValidateToken validates a JWT token and returns the claims
 func ValidateToken(tokenString string) (*JWTClaims, error) {
+	if keys == nil {
+		return nil, fmt.Errorf("signing keys not loaded")
+	}
+
 	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys.Verify[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(JWTSecret()), nil
-	})
+		return key.Public, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodRS256.Alg()}))
 
 	if err != nil {
 		return nil, err
@@ -70,9 +111,14 @@ func ValidateToken(tokenString string) (*JWTClaims, error) {
 	return nil, fmt.Errorf("invalid token")
 }
 
-// AuthRequired is a middleware that requires a valid JWT token
+// AuthRequired is a middleware that requires a valid JWT token (or an API
+// key already accepted by APIKeyAuth)
 func AuthRequired() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if authenticatedByAPIKey(c) {
+			return c.Next()
+		}
+
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -88,34 +134,94 @@ func AuthRequired() fiber.Handler {
 			})
 		}
 
-		claims, err := ValidateToken(parts[1])
-		if err != nil {
+		return authenticate(c, parts[1])
+	}
+}
+
+// WebSocketAuth is AuthRequired for WebSocket handshakes. Browsers can't set
+// headers on a WebSocket, so the token may also be passed as ?token=.
+func WebSocketAuth() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if authenticatedByAPIKey(c) {
+			return c.Next()
+		}
+
+		token := c.Query("token")
+		if token == "" {
+			token = strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
+		}
+		if token == "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid or expired token",
+				"error": "Missing access token",
 			})
 		}
 
-		// Store wallet address in context
-		c.Locals("wallet_address", claims.WalletAddress)
-		return c.Next()
+		return authenticate(c, token)
 	}
 }
 
+// authenticate validates an access token, rejects revoked ones and stores the
+// identity in context before continuing the chain
+func authenticate(c *fiber.Ctx, token string) error {
+	claims, err := ValidateToken(token)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired token",
+		})
+	}
+
+	if revoked, err := isRevoked(c.Context(), claims); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to check token",
+		})
+	} else if revoked {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Token has been revoked",
+		})
+	}
+
+	// Store identity, session and permissions in context
+	setClaimsLocals(c, claims)
+	return c.Next()
+}
+
 // OptionalAuth is a middleware that validates JWT if present but doesn't require it
 func OptionalAuth() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
-		if authHeader == "" {
+		if authHeader == "" || authenticatedByAPIKey(c) {
 			return c.Next()
 		}
 
 		parts := strings.Split(authHeader, " ")
 		if len(parts) == 2 && parts[0] == "Bearer" {
 			if claims, err := ValidateToken(parts[1]); err == nil {
-				c.Locals("wallet_address", claims.WalletAddress)
+				if revoked, err := isRevoked(c.Context(), claims); err == nil && !revoked {
+					setClaimsLocals(c, claims)
+				}
 			}
 		}
 
 		return c.Next()
 	}
 }
+
+// isRevoked runs the installed revocation check. Tokens that are not bound to
+// a login session predate refresh tokens and are treated as revoked.
+func isRevoked(ctx context.Context, claims *JWTClaims) (bool, error) {
+	if claims.SessionID == "" {
+		return true, nil
+	}
+	if revocationCheck == nil {
+		return false, nil
+	}
+	return revocationCheck(ctx, claims.SessionID)
+}
+
+func setClaimsLocals(c *fiber.Ctx, claims *JWTClaims) {
+	c.Locals("wallet_address", claims.WalletAddress)
+	c.Locals("user_id", claims.Subject)
+	c.Locals("session_id", claims.SessionID)
+	c.Locals("role", claims.Role)
+	c.Locals("scopes", claims.Scopes)
+}
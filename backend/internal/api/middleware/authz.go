@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireRole only lets requests through whose token carries one of roles.
+// It must run after AuthRequired.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, _ := c.Locals("role").(string)
+		if !slices.Contains(roles, role) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Insufficient role",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// RequireScope only lets requests through whose token carries every one of
+// scopes. It must run after AuthRequired.
+func RequireScope(scopes ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		granted, _ := c.Locals("scopes").([]string)
+		for _, scope := range scopes {
+			if !slices.Contains(granted, scope) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error":          "Insufficient scope",
+					"required_scope": scope,
+				})
+			}
+		}
+		return c.Next()
+	}
+}
+
+// RequireSession only lets requests through that were authenticated with a
+// wallet login, keeping API keys away from account management. It must run
+// after AuthRequired.
+func RequireSession() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if authenticatedByAPIKey(c) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "API keys cannot manage the account",
+			})
+		}
+		return c.Next()
+	}
+}
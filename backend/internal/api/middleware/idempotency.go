@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"mime/multipart"
+	"slices"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/idempotency"
+	"github.com/gofiber/fiber/v2"
+)
+
+// IdempotencyKeyHeader is the header clients send to make a request safe
+// to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds client supplied keys
+const maxIdempotencyKeyLength = 255
+
+var idempotencyStore *idempotency.Store
+
+// SetIdempotencyStore installs the store Idempotency keeps responses in
+func SetIdempotencyStore(store *idempotency.Store) {
+	idempotencyStore = store
+}
+
+// Idempotency replays the stored response when a request is retried with
+// the same Idempotency-Key, instead of running it again. Keys belong to the
+// caller and the route; reusing one for a different request is rejected.
+// Server errors are not stored, so those can be retried with the same key.
+// Requests without the header, or with no store installed, pass through.
+func Idempotency() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IdempotencyKeyHeader)
+		if key == "" || idempotencyStore == nil {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Idempotency-Key too long"})
+		}
+
+		scope := ClientKey(c) + ":" + c.Method() + " " + c.Path()
+
+		stored, err := idempotencyStore.Begin(c.Context(), scope, key, requestHash(c))
+		switch {
+		case errors.Is(err, idempotency.ErrKeyReused):
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, idempotency.ErrInProgress):
+			c.Set(fiber.HeaderRetryAfter, "1")
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			log.Printf("⚠️  Idempotency store error: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to check idempotency key"})
+		case stored != nil:
+			c.Set("Idempotent-Replayed", "true")
+			if stored.Location != "" {
+				c.Location(stored.Location)
+			}
+			c.Set(fiber.HeaderContentType, stored.ContentType)
+			return c.Status(stored.StatusCode).Send(stored.Body)
+		}
+
+		handlerErr := c.Next()
+
+		// Record the outcome even if the server is shutting down
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		status := c.Response().StatusCode()
+		if handlerErr != nil || status >= fiber.StatusInternalServerError {
+			if err := idempotencyStore.Release(ctx, scope, key); err != nil {
+				log.Printf("⚠️  Failed to release idempotency key: %v", err)
+			}
+			return handlerErr
+		}
+
+		err = idempotencyStore.Complete(ctx, scope, key, &idempotency.Response{
+			StatusCode:  status,
+			ContentType: string(c.Response().Header.ContentType()),
+			Location:    string(c.Response().Header.Peek(fiber.HeaderLocation)),
+			Body:        append([]byte(nil), c.Response().Body()...),
+		})
+		if err != nil {
+			log.Printf("⚠️  Failed to store idempotent response: %v", err)
+		}
+
+		return nil
+	}
+}
+
+// requestHash fingerprints a request body so a key reused for a different
+// request can be told apart from a retry. Multipart bodies are hashed by
+// their parsed fields and file contents, since clients pick a new boundary
+// every time they send one.
+func requestHash(c *fiber.Ctx) string {
+	hash := sha256.New()
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		hash.Write([]byte(c.Get(fiber.HeaderContentType)))
+		hash.Write([]byte{0})
+		hash.Write(c.Body())
+		return hex.EncodeToString(hash.Sum(nil))
+	}
+
+	hash.Write([]byte(fiber.MIMEMultipartForm))
+	for _, name := range slices.Sorted(maps.Keys(form.Value)) {
+		for _, value := range form.Value[name] {
+			fmt.Fprintf(hash, "\x00field %q %q", name, value)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(form.File)) {
+		for _, file := range form.File[name] {
+			fmt.Fprintf(hash, "\x00file %q %q %d ", name, file.Filename, file.Size)
+			hash.Write(fileDigest(file))
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// fileDigest is the SHA-256 of an uploaded file part, or nil if it can't be
+// read (the handler reports that)
+func fileDigest(file *multipart.FileHeader) []byte {
+	f, err := file.Open()
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil
+	}
+	return hash.Sum(nil)
+}
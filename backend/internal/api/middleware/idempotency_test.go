@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// multipartBody encodes a form with one field and one file under boundary
+func multipartBody(t *testing.T, boundary, title, video string) (string, []byte) {
+	t.Helper()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err := w.SetBoundary(boundary); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteField("title", title); err != nil {
+		t.Fatal(err)
+	}
+	part, err := w.CreateFormFile("video", "clip.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(video))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return w.FormDataContentType(), body.Bytes()
+}
+
+func TestRequestHash(t *testing.T) {
+	app := fiber.New()
+	app.Post("/", func(c *fiber.Ctx) error {
+		return c.SendString(requestHash(c))
+	})
+
+	hashOf := func(contentType string, body []byte) string {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, contentType)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := io.ReadAll(resp.Body)
+		return string(out)
+	}
+
+	first := hashOf(multipartBody(t, "boundary-one", "sunset", "frames"))
+	retry := hashOf(multipartBody(t, "boundary-two", "sunset", "frames"))
+	if first != retry {
+		t.Error("a multipart retry with a new boundary should hash the same")
+	}
+	if other := hashOf(multipartBody(t, "boundary-one", "sunset", "other frames")); other == first {
+		t.Error("a different file should hash differently")
+	}
+	if other := hashOf(multipartBody(t, "boundary-one", "sunrise", "frames")); other == first {
+		t.Error("a different field should hash differently")
+	}
+
+	json := hashOf(fiber.MIMEApplicationJSON, []byte(`{"title":"sunset"}`))
+	if json == hashOf(fiber.MIMEApplicationJSON, []byte(`{"title":"sunrise"}`)) {
+		t.Error("different JSON bodies should hash differently")
+	}
+}
@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey is a key used to sign or verify access tokens
+type signingKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Public crypto.PublicKey
+}
+
+// keySet holds the current signing key and every key tokens may still be
+// verified with. Retired keys stay in Verify until all tokens they signed
+// have expired, so keys can rotate without logging anyone out.
+type keySet struct {
+	SigningKey signingKey
+	Private    crypto.PrivateKey
+	Verify     map[string]signingKey
+}
+
+var keys *keySet
+
+// LoadSigningKeys loads the JWT keys from the environment:
+//
+//	JWT_SIGNING_KEY_FILE        PKCS#8 PEM private key (Ed25519 or RSA) used to sign tokens
+//	JWT_VERIFICATION_KEY_FILES  comma-separated PKIX PEM public keys of retired signing keys,
+//	                            or directories whose .pem files are all loaded
+//
+// Without a signing key an ephemeral Ed25519 key is generated, unless
+// ENV=production, in which case an error is returned.
+func LoadSigningKeys() error {
+	set := &keySet{Verify: map[string]signingKey{}}
+
+	if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+		private, err := readPrivateKey(path)
+		if err != nil {
+			return fmt.Errorf("failed to load JWT signing key: %w", err)
+		}
+		set.Private = private
+	} else {
+		if os.Getenv("ENV") == "production" {
+			return errors.New("JWT_SIGNING_KEY_FILE must be set in production")
+		}
+
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to generate JWT signing key: %w", err)
+		}
+		set.Private = private
+		log.Println("⚠️  No JWT signing key configured, using an ephemeral key (tokens won't survive a restart)")
+	}
+
+	signer, ok := set.Private.(crypto.Signer)
+	if !ok {
+		return errors.New("JWT signing key cannot sign")
+	}
+	key, err := newSigningKey(signer.Public())
+	if err != nil {
+		return err
+	}
+	set.SigningKey = key
+	set.Verify[key.ID] = key
+
+	paths, err := verificationKeyFiles()
+	if err != nil {
+		return fmt.Errorf("failed to list JWT verification keys: %w", err)
+	}
+	for _, path := range paths {
+		public, err := readPublicKey(path)
+		if err != nil {
+			return fmt.Errorf("failed to load JWT verification key %s: %w", path, err)
+		}
+		key, err := newSigningKey(public)
+		if err != nil {
+			return err
+		}
+		set.Verify[key.ID] = key
+	}
+
+	keys = set
+	log.Printf("🔑 JWT signing key %s (%s), %d verification key(s)", set.SigningKey.ID, set.SigningKey.Method.Alg(), len(set.Verify))
+	return nil
+}
+
+// verificationKeyFiles expands JWT_VERIFICATION_KEY_FILES into key files, so
+// a mounted directory of retired keys can be listed as a whole
+func verificationKeyFiles() ([]string, error) {
+	files := []string{}
+	for _, path := range strings.Split(os.Getenv("JWT_VERIFICATION_KEY_FILES"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(path, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// newSigningKey picks the JWT algorithm for a public key and derives its kid
+func newSigningKey(public crypto.PublicKey) (signingKey, error) {
+	var method jwt.SigningMethod
+	switch public.(type) {
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	default:
+		return signingKey{}, fmt.Errorf("unsupported JWT key type %T", public)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return signingKey{}, err
+	}
+	sum := sha256.Sum256(der)
+
+	return signingKey{
+		ID:     hex.EncodeToString(sum[:8]),
+		Method: method,
+		Public: public,
+	}, nil
+}
+
+func readPrivateKey(path string) (crypto.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	return block, nil
+}
+
+// JWK is a single JSON Web Key
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKS serves the public verification keys as a JSON Web Key Set so other
+// services can verify access tokens without holding any secret
+func JWKS(c *fiber.Ctx) error {
+	if keys == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "signing keys not loaded"})
+	}
+
+	set := []JWK{}
+	for _, key := range keys.Verify {
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+
+		switch public := key.Public.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		}
+
+		set = append(set, jwk)
+	}
+
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(fiber.Map{"keys": set})
+}
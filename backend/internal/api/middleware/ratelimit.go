@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/ratelimit"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RateLimitPolicy limits how often one client may hit a group of routes
+type RateLimitPolicy struct {
+	// Name separates the counters of different policies
+	Name   string
+	Limit  int
+	Window time.Duration
+	// Key identifies the client; defaults to ClientKey
+	Key func(c *fiber.Ctx) string
+}
+
+var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+
+// SetRateLimitStore replaces the default in-memory store, e.g. with a shared
+// store when running several API instances
+func SetRateLimitStore(store ratelimit.Store) {
+	rateLimitStore = store
+}
+
+// ClientKey identifies the caller by API key, then wallet, then IP address.
+// Wallet and API key are only known after APIKeyAuth/AuthRequired ran.
+func ClientKey(c *fiber.Ctx) string {
+	if keyID, _ := c.Locals("api_key_id").(string); keyID != "" {
+		return "key:" + keyID
+	}
+	if wallet, _ := c.Locals("wallet_address").(string); wallet != "" {
+		return "wallet:" + wallet
+	}
+	return "ip:" + c.IP()
+}
+
+// RateLimit enforces policy and reports usage in the standard RateLimit-*
+// headers, with Retry-After on 429 responses. If the store fails the request
+// is let through rather than taking the API down.
+func RateLimit(policy RateLimitPolicy) fiber.Handler {
+	keyFunc := policy.Key
+	if keyFunc == nil {
+		keyFunc = ClientKey
+	}
+
+	return func(c *fiber.Ctx) error {
+		key := keyFunc(c)
+		if key == "" {
+			return c.Next()
+		}
+
+		allowed, err := checkRateLimit(c, policy.Name+":"+key, policy.Limit, policy.Window)
+		if err != nil {
+			log.Printf("⚠️  Rate limit store error (%s): %v", policy.Name, err)
+			return c.Next()
+		}
+		if !allowed {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Rate limit exceeded",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// checkRateLimit counts one hit, sets the RateLimit-* headers and reports
+// whether the request is within limit
+func checkRateLimit(c *fiber.Ctx, key string, limit int, window time.Duration) (bool, error) {
+	count, resetAt, err := rateLimitStore.Increment(c.Context(), key, window)
+	if err != nil {
+		return true, err
+	}
+
+	remaining := limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	reset := int(time.Until(resetAt).Seconds() + 0.999)
+	if reset < 0 {
+		reset = 0
+	}
+
+	c.Set("RateLimit-Limit", strconv.Itoa(limit))
+	c.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Set("RateLimit-Reset", strconv.Itoa(reset))
+	c.Set("RateLimit-Policy", strconv.Itoa(limit)+";w="+strconv.Itoa(int(window.Seconds())))
+
+	if count > limit {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(reset))
+		return false, nil
+	}
+
+	return true, nil
+}
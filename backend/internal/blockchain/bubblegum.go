@@ -0,0 +1,527 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// Program IDs used for compressed NFTs
+var (
+	BubblegumProgramID          = solana.MustPublicKeyFromBase58("BGUMAp9Gq7iTEuizy4pqaxsTyUCBK68MDfK752saRPUY")
+	AccountCompressionProgramID = solana.MustPublicKeyFromBase58("cmtDvXumGCrqC1Age74AVPhSRVXJMd8PJS91L8KbNCK")
+	NoopProgramID               = solana.MustPublicKeyFromBase58("noopb9bkMVfRPU8AsbpTUg8AQkHtKwMYZiFUjNRtMmV")
+)
+
+// Mint modes
+const (
+	MintModeStandard   = "standard"
+	MintModeCompressed = "compressed"
+)
+
+// Bubblegum instructions are Anchor instructions, identified by the hash of
+// their name
+var (
+	createTreeDiscriminator = anchorDiscriminator("create_tree")
+	mintV1Discriminator     = anchorDiscriminator("mint_v1")
+)
+
+// ErrNoTreeStore means compressed minting was enabled without a place to
+// keep track of the platform's Merkle trees
+var ErrNoTreeStore = errors.New("merkle tree store not configured")
+
+// validTreeSizes are the (max depth, max buffer size) pairs the account
+// compression program accepts
+var validTreeSizes = map[[2]uint32]bool{
+	{3, 8}: true, {5, 8}: true,
+	{14, 64}: true, {14, 256}: true, {14, 1024}: true, {14, 2048}: true,
+	{15, 64}: true, {16, 64}: true, {17, 64}: true, {18, 64}: true, {19, 64}: true,
+	{20, 64}: true, {20, 256}: true, {20, 1024}: true, {20, 2048}: true,
+	{24, 64}: true, {24, 256}: true, {24, 512}: true, {24, 1024}: true, {24, 2048}: true,
+	{26, 512}: true, {26, 1024}: true, {26, 2048}: true,
+	{30, 512}: true, {30, 1024}: true, {30, 2048}: true,
+}
+
+// treeConfig sizes new Merkle trees. A tree holds 2^maxDepth NFTs; a larger
+// canopy costs more rent up front but keeps later transfers small.
+type treeConfig struct {
+	maxDepth      uint32
+	maxBufferSize uint32
+	canopyDepth   uint32
+}
+
+func loadTreeConfig() treeConfig {
+	cfg := treeConfig{maxDepth: 14, maxBufferSize: 64, canopyDepth: 10}
+
+	depth, depthErr := strconv.ParseUint(os.Getenv("MINT_TREE_MAX_DEPTH"), 10, 32)
+	buffer, bufferErr := strconv.ParseUint(os.Getenv("MINT_TREE_MAX_BUFFER_SIZE"), 10, 32)
+	if depthErr == nil && bufferErr == nil {
+		if validTreeSizes[[2]uint32{uint32(depth), uint32(buffer)}] {
+			cfg.maxDepth, cfg.maxBufferSize = uint32(depth), uint32(buffer)
+		} else {
+			log.Printf("⚠️  Unsupported Merkle tree size %d/%d, using %d/%d", depth, buffer, cfg.maxDepth, cfg.maxBufferSize)
+		}
+	}
+	if canopy, err := strconv.ParseUint(os.Getenv("MINT_TREE_CANOPY_DEPTH"), 10, 32); err == nil {
+		cfg.canopyDepth = uint32(canopy)
+	}
+	if cfg.canopyDepth >= cfg.maxDepth {
+		cfg.canopyDepth = cfg.maxDepth - 1
+	}
+
+	return cfg
+}
+
+// MerkleTree is a platform owned tree compressed NFTs are minted into
+type MerkleTree struct {
+	Address       string
+	MaxDepth      uint32
+	MaxBufferSize uint32
+	CanopyDepth   uint32
+	// Signature is the transaction that created the tree
+	Signature string
+}
+
+// Capacity is how many NFTs fit in the tree
+func (t *MerkleTree) Capacity() uint64 {
+	return 1 << t.MaxDepth
+}
+
+// TreeStore keeps track of the platform's Merkle trees. It is shared by
+// every API instance, so they fill the same tree.
+type TreeStore interface {
+	// ActiveTree returns the tree new mints go into, or nil if there is none
+	ActiveTree(ctx context.Context) (*MerkleTree, error)
+	// AddTree records a newly created tree as the active one
+	AddTree(ctx context.Context, tree *MerkleTree) error
+	// RetireTree stops minting into a full tree
+	RetireTree(ctx context.Context, address string) error
+	// LockTrees keeps other instances from creating a tree at the same
+	// time; call unlock when done
+	LockTrees(ctx context.Context) (unlock func(), err error)
+}
+
+// SetTreeStore installs the store used in compressed mode
+func (s *SolanaClient) SetTreeStore(store TreeStore) {
+	s.trees = store
+}
+
+// mintCompressed mints a compressed NFT to the creator's wallet in the
+// active Merkle tree, creating a new tree when there is none or the last
+// one is full. A pending mint is resumed, and once it landed only its leaf
+// is looked up; mint_v1 is only sent again once it provably didn't land.
+func (s *SolanaClient) mintCompressed(ctx context.Context, opts MintOptions) (*MintResult, error) {
+	payer := s.minting.payer
+	if payer == nil {
+		return nil, ErrNoPayer
+	}
+	if s.trees == nil {
+		return nil, ErrNoTreeStore
+	}
+	if len(opts.MetadataURI) > maxURILength {
+		return nil, fmt.Errorf("metadata URI longer than %d bytes", maxURILength)
+	}
+
+	owner, err := solana.PublicKeyFromBase58(opts.CreatorWallet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid creator wallet: %w", err)
+	}
+
+	if opts.Pending != nil {
+		sent, err := s.resumeTransaction(ctx, opts.Pending, solana.PublicKey{})
+		if err == nil {
+			tree, err := solana.PublicKeyFromBase58(opts.Pending.MerkleTree)
+			if err != nil {
+				return nil, fmt.Errorf("invalid saved merkle tree: %w", err)
+			}
+			return s.compressedResult(ctx, tree, owner, sent, opts)
+		}
+		if !errors.Is(err, ErrMintNotLanded) || opts.ResumeOnly {
+			return nil, err
+		}
+		log.Printf("🔁 %v, minting again", err)
+	}
+
+	tree, err := s.activeTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+	treeAddress := solana.MustPublicKeyFromBase58(tree.Address)
+
+	creators := []metadataCreator{
+		{Address: payer.PublicKey(), Verified: true, Share: 5},
+		{Address: owner, Share: 95},
+	}
+	if owner == payer.PublicKey() {
+		creators = []metadataCreator{{Address: owner, Verified: true, Share: 100}}
+	}
+
+	instructions := append(budgetInstructions(s.minting),
+		newMintV1Instruction(treeAddress, owner, payer.PublicKey(), metadataData{
+			Name:     truncateUTF8(opts.Title, maxNameLength),
+			Symbol:   nftSymbol,
+			URI:      opts.MetadataURI,
+			Creators: creators,
+		}),
+	)
+
+	sent, err := s.sendAndConfirm(ctx, instructions, opts.saveBeforeSend(ctx, PendingMint{MerkleTree: tree.Address}), payer)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.compressedResult(ctx, treeAddress, owner, sent, opts)
+}
+
+// compressedResult describes a compressed mint whose transaction reached
+// the commitment. If its leaf can't be found, the error leaves the mint
+// pending, so a retry only looks the leaf up again.
+func (s *SolanaClient) compressedResult(ctx context.Context, tree, owner solana.PublicKey, sent *sentTransaction, opts MintOptions) (*MintResult, error) {
+	// The leaf index is only known once the mint landed; it is logged by
+	// Bubblegum through the noop program
+	assetID, leafIndex, err := s.findMintedLeaf(ctx, sent.signature, tree, owner)
+	if err != nil {
+		return nil, fmt.Errorf("compressed NFT minted in %s but not found: %w", sent.signature, err)
+	}
+
+	log.Printf("✅ Compressed NFT minted: %s (tree %s, leaf %d)", assetID, tree, leafIndex)
+
+	return &MintResult{
+		MintAddress:          assetID.String(),
+		MetadataURI:          opts.MetadataURI,
+		ArweaveTxID:          opts.ArweaveTxID,
+		Signature:            sent.signature.String(),
+		LastValidBlockHeight: sent.lastValidBlockHeight,
+		Confirmation:         sent.status,
+		Compressed:           true,
+		MerkleTree:           tree.String(),
+		LeafIndex:            leafIndex,
+		Status:               "minted",
+		Network:              s.network,
+	}, nil
+}
+
+// activeTree returns a tree with room for another NFT
+func (s *SolanaClient) activeTree(ctx context.Context) (*MerkleTree, error) {
+	tree, err := s.usableTree(ctx)
+	if err != nil || tree != nil {
+		return tree, err
+	}
+
+	unlock, err := s.trees.LockTrees(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock merkle trees: %w", err)
+	}
+	defer unlock()
+
+	// Another instance may have created one while we waited
+	tree, err = s.usableTree(ctx)
+	if err != nil || tree != nil {
+		return tree, err
+	}
+
+	tree, err = s.createTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.trees.AddTree(ctx, tree); err != nil {
+		log.Printf("❌ Merkle tree %s was created but not recorded: %v", tree.Address, err)
+		return nil, fmt.Errorf("failed to record merkle tree: %w", err)
+	}
+
+	return tree, nil
+}
+
+// usableTree returns the active tree if it isn't full, retiring it if it is
+func (s *SolanaClient) usableTree(ctx context.Context) (*MerkleTree, error) {
+	tree, err := s.trees.ActiveTree(ctx)
+	if err != nil || tree == nil {
+		return nil, err
+	}
+
+	minted, capacity, err := s.treeUsage(ctx, solana.MustPublicKeyFromBase58(tree.Address))
+	if err != nil {
+		return nil, err
+	}
+	if minted < capacity {
+		return tree, nil
+	}
+
+	log.Printf("🌳 Merkle tree %s is full (%d NFTs), rolling over", tree.Address, minted)
+	return nil, s.trees.RetireTree(ctx, tree.Address)
+}
+
+// treeUsage reads how many NFTs were minted into a tree and how many fit
+// from its Bubblegum tree config account
+func (s *SolanaClient) treeUsage(ctx context.Context, tree solana.PublicKey) (minted, capacity uint64, err error) {
+	treeConfig, _, err := findTreeConfigAddress(tree)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	account, err := s.rpcClient.GetAccountInfoWithOpts(ctx, treeConfig, &rpc.GetAccountInfoOpts{
+		Commitment: s.minting.commitment,
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read tree config: %w", err)
+	}
+
+	// discriminator, tree_creator, tree_delegate, total_mint_capacity, num_minted
+	data := account.Value.Data.GetBinary()
+	if len(data) < 88 {
+		return 0, 0, fmt.Errorf("tree config %s too short", treeConfig)
+	}
+
+	capacity = binary.LittleEndian.Uint64(data[72:80])
+	minted = binary.LittleEndian.Uint64(data[80:88])
+	return minted, capacity, nil
+}
+
+// createTree allocates a Merkle tree account and sets it up in Bubblegum
+// with the platform as creator and only minter
+func (s *SolanaClient) createTree(ctx context.Context) (*MerkleTree, error) {
+	payer := s.minting.payer
+	cfg := s.minting.tree
+
+	treeKey, err := solana.NewRandomPrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate tree keypair: %w", err)
+	}
+
+	size := merkleTreeAccountSize(cfg.maxDepth, cfg.maxBufferSize, cfg.canopyDepth)
+	rent, err := s.rpcClient.GetMinimumBalanceForRentExemption(ctx, size, s.minting.commitment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree rent: %w", err)
+	}
+
+	log.Printf("🌳 Creating Merkle tree %s (depth %d, buffer %d, canopy %d, %d lamports)",
+		treeKey.PublicKey(), cfg.maxDepth, cfg.maxBufferSize, cfg.canopyDepth, rent)
+
+	instructions := append(budgetInstructions(s.minting),
+		system.NewCreateAccountInstruction(rent, size, AccountCompressionProgramID, payer.PublicKey(), treeKey.PublicKey()).Build(),
+		newCreateTreeInstruction(treeKey.PublicKey(), payer.PublicKey(), cfg),
+	)
+
+	sent, err := s.sendAndConfirm(ctx, instructions, nil, payer, treeKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create merkle tree: %w", err)
+	}
+
+	return &MerkleTree{
+		Address:       treeKey.PublicKey().String(),
+		MaxDepth:      cfg.maxDepth,
+		MaxBufferSize: cfg.maxBufferSize,
+		CanopyDepth:   cfg.canopyDepth,
+		Signature:     sent.signature.String(),
+	}, nil
+}
+
+// merkleTreeAccountSize is the size of a concurrent Merkle tree account:
+// the header, the change log buffer, the rightmost path and the canopy
+func merkleTreeAccountSize(maxDepth, maxBufferSize, canopyDepth uint32) uint64 {
+	const headerSize = 2 + 54
+	pathSize := uint64(40 + 32*maxDepth)      // proof, leaf, index, padding
+	changeLogSize := uint64(40 + 32*maxDepth) // root, path, index, padding
+	treeSize := 24 + uint64(maxBufferSize)*changeLogSize + pathSize
+	canopySize := ((uint64(1) << (canopyDepth + 1)) - 2) * 32
+	return headerSize + treeSize + canopySize
+}
+
+// findTreeConfigAddress derives the Bubblegum config account of a tree
+func findTreeConfigAddress(tree solana.PublicKey) (solana.PublicKey, uint8, error) {
+	return solana.FindProgramAddress([][]byte{tree[:]}, BubblegumProgramID)
+}
+
+// findAssetAddress derives the asset ID of the compressed NFT at a leaf
+func findAssetAddress(tree solana.PublicKey, leafIndex uint64) (solana.PublicKey, uint8, error) {
+	nonce := make([]byte, 8)
+	binary.LittleEndian.PutUint64(nonce, leafIndex)
+	return solana.FindProgramAddress([][]byte{[]byte("asset"), tree[:], nonce}, BubblegumProgramID)
+}
+
+// newCreateTreeInstruction builds Bubblegum's create_tree for a private
+// tree, so only the platform can mint into it
+func newCreateTreeInstruction(tree, payer solana.PublicKey, cfg treeConfig) solana.Instruction {
+	treeConfig, _, _ := findTreeConfigAddress(tree)
+
+	var buf bytes.Buffer
+	buf.Write(createTreeDiscriminator)
+	binary.Write(&buf, binary.LittleEndian, cfg.maxDepth)
+	binary.Write(&buf, binary.LittleEndian, cfg.maxBufferSize)
+	buf.Write([]byte{1, 0}) // public: Some(false)
+
+	accounts := solana.AccountMetaSlice{
+		solana.Meta(treeConfig).WRITE(),
+		solana.Meta(tree).WRITE(),
+		solana.Meta(payer).SIGNER().WRITE(), // payer
+		solana.Meta(payer).SIGNER(),         // tree creator
+		solana.Meta(NoopProgramID),
+		solana.Meta(AccountCompressionProgramID),
+		solana.Meta(solana.SystemProgramID),
+	}
+
+	return solana.NewInstruction(BubblegumProgramID, accounts, buf.Bytes())
+}
+
+// newMintV1Instruction builds Bubblegum's mint_v1, minting a compressed
+// NFT owned and delegated to owner
+func newMintV1Instruction(tree, owner, payer solana.PublicKey, data metadataData) solana.Instruction {
+	treeConfig, _, _ := findTreeConfigAddress(tree)
+
+	var buf bytes.Buffer
+	buf.Write(mintV1Discriminator)
+
+	// MetadataArgs
+	writeBorshString(&buf, data.Name)
+	writeBorshString(&buf, data.Symbol)
+	writeBorshString(&buf, data.URI)
+	binary.Write(&buf, binary.LittleEndian, uint16(sellerFeeBasisPoints))
+	writeBorshBool(&buf, false) // primary_sale_happened
+	writeBorshBool(&buf, true)  // is_mutable
+	buf.WriteByte(0)            // edition_nonce: None
+	buf.Write([]byte{1, 0})     // token_standard: Some(NonFungible)
+	buf.WriteByte(0)            // collection: None
+	buf.WriteByte(0)            // uses: None
+	buf.WriteByte(0)            // token_program_version: Original
+	binary.Write(&buf, binary.LittleEndian, uint32(len(data.Creators)))
+	for _, creator := range data.Creators {
+		buf.Write(creator.Address[:])
+		writeBorshBool(&buf, creator.Verified)
+		buf.WriteByte(creator.Share)
+	}
+
+	accounts := solana.AccountMetaSlice{
+		solana.Meta(treeConfig).WRITE(),
+		solana.Meta(owner), // leaf owner
+		solana.Meta(owner), // leaf delegate
+		solana.Meta(tree).WRITE(),
+		solana.Meta(payer).SIGNER().WRITE(), // payer
+		solana.Meta(payer).SIGNER(),         // tree delegate
+		solana.Meta(NoopProgramID),
+		solana.Meta(AccountCompressionProgramID),
+		solana.Meta(solana.SystemProgramID),
+	}
+
+	return solana.NewInstruction(BubblegumProgramID, accounts, buf.Bytes())
+}
+
+// findMintedLeaf looks up the leaf a mint_v1 transaction appended. The
+// transaction may take a moment to be served by the node, so it is polled
+// like the confirmation.
+func (s *SolanaClient) findMintedLeaf(ctx context.Context, signature solana.Signature, tree, owner solana.PublicKey) (solana.PublicKey, uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.minting.confirmTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(s.minting.pollInterval)
+	defer ticker.Stop()
+
+	maxVersion := uint64(0)
+	commitment := s.minting.commitment
+	if commitment == rpc.CommitmentProcessed {
+		// getTransaction doesn't serve processed transactions
+		commitment = rpc.CommitmentConfirmed
+	}
+
+	for {
+		out, err := s.rpcClient.GetTransaction(ctx, signature, &rpc.GetTransactionOpts{
+			Encoding:                       solana.EncodingBase64,
+			Commitment:                     commitment,
+			MaxSupportedTransactionVersion: &maxVersion,
+		})
+		if err == nil && out.Meta != nil {
+			for _, inner := range out.Meta.InnerInstructions {
+				for _, instruction := range inner.Instructions {
+					if assetID, leafIndex, ok := parseLeafSchemaEvent(instruction.Data, tree, owner); ok {
+						return assetID, leafIndex, nil
+					}
+				}
+			}
+			return solana.PublicKey{}, 0, errors.New("no leaf schema event in transaction")
+		}
+
+		select {
+		case <-ctx.Done():
+			return solana.PublicKey{}, 0, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseLeafSchemaEvent decodes the LeafSchemaEvent Bubblegum logs through
+// the noop program when it mints:
+//
+//	AccountCompressionEvent::ApplicationData (1), ApplicationDataEvent::V1 (0),
+//	u32 length, then BubblegumEventType::LeafSchemaEvent (1), Version::V1 (0),
+//	LeafSchema::V1 (0), id, owner, delegate, nonce, data_hash, creator_hash
+//
+// The asset ID in the event must be the one derived from the tree and nonce,
+// and the owner must match, so unrelated inner instructions are skipped.
+func parseLeafSchemaEvent(data []byte, tree, owner solana.PublicKey) (solana.PublicKey, uint64, bool) {
+	const prefix = 2 + 4 + 3
+	if len(data) < prefix+32*3+8 || data[0] != 1 || data[1] != 0 {
+		return solana.PublicKey{}, 0, false
+	}
+	event := data[6:]
+	if event[0] != 1 || event[1] != 0 || event[2] != 0 {
+		return solana.PublicKey{}, 0, false
+	}
+
+	schema := event[3:]
+	id := solana.PublicKeyFromBytes(schema[0:32])
+	leafOwner := solana.PublicKeyFromBytes(schema[32:64])
+	nonce := binary.LittleEndian.Uint64(schema[96:104])
+
+	if leafOwner != owner {
+		return solana.PublicKey{}, 0, false
+	}
+	if assetID, _, err := findAssetAddress(tree, nonce); err != nil || assetID != id {
+		return solana.PublicKey{}, 0, false
+	}
+
+	return id, nonce, true
+}
+
+func anchorDiscriminator(name string) []byte {
+	sum := sha256.Sum256([]byte("global:" + name))
+	return sum[:8]
+}
+
+// mockLeaves numbers compressed mints in mock mode
+var (
+	mockLeaves   atomic.Uint64
+	mockTreeOnce sync.Once
+	mockTree     string
+)
+
+// mockCompressedResult fakes a compressed mint for development
+func (s *SolanaClient) mockCompressedResult(opts MintOptions) *MintResult {
+	mockTreeOnce.Do(func() {
+		mockTree = fmt.Sprintf("MOCK_TREE_%d", time.Now().Unix())
+	})
+	leaf := mockLeaves.Add(1) - 1
+
+	return &MintResult{
+		MintAddress: fmt.Sprintf("MOCK_CNFT_%s_%d", opts.CreatorWallet[:min(8, len(opts.CreatorWallet))], time.Now().UnixNano()),
+		MetadataURI: opts.MetadataURI,
+		ArweaveTxID: opts.ArweaveTxID,
+		Compressed:  true,
+		MerkleTree:  mockTree,
+		LeafIndex:   leaf,
+		Status:      "minted",
+		Network:     s.network,
+	}
+}
@@ -0,0 +1,359 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// memTrees is an in-memory TreeStore
+type memTrees struct {
+	mu      sync.Mutex
+	creator sync.Mutex
+	trees   []*MerkleTree
+	retired map[string]bool
+}
+
+func (m *memTrees) ActiveTree(ctx context.Context) (*MerkleTree, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, tree := range m.trees {
+		if !m.retired[tree.Address] {
+			return tree, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memTrees) AddTree(ctx context.Context, tree *MerkleTree) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.trees = append(m.trees, tree)
+	return nil
+}
+
+func (m *memTrees) RetireTree(ctx context.Context, address string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.retired[address] = true
+	return nil
+}
+
+func (m *memTrees) LockTrees(ctx context.Context) (func(), error) {
+	m.creator.Lock()
+	return m.creator.Unlock, nil
+}
+
+// recordBubblegum assigns each mint_v1 sent the next leaf and remembers
+// the trees created; s.mu must be held
+func (s *rpcStandIn) recordBubblegum(tx *solana.Transaction) {
+	for _, instruction := range tx.Message.Instructions {
+		if tx.Message.AccountKeys[instruction.ProgramIDIndex] != BubblegumProgramID {
+			continue
+		}
+		accounts, _ := instruction.ResolveInstructionAccounts(&tx.Message)
+		switch {
+		case bytes.HasPrefix(instruction.Data, createTreeDiscriminator):
+			s.createdTrees[accounts[0].PublicKey.String()] = true
+		case bytes.HasPrefix(instruction.Data, mintV1Discriminator):
+			if _, ok := s.leaves[tx.Signatures[0]]; !ok {
+				s.leaves[tx.Signatures[0]] = s.nextLeaf
+				s.nextLeaf++
+			}
+		}
+	}
+}
+
+// treeConfigAccount answers getAccountInfo for a Bubblegum tree config
+func (s *rpcStandIn) treeConfigAccount(address string) string {
+	s.mu.Lock()
+	minted := s.treeMinted
+	if s.createdTrees[address] {
+		minted = 0
+	}
+	s.mu.Unlock()
+
+	data := make([]byte, 96)
+	binary.LittleEndian.PutUint64(data[72:], s.treeCapacity)
+	binary.LittleEndian.PutUint64(data[80:], minted)
+
+	return `{"context":{"slot":339010822},"value":{"data":["` + base64.StdEncoding.EncodeToString(data) +
+		`","base64"],"executable":false,"lamports":1566000,"owner":"` + BubblegumProgramID.String() +
+		`","rentEpoch":18446744073709551615,"space":96}}`
+}
+
+// mintTransaction answers getTransaction for a mint_v1 sent, with the leaf
+// schema event Bubblegum logs through the noop program
+func (s *rpcStandIn) mintTransaction(signature solana.Signature) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tx *solana.Transaction
+	for _, sent := range s.sent {
+		if sent.Signatures[0] == signature {
+			tx = sent
+		}
+	}
+	leaf, ok := s.leaves[signature]
+	if !ok || s.unserved {
+		return "null"
+	}
+
+	var tree, owner solana.PublicKey
+	for _, instruction := range tx.Message.Instructions {
+		if tx.Message.AccountKeys[instruction.ProgramIDIndex] == BubblegumProgramID {
+			accounts, _ := instruction.ResolveInstructionAccounts(&tx.Message)
+			owner, tree = accounts[1].PublicKey, accounts[3].PublicKey
+		}
+	}
+
+	assetID, _, _ := findAssetAddress(tree, leaf)
+	event := []byte{1, 0, 0, 0, 0, 0, 1, 0, 0}
+	event = append(event, assetID[:]...)
+	event = append(event, owner[:]...)
+	event = append(event, owner[:]...)
+	event = binary.LittleEndian.AppendUint64(event, leaf)
+	event = append(event, make([]byte, 96)...) // data, creator and leaf hashes
+	binary.LittleEndian.PutUint32(event[2:], uint32(len(event)-6))
+
+	encoded, _ := tx.MarshalBinary()
+	return `{"slot":339010824,"blockTime":1760659200,"transaction":["` + base64.StdEncoding.EncodeToString(encoded) +
+		`","base64"],"meta":{"err":null,"fee":5000,"preBalances":[],"postBalances":[],"logMessages":[],` +
+		`"preTokenBalances":[],"postTokenBalances":[],"status":{"Ok":null},"innerInstructions":[{"index":2,"instructions":[` +
+		`{"programIdIndex":` + strconv.Itoa(len(tx.Message.AccountKeys)-1) + `,"accounts":[],"data":"` + solana.Base58(event).String() + `"}]}]}}`
+}
+
+func TestMintCompressed(t *testing.T) {
+	creator, _ := newTestWallet(t)
+	opts := MintOptions{CreatorWallet: creator, MetadataURI: "ar://metadata", ArweaveTxID: "video", Title: "Moment"}
+
+	t.Run("creates the first tree", func(t *testing.T) {
+		standIn, client := newRPCStandIn(t, statusConfirmed)
+		client.minting.compressed = true
+		client.minting.tree = treeConfig{maxDepth: 14, maxBufferSize: 64, canopyDepth: 10}
+		trees := &memTrees{retired: map[string]bool{}}
+		client.SetTreeStore(trees)
+
+		result, err := client.mintCompressed(t.Context(), opts)
+		if err != nil {
+			t.Fatalf("mint: %v", err)
+		}
+
+		if len(trees.trees) != 1 || len(standIn.sent) != 2 {
+			t.Fatalf("created %d trees in %d transactions, want 1 tree and a mint", len(trees.trees), len(standIn.sent))
+		}
+		tree := solana.MustPublicKeyFromBase58(trees.trees[0].Address)
+		if !standIn.sent[0].IsSigner(tree) {
+			t.Errorf("tree keypair must sign its creation")
+		}
+
+		assetID, _, _ := findAssetAddress(tree, 0)
+		if !result.Compressed || result.MerkleTree != tree.String() || result.LeafIndex != 0 || result.MintAddress != assetID.String() {
+			t.Errorf("result = %+v, want asset %s at leaf 0 of %s", result, assetID, tree)
+		}
+		if result.Signature != standIn.sent[1].Signatures[0].String() {
+			t.Errorf("signature = %s, want the mint transaction", result.Signature)
+		}
+		if err := standIn.sent[1].VerifySignatures(); err != nil {
+			t.Errorf("mint signatures: %v", err)
+		}
+	})
+
+	t.Run("mints into the active tree", func(t *testing.T) {
+		standIn, client := newRPCStandIn(t, statusConfirmed)
+		standIn.treeCapacity, standIn.treeMinted, standIn.nextLeaf = 16384, 41, 41
+		existing := &MerkleTree{Address: solana.NewWallet().PublicKey().String(), MaxDepth: 14, MaxBufferSize: 64}
+		client.SetTreeStore(&memTrees{trees: []*MerkleTree{existing}, retired: map[string]bool{}})
+
+		result, err := client.mintCompressed(t.Context(), opts)
+		if err != nil {
+			t.Fatalf("mint: %v", err)
+		}
+		if len(standIn.sent) != 1 || result.MerkleTree != existing.Address || result.LeafIndex != 41 {
+			t.Errorf("sent %d transactions, result = %+v, want leaf 41 of %s", len(standIn.sent), result, existing.Address)
+		}
+	})
+
+	t.Run("lands in its last valid block", func(t *testing.T) {
+		// The status only shows up on the poll that reads a height past the
+		// last valid one, as when the transaction lands in between
+		standIn, client := newRPCStandIn(t, statusPending, statusPending, statusConfirmed)
+		standIn.treeCapacity = 16384
+		existing := &MerkleTree{Address: solana.NewWallet().PublicKey().String(), MaxDepth: 14, MaxBufferSize: 64}
+		client.SetTreeStore(&memTrees{trees: []*MerkleTree{existing}, retired: map[string]bool{}})
+
+		result, err := client.mintCompressed(t.Context(), opts)
+		if err != nil {
+			t.Fatalf("mint: %v", err)
+		}
+		if len(standIn.sent) != 1 {
+			t.Fatalf("sent %d mint_v1 transactions, want 1", len(standIn.sent))
+		}
+		if result.Signature != standIn.sent[0].Signatures[0].String() || result.Confirmation != TxConfirmed {
+			t.Errorf("result = %+v, want the first transaction confirmed", result)
+		}
+	})
+
+	t.Run("rolls over a full tree", func(t *testing.T) {
+		standIn, client := newRPCStandIn(t, statusConfirmed)
+		standIn.treeCapacity, standIn.treeMinted = 16384, 16384
+		client.minting.tree = treeConfig{maxDepth: 14, maxBufferSize: 64, canopyDepth: 10}
+		full := &MerkleTree{Address: solana.NewWallet().PublicKey().String(), MaxDepth: 14, MaxBufferSize: 64}
+		trees := &memTrees{trees: []*MerkleTree{full}, retired: map[string]bool{}}
+		client.SetTreeStore(trees)
+
+		result, err := client.mintCompressed(t.Context(), opts)
+		if err != nil {
+			t.Fatalf("mint: %v", err)
+		}
+		if !trees.retired[full.Address] || len(trees.trees) != 2 {
+			t.Fatalf("full tree should be retired and replaced")
+		}
+		if result.MerkleTree != trees.trees[1].Address || result.LeafIndex != 0 {
+			t.Errorf("result = %+v, want leaf 0 of the new tree", result)
+		}
+	})
+
+	t.Run("no tree store", func(t *testing.T) {
+		_, client := newRPCStandIn(t, statusConfirmed)
+		if _, err := client.mintCompressed(t.Context(), opts); !errors.Is(err, ErrNoTreeStore) {
+			t.Fatalf("err = %v, want ErrNoTreeStore", err)
+		}
+	})
+}
+
+func TestMintCompressedResumesLeafLookup(t *testing.T) {
+	t.Setenv("USE_REAL_MINTING", "true")
+
+	standIn, client := newRPCStandIn(t, statusConfirmed)
+	standIn.treeCapacity, standIn.treeMinted, standIn.nextLeaf = 16384, 7, 7
+	standIn.unserved = true
+	client.minting.confirmTimeout = 50 * time.Millisecond
+	tree := &MerkleTree{Address: solana.NewWallet().PublicKey().String(), MaxDepth: 14, MaxBufferSize: 64}
+	client.SetTreeStore(&memTrees{trees: []*MerkleTree{tree}, retired: map[string]bool{}})
+
+	creator, _ := newTestWallet(t)
+	var pending *PendingMint
+	opts := MintOptions{
+		CreatorWallet: creator,
+		MetadataURI:   "ar://metadata",
+		Title:         "Moment",
+		OnSigned: func(ctx context.Context, p *PendingMint) error {
+			pending = p
+			return nil
+		},
+	}
+
+	// The mint lands but the node doesn't serve the transaction in time
+	if _, err := client.mintCompressed(t.Context(), opts); err == nil {
+		t.Fatal("want an error when the leaf can't be found")
+	}
+	if pending == nil || pending.MerkleTree != tree.Address || len(standIn.sent) != 1 {
+		t.Fatalf("pending = %+v after %d sends, want the mint saved with its tree", pending, len(standIn.sent))
+	}
+
+	standIn.mu.Lock()
+	standIn.unserved = false
+	standIn.mu.Unlock()
+
+	retry := opts
+	retry.Pending = pending
+	retry.OnSigned = func(ctx context.Context, p *PendingMint) error {
+		t.Error("the retry must not sign another mint_v1")
+		return nil
+	}
+	result, err := client.MintNFT(t.Context(), retry)
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if len(standIn.sent) != 1 {
+		t.Errorf("sent %d transactions, want only the first mint", len(standIn.sent))
+	}
+
+	assetID, _, _ := findAssetAddress(solana.MustPublicKeyFromBase58(tree.Address), 7)
+	if result.Signature != pending.Signature || result.LeafIndex != 7 || result.MintAddress != assetID.String() {
+		t.Errorf("result = %+v, want asset %s at leaf 7 from %s", result, assetID, pending.Signature)
+	}
+}
+
+func TestMintCompressedConcurrentRollover(t *testing.T) {
+	t.Setenv("USE_REAL_MINTING", "true")
+
+	standIn, client := newRPCStandIn(t, statusConfirmed)
+	standIn.treeCapacity, standIn.treeMinted = 16384, 16384
+	client.minting.compressed = true
+	client.minting.tree = treeConfig{maxDepth: 14, maxBufferSize: 64, canopyDepth: 10}
+	full := &MerkleTree{Address: solana.NewWallet().PublicKey().String(), MaxDepth: 14, MaxBufferSize: 64}
+	trees := &memTrees{trees: []*MerkleTree{full}, retired: map[string]bool{}}
+	client.SetTreeStore(trees)
+
+	creator, _ := newTestWallet(t)
+	opts := MintOptions{CreatorWallet: creator, MetadataURI: "ar://metadata", Title: "Moment"}
+
+	const workers = 8
+	results := make([]*MintResult, workers)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			result, err := client.MintNFT(t.Context(), opts)
+			if err != nil {
+				t.Errorf("worker %d: %v", i, err)
+				return
+			}
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	if !trees.retired[full.Address] || len(trees.trees) != 2 {
+		t.Fatalf("want the full tree retired and exactly one new tree, have %d trees", len(trees.trees))
+	}
+	if len(standIn.sent) != workers+1 {
+		t.Errorf("sent %d transactions, want one tree and %d mints", len(standIn.sent), workers)
+	}
+
+	leaves := map[uint64]bool{}
+	for _, result := range results {
+		if result.MerkleTree != trees.trees[1].Address {
+			t.Errorf("minted into %s, want the new tree %s", result.MerkleTree, trees.trees[1].Address)
+		}
+		leaves[result.LeafIndex] = true
+	}
+	if len(leaves) != workers {
+		t.Errorf("%d distinct leaves for %d mints", len(leaves), workers)
+	}
+}
+
+func TestMerkleTreeAccountSize(t *testing.T) {
+	// Sizes from getConcurrentMerkleTreeAccountSize in spl-account-compression
+	tests := []struct {
+		depth, buffer, canopy uint32
+		want                  uint64
+	}{
+		{3, 8, 0, 1304},
+		{14, 64, 0, 31800},
+		{14, 64, 10, 97272},
+	}
+	for _, tt := range tests {
+		if got := merkleTreeAccountSize(tt.depth, tt.buffer, tt.canopy); got != tt.want {
+			t.Errorf("merkleTreeAccountSize(%d, %d, %d) = %d, want %d", tt.depth, tt.buffer, tt.canopy, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// Confirmation states of a mint transaction. Processed, confirmed and
+// finalized are Solana commitment levels; failed and expired are final.
+const (
+	TxPending   = "pending"
+	TxProcessed = "processed"
+	TxConfirmed = "confirmed"
+	TxFinalized = "finalized"
+	TxFailed    = "failed"
+	TxExpired   = "expired"
+)
+
+// maxStatusBatch is the most signatures getSignatureStatuses accepts
+const maxStatusBatch = 256
+
+// TransactionStatus is where a transaction stands on chain
+type TransactionStatus struct {
+	Signature string
+	// Status is one of the Tx states; TxPending when the node doesn't know
+	// the transaction
+	Status string
+	Slot   uint64
+	Err    string
+}
+
+// TransactionStatuses looks up transactions by signature, searching the
+// node's full history so older mints are found too
+func (s *SolanaClient) TransactionStatuses(ctx context.Context, signatures []string) ([]TransactionStatus, error) {
+	result := make([]TransactionStatus, 0, len(signatures))
+
+	for start := 0; start < len(signatures); start += maxStatusBatch {
+		batch := signatures[start:min(start+maxStatusBatch, len(signatures))]
+
+		keys := make([]solana.Signature, len(batch))
+		for i, signature := range batch {
+			key, err := solana.SignatureFromBase58(signature)
+			if err != nil {
+				return nil, fmt.Errorf("invalid signature %q: %w", signature, err)
+			}
+			keys[i] = key
+		}
+
+		out, err := s.rpcClient.GetSignatureStatuses(ctx, true, keys...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get signature statuses: %w", err)
+		}
+
+		for i, signature := range batch {
+			status := TransactionStatus{Signature: signature, Status: TxPending}
+			if i < len(out.Value) && out.Value[i] != nil {
+				value := out.Value[i]
+				status.Slot = value.Slot
+				switch {
+				case value.Err != nil:
+					status.Status = TxFailed
+					status.Err = fmt.Sprint(value.Err)
+				case value.ConfirmationStatus != "":
+					status.Status = string(value.ConfirmationStatus)
+				default:
+					// Nodes leave the status out for transactions too old
+					// to be in their status cache, which are rooted
+					status.Status = TxFinalized
+				}
+			}
+			result = append(result, status)
+		}
+	}
+
+	return result, nil
+}
+
+// BlockHeight returns the current block height; a transaction that hasn't
+// landed once it passes the transaction's last valid block height never will
+func (s *SolanaClient) BlockHeight(ctx context.Context) (uint64, error) {
+	height, err := s.rpcClient.GetBlockHeight(ctx, rpc.CommitmentFinalized)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get block height: %w", err)
+	}
+	return height, nil
+}
@@ -0,0 +1,549 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gagliardetto/solana-go"
+	associatedtokenaccount "github.com/gagliardetto/solana-go/programs/associated-token-account"
+	computebudget "github.com/gagliardetto/solana-go/programs/compute-budget"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/gagliardetto/solana-go/programs/token"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// Token Metadata instruction discriminators
+const (
+	createMasterEditionV3   = 17
+	createMetadataAccountV3 = 33
+)
+
+// Token Metadata field limits
+const (
+	maxNameLength   = 32
+	maxSymbolLength = 10
+	maxURILength    = 200
+)
+
+// nftSymbol and sellerFeeBasisPoints match the off-chain metadata
+const (
+	nftSymbol            = "NOWINK"
+	sellerFeeBasisPoints = 500 // 5% platform commission
+)
+
+// maxSends is how many times a mint transaction is sent before giving up
+// on blockhash expiry
+const maxSends = 3
+
+// Minting errors
+var (
+	ErrNoPayer          = errors.New("mint payer keypair not configured")
+	ErrBlockhashExpired = errors.New("transaction blockhash expired before it was confirmed")
+	// ErrMintNotLanded means a saved mint transaction failed, or expired
+	// without landing, so signing the mint again can't mint it twice
+	ErrMintNotLanded = errors.New("mint transaction failed or expired without landing")
+)
+
+// mintConfig controls how mint transactions are paid for and confirmed
+type mintConfig struct {
+	// payer funds the accounts, signs as mint and update authority, and is
+	// the verified platform creator
+	payer solana.PrivateKey
+	// priorityFee is the compute unit price in micro-lamports
+	priorityFee    uint64
+	computeUnits   uint32
+	commitment     rpc.CommitmentType
+	confirmTimeout time.Duration
+	pollInterval   time.Duration
+	// compressed mints Bubblegum compressed NFTs into tree instead of
+	// Metaplex master editions
+	compressed bool
+	tree       treeConfig
+}
+
+func loadMintConfig() mintConfig {
+	cfg := mintConfig{
+		priorityFee:    10_000,
+		computeUnits:   250_000,
+		commitment:     rpc.CommitmentConfirmed,
+		confirmTimeout: 90 * time.Second,
+		pollInterval:   time.Second,
+		tree:           loadTreeConfig(),
+	}
+
+	if fee, err := strconv.ParseUint(os.Getenv("SOLANA_PRIORITY_FEE_MICROLAMPORTS"), 10, 64); err == nil {
+		cfg.priorityFee = fee
+	}
+	if units, err := strconv.ParseUint(os.Getenv("SOLANA_COMPUTE_UNIT_LIMIT"), 10, 32); err == nil && units > 0 {
+		cfg.computeUnits = uint32(units)
+	}
+	switch commitment := rpc.CommitmentType(os.Getenv("SOLANA_COMMITMENT")); commitment {
+	case rpc.CommitmentProcessed, rpc.CommitmentConfirmed, rpc.CommitmentFinalized:
+		cfg.commitment = commitment
+	}
+	if d, err := time.ParseDuration(os.Getenv("SOLANA_CONFIRM_TIMEOUT")); err == nil && d > 0 {
+		cfg.confirmTimeout = d
+	}
+	switch mode := os.Getenv("SOLANA_MINT_MODE"); mode {
+	case "", MintModeStandard:
+	case MintModeCompressed:
+		cfg.compressed = true
+	default:
+		log.Printf("⚠️  Unknown SOLANA_MINT_MODE %q, minting standard NFTs", mode)
+	}
+
+	payer, err := loadPayer()
+	if err != nil {
+		log.Printf("⚠️  Invalid mint payer keypair: %v", err)
+	}
+	cfg.payer = payer
+
+	return cfg
+}
+
+// loadPayer reads the platform keypair from PLATFORM_WALLET_PRIVATE_KEY
+// (base58, or the JSON byte array solana-keygen writes) or from the
+// keypair file at PLATFORM_WALLET_PATH
+func loadPayer() (solana.PrivateKey, error) {
+	if key := strings.TrimSpace(os.Getenv("PLATFORM_WALLET_PRIVATE_KEY")); key != "" {
+		if strings.HasPrefix(key, "[") {
+			return solana.PrivateKeyFromSolanaKeygenFileBytes([]byte(key))
+		}
+		return solana.PrivateKeyFromBase58(key)
+	}
+	if path := os.Getenv("PLATFORM_WALLET_PATH"); path != "" {
+		return solana.PrivateKeyFromSolanaKeygenFile(path)
+	}
+	return nil, nil
+}
+
+// mintWithMetaplex mints a Metaplex master edition NFT to the creator's
+// wallet in a single transaction and waits for it to be confirmed. A
+// pending mint is resumed; it is only signed again, with the same mint
+// keypair, once it provably didn't land.
+func (s *SolanaClient) mintWithMetaplex(ctx context.Context, opts MintOptions) (*MintResult, error) {
+	payer := s.minting.payer
+	if payer == nil {
+		return nil, ErrNoPayer
+	}
+
+	owner, err := solana.PublicKeyFromBase58(opts.CreatorWallet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid creator wallet: %w", err)
+	}
+
+	mint, err := mintKeypair(opts.Pending)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Pending != nil {
+		sent, err := s.resumeTransaction(ctx, opts.Pending, mint.PublicKey())
+		if err == nil {
+			return s.metaplexResult(mint.PublicKey(), sent, opts), nil
+		}
+		if !errors.Is(err, ErrMintNotLanded) || opts.ResumeOnly {
+			return nil, err
+		}
+		log.Printf("🔁 %v, signing mint %s again", err, mint.PublicKey())
+	}
+
+	rent, err := s.rpcClient.GetMinimumBalanceForRentExemption(ctx, token.MINT_SIZE, s.minting.commitment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mint rent: %w", err)
+	}
+
+	instructions, err := buildMintInstructions(s.minting, payer.PublicKey(), mint.PublicKey(), owner, rent, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sent, err := s.sendAndConfirm(ctx, instructions, opts.saveBeforeSend(ctx, PendingMint{MintKey: mint.String()}), payer, mint)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.metaplexResult(mint.PublicKey(), sent, opts), nil
+}
+
+// mintKeypair returns the mint account keypair a pending mint was signed
+// with, or a new one
+func mintKeypair(pending *PendingMint) (solana.PrivateKey, error) {
+	if pending != nil && pending.MintKey != "" {
+		mint, err := solana.PrivateKeyFromBase58(pending.MintKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid saved mint keypair: %w", err)
+		}
+		return mint, nil
+	}
+
+	mint, err := solana.NewRandomPrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate mint keypair: %w", err)
+	}
+	return mint, nil
+}
+
+// metaplexResult describes a mint whose transaction reached the commitment
+func (s *SolanaClient) metaplexResult(mint solana.PublicKey, sent *sentTransaction, opts MintOptions) *MintResult {
+	log.Printf("✅ NFT minted: %s", mint)
+
+	return &MintResult{
+		MintAddress:          mint.String(),
+		MetadataURI:          opts.MetadataURI,
+		ArweaveTxID:          opts.ArweaveTxID,
+		Signature:            sent.signature.String(),
+		LastValidBlockHeight: sent.lastValidBlockHeight,
+		Confirmation:         sent.status,
+		Status:               "minted",
+		Network:              s.network,
+	}
+}
+
+// buildMintInstructions creates the mint, mints one token to the owner's
+// associated token account, and adds Token Metadata and a master edition
+// with a supply of zero, which also takes over the mint authority
+func buildMintInstructions(cfg mintConfig, payer, mint, owner solana.PublicKey, rent uint64, opts MintOptions) ([]solana.Instruction, error) {
+	if len(opts.MetadataURI) > maxURILength {
+		return nil, fmt.Errorf("metadata URI longer than %d bytes", maxURILength)
+	}
+
+	ownerTokenAccount, _, err := solana.FindAssociatedTokenAddress(owner, mint)
+	if err != nil {
+		return nil, err
+	}
+	metadata, _, err := solana.FindTokenMetadataAddress(mint)
+	if err != nil {
+		return nil, err
+	}
+	edition, _, err := findMasterEditionAddress(mint)
+	if err != nil {
+		return nil, err
+	}
+
+	// The platform signs the transaction, so its creator entry is verified;
+	// the user verifies theirs later
+	creators := []metadataCreator{
+		{Address: payer, Verified: true, Share: 5},
+		{Address: owner, Share: 95},
+	}
+	if owner == payer {
+		creators = []metadataCreator{{Address: payer, Verified: true, Share: 100}}
+	}
+
+	instructions := append(budgetInstructions(cfg),
+		system.NewCreateAccountInstruction(rent, token.MINT_SIZE, solana.TokenProgramID, payer, mint).Build(),
+		token.NewInitializeMint2Instruction(0, payer, payer, mint).Build(),
+		associatedtokenaccount.NewCreateInstruction(payer, owner, mint).Build(),
+		token.NewMintToInstruction(1, mint, ownerTokenAccount, payer, nil).Build(),
+		newCreateMetadataInstruction(metadata, mint, payer, metadataData{
+			Name:     truncateUTF8(opts.Title, maxNameLength),
+			Symbol:   nftSymbol,
+			URI:      opts.MetadataURI,
+			Creators: creators,
+		}),
+		newCreateMasterEditionInstruction(edition, mint, payer, metadata),
+	)
+
+	return instructions, nil
+}
+
+// budgetInstructions set the compute unit limit and priority fee
+func budgetInstructions(cfg mintConfig) []solana.Instruction {
+	var instructions []solana.Instruction
+	if cfg.computeUnits > 0 {
+		instructions = append(instructions, computebudget.NewSetComputeUnitLimitInstruction(cfg.computeUnits).Build())
+	}
+	if cfg.priorityFee > 0 {
+		instructions = append(instructions, computebudget.NewSetComputeUnitPriceInstruction(cfg.priorityFee).Build())
+	}
+	return instructions
+}
+
+// sentTransaction is a transaction that reached the configured commitment
+type sentTransaction struct {
+	signature            solana.Signature
+	lastValidBlockHeight uint64
+	status               string
+}
+
+// sendAndConfirm signs a transaction paid for by the first signer, sends
+// it and waits for it to be confirmed. A transaction whose blockhash
+// expired can no longer land, so once the node's full history confirms it
+// didn't, it is signed again with a fresh blockhash and re-sent, up to
+// maxSends times. save, if set, gets every signed transaction before it is
+// sent; nothing is sent if it fails.
+func (s *SolanaClient) sendAndConfirm(ctx context.Context, instructions []solana.Instruction, save func(tx *solana.Transaction, lastValidBlockHeight uint64) error, signers ...solana.PrivateKey) (*sentTransaction, error) {
+	for send := 1; ; send++ {
+		sent, err := s.sendOnce(ctx, instructions, save, signers)
+		if !errors.Is(err, ErrBlockhashExpired) || send == maxSends {
+			if err != nil {
+				return nil, err
+			}
+			return sent, nil
+		}
+
+		// Nothing but the blockhash keeps a mint_v1 from landing twice, so
+		// make sure the expired copy really isn't on chain
+		landed, err := s.landedAfterExpiry(ctx, sent)
+		if err != nil || landed != nil {
+			return landed, err
+		}
+		log.Printf("🔁 Transaction %s expired, re-sending (%d/%d)", sent.signature, send+1, maxSends)
+	}
+}
+
+// landedAfterExpiry looks an expired transaction up in the node's full
+// history. It returns the transaction once it reached the commitment if it
+// landed after all, and nil if it didn't.
+func (s *SolanaClient) landedAfterExpiry(ctx context.Context, sent *sentTransaction) (*sentTransaction, error) {
+	statuses, err := s.TransactionStatuses(ctx, []string{sent.signature.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	switch status := statuses[0]; status.Status {
+	case TxPending:
+		return nil, nil
+	case TxFailed:
+		return nil, fmt.Errorf("transaction %s failed: %s", sent.signature, status.Err)
+	default:
+		log.Printf("🔁 Transaction %s landed as its blockhash expired (%s)", sent.signature, status.Status)
+		if commitmentReached(rpc.ConfirmationStatusType(status.Status), s.minting.commitment) {
+			sent.status = status.Status
+			return sent, nil
+		}
+	}
+
+	confirmation, err := s.waitForConfirmation(ctx, sent.signature, sent.lastValidBlockHeight)
+	if err != nil {
+		return nil, err
+	}
+	sent.status = string(confirmation)
+	return sent, nil
+}
+
+// sendOnce signs the transaction with the latest blockhash, saves it,
+// sends it and waits for it. When the blockhash expires, the transaction is
+// returned along with ErrBlockhashExpired.
+func (s *SolanaClient) sendOnce(ctx context.Context, instructions []solana.Instruction, save func(*solana.Transaction, uint64) error, signers []solana.PrivateKey) (*sentTransaction, error) {
+	blockhash, err := s.rpcClient.GetLatestBlockhash(ctx, s.minting.commitment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blockhash: %w", err)
+	}
+
+	tx, err := solana.NewTransaction(instructions, blockhash.Value.Blockhash, solana.TransactionPayer(signers[0].PublicKey()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build transaction: %w", err)
+	}
+
+	_, err = tx.Sign(func(key solana.PublicKey) *solana.PrivateKey {
+		for i := range signers {
+			if signers[i].PublicKey() == key {
+				return &signers[i]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+
+	if save != nil {
+		if err := save(tx, blockhash.Value.LastValidBlockHeight); err != nil {
+			return nil, fmt.Errorf("failed to save transaction before sending: %w", err)
+		}
+	}
+
+	signature, err := s.rpcClient.SendTransactionWithOpts(ctx, tx, rpc.TransactionOpts{
+		PreflightCommitment: s.minting.commitment,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send transaction: %w", err)
+	}
+
+	log.Printf("⚡ Transaction sent: %s", signature)
+
+	sent := &sentTransaction{
+		signature:            signature,
+		lastValidBlockHeight: blockhash.Value.LastValidBlockHeight,
+	}
+
+	status, err := s.waitForConfirmation(ctx, signature, blockhash.Value.LastValidBlockHeight)
+	if errors.Is(err, ErrBlockhashExpired) {
+		return sent, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sent.status = string(status)
+	return sent, nil
+}
+
+// findMasterEditionAddress derives a mint's master edition account
+func findMasterEditionAddress(mint solana.PublicKey) (solana.PublicKey, uint8, error) {
+	return solana.FindProgramAddress([][]byte{
+		[]byte("metadata"),
+		solana.TokenMetadataProgramID[:],
+		mint[:],
+		[]byte("edition"),
+	}, solana.TokenMetadataProgramID)
+}
+
+type metadataCreator struct {
+	Address  solana.PublicKey
+	Verified bool
+	Share    uint8
+}
+
+type metadataData struct {
+	Name     string
+	Symbol   string
+	URI      string
+	Creators []metadataCreator
+}
+
+// newCreateMetadataInstruction builds CreateMetadataAccountV3 with the payer
+// as mint and update authority. The metadata is mutable so it can be
+// corrected later; there is no collection or uses.
+func newCreateMetadataInstruction(metadata, mint, payer solana.PublicKey, data metadataData) solana.Instruction {
+	var buf bytes.Buffer
+	buf.WriteByte(createMetadataAccountV3)
+
+	// DataV2
+	writeBorshString(&buf, data.Name)
+	writeBorshString(&buf, data.Symbol)
+	writeBorshString(&buf, data.URI)
+	binary.Write(&buf, binary.LittleEndian, uint16(sellerFeeBasisPoints))
+	buf.WriteByte(1) // Some(creators)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(data.Creators)))
+	for _, creator := range data.Creators {
+		buf.Write(creator.Address[:])
+		writeBorshBool(&buf, creator.Verified)
+		buf.WriteByte(creator.Share)
+	}
+	buf.WriteByte(0) // collection: None
+	buf.WriteByte(0) // uses: None
+
+	writeBorshBool(&buf, true) // is_mutable
+	buf.WriteByte(0)           // collection_details: None
+
+	accounts := solana.AccountMetaSlice{
+		solana.Meta(metadata).WRITE(),
+		solana.Meta(mint),
+		solana.Meta(payer).SIGNER(),         // mint authority
+		solana.Meta(payer).SIGNER().WRITE(), // payer
+		solana.Meta(payer).SIGNER(),         // update authority
+		solana.Meta(solana.SystemProgramID),
+		solana.Meta(solana.SysVarRentPubkey),
+	}
+
+	return solana.NewInstruction(solana.TokenMetadataProgramID, accounts, buf.Bytes())
+}
+
+// newCreateMasterEditionInstruction builds CreateMasterEditionV3 with a max
+// supply of zero, making the NFT one of a kind
+func newCreateMasterEditionInstruction(edition, mint, payer, metadata solana.PublicKey) solana.Instruction {
+	var buf bytes.Buffer
+	buf.WriteByte(createMasterEditionV3)
+	buf.WriteByte(1) // Some(max_supply)
+	binary.Write(&buf, binary.LittleEndian, uint64(0))
+
+	accounts := solana.AccountMetaSlice{
+		solana.Meta(edition).WRITE(),
+		solana.Meta(mint).WRITE(),
+		solana.Meta(payer).SIGNER(),         // update authority
+		solana.Meta(payer).SIGNER(),         // mint authority
+		solana.Meta(payer).SIGNER().WRITE(), // payer
+		solana.Meta(metadata).WRITE(),
+		solana.Meta(solana.TokenProgramID),
+		solana.Meta(solana.SystemProgramID),
+		solana.Meta(solana.SysVarRentPubkey),
+	}
+
+	return solana.NewInstruction(solana.TokenMetadataProgramID, accounts, buf.Bytes())
+}
+
+func writeBorshString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(s)))
+	buf.WriteString(s)
+}
+
+func writeBorshBool(buf *bytes.Buffer, b bool) {
+	if b {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// waitForConfirmation polls the signature until it reaches the configured
+// commitment, fails, or can no longer land because its blockhash expired.
+// It returns the confirmation status reached.
+func (s *SolanaClient) waitForConfirmation(ctx context.Context, signature solana.Signature, lastValidBlockHeight uint64) (rpc.ConfirmationStatusType, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.minting.confirmTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(s.minting.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// The height is read before the status, so a transaction that lands
+		// in its last valid block shows up in the status rather than being
+		// taken for expired
+		height, heightErr := s.rpcClient.GetBlockHeight(ctx, s.minting.commitment)
+		statuses, err := s.rpcClient.GetSignatureStatuses(ctx, false, signature)
+		if err == nil && len(statuses.Value) > 0 && statuses.Value[0] != nil {
+			status := statuses.Value[0]
+			if status.Err != nil {
+				return "", fmt.Errorf("transaction %s failed: %v", signature, status.Err)
+			}
+			if commitmentReached(status.ConfirmationStatus, s.minting.commitment) {
+				return status.ConfirmationStatus, nil
+			}
+		} else if err == nil && heightErr == nil && height > lastValidBlockHeight {
+			return "", fmt.Errorf("transaction %s: %w", signature, ErrBlockhashExpired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("transaction %s not confirmed: %w", signature, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// commitmentReached reports whether a transaction's status is at least the
+// wanted commitment
+func commitmentReached(status rpc.ConfirmationStatusType, want rpc.CommitmentType) bool {
+	switch status {
+	case rpc.ConfirmationStatusFinalized:
+		return true
+	case rpc.ConfirmationStatusConfirmed:
+		return want != rpc.CommitmentFinalized
+	case rpc.ConfirmationStatusProcessed:
+		return want == rpc.CommitmentProcessed
+	}
+	return false
+}
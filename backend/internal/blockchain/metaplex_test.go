@@ -0,0 +1,346 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// rpcStandIn answers JSON-RPC calls with responses recorded from a devnet
+// node, so the mint flow runs without a validator. Signature statuses are
+// replayed in order, the last one repeating.
+type rpcStandIn struct {
+	t           *testing.T
+	statuses    []string
+	blockHeight uint64
+	// Merkle tree usage reported in compressed mode, and the leaf the next
+	// mint lands in. Trees created through the stand-in start empty.
+	treeMinted, treeCapacity uint64
+	nextLeaf                 uint64
+	// unserved makes getTransaction find nothing
+	unserved bool
+	// mints are the mint accounts on chain; when set, getAccountInfo looks
+	// up mints instead of tree configs
+	mints map[string]bool
+
+	mu    sync.Mutex
+	calls map[string]int
+	sent  []*solana.Transaction
+	// leaves are the leaves mint_v1 transactions landed in, by signature
+	leaves       map[solana.Signature]uint64
+	createdTrees map[string]bool
+}
+
+const (
+	recordedBlockhash            = "EkSnNWid2cvwEVnVx9aBqawnmiCNiDgp3gUdkDPTKN1N"
+	recordedLastValidBlockHeight = 2_874_101
+	recordedRent                 = 1_461_600
+)
+
+func newRPCStandIn(t *testing.T, statuses ...string) (*rpcStandIn, *SolanaClient) {
+	t.Helper()
+
+	standIn := &rpcStandIn{
+		t:            t,
+		statuses:     statuses,
+		blockHeight:  recordedLastValidBlockHeight - 150,
+		calls:        map[string]int{},
+		leaves:       map[solana.Signature]uint64{},
+		createdTrees: map[string]bool{},
+	}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+
+	payer, err := solana.NewRandomPrivateKey()
+	if err != nil {
+		t.Fatalf("generate payer: %v", err)
+	}
+
+	client := &SolanaClient{
+		rpcClient: rpc.New(server.URL),
+		network:   "devnet",
+		minting: mintConfig{
+			payer:          payer,
+			priorityFee:    10_000,
+			computeUnits:   250_000,
+			commitment:     rpc.CommitmentConfirmed,
+			confirmTimeout: 5 * time.Second,
+			pollInterval:   10 * time.Millisecond,
+		},
+	}
+
+	return standIn, client
+}
+
+func (s *rpcStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		s.t.Errorf("stand-in: bad request %s: %v", body, err)
+		return
+	}
+
+	s.mu.Lock()
+	call := s.calls[req.Method]
+	s.calls[req.Method]++
+	s.mu.Unlock()
+
+	var result string
+	switch req.Method {
+	case "getMinimumBalanceForRentExemption":
+		result = strconv.Itoa(recordedRent)
+	case "getLatestBlockhash":
+		// Later calls get a newer blockhash, so a transaction signed again
+		// gets a new signature
+		blockhash := recordedBlockhash
+		if call > 0 {
+			blockhash = solana.HashFromBytes(bytes.Repeat([]byte{byte(call)}, 32)).String()
+		}
+		result = `{"context":{"apiVersion":"2.0.15","slot":339010822},"value":{"blockhash":"` + blockhash + `","lastValidBlockHeight":` + strconv.Itoa(recordedLastValidBlockHeight) + `}}`
+	case "sendTransaction":
+		var encoded string
+		json.Unmarshal(req.Params[0], &encoded)
+		tx, err := solana.TransactionFromBase64(encoded)
+		if err != nil {
+			s.t.Errorf("stand-in: undecodable transaction: %v", err)
+			return
+		}
+		s.mu.Lock()
+		s.sent = append(s.sent, tx)
+		s.recordBubblegum(tx)
+		s.mu.Unlock()
+		result = `"` + tx.Signatures[0].String() + `"`
+	case "getSignatureStatuses":
+		result = s.statuses[min(call, len(s.statuses)-1)]
+	case "getBlockHeight":
+		// The chain moves on while the transaction is pending
+		s.mu.Lock()
+		s.blockHeight += 100
+		result = strconv.FormatUint(s.blockHeight, 10)
+		s.mu.Unlock()
+	case "getAccountInfo":
+		var address string
+		json.Unmarshal(req.Params[0], &address)
+		switch {
+		case s.mints == nil:
+			result = s.treeConfigAccount(address)
+		case s.mints[address]:
+			result = `{"context":{"slot":339010822},"value":{"data":["","base64"],"executable":false,"lamports":` +
+				strconv.Itoa(recordedRent) + `,"owner":"` + solana.TokenProgramID.String() + `","rentEpoch":18446744073709551615,"space":82}}`
+		default:
+			result = `{"context":{"slot":339010822},"value":null}`
+		}
+	case "getTransaction":
+		var signature string
+		json.Unmarshal(req.Params[0], &signature)
+		result = s.mintTransaction(solana.MustSignatureFromBase58(signature))
+	default:
+		s.t.Errorf("stand-in: unexpected method %s", req.Method)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"jsonrpc":"2.0","id":` + string(req.ID) + `,"result":` + result + `}`))
+}
+
+const (
+	statusPending   = `{"context":{"slot":339010823},"value":[null]}`
+	statusProcessed = `{"context":{"slot":339010824},"value":[{"slot":339010824,"confirmations":0,"err":null,"status":{"Ok":null},"confirmationStatus":"processed"}]}`
+	statusConfirmed = `{"context":{"slot":339010826},"value":[{"slot":339010824,"confirmations":2,"err":null,"status":{"Ok":null},"confirmationStatus":"confirmed"}]}`
+	statusFailed    = `{"context":{"slot":339010826},"value":[{"slot":339010824,"confirmations":2,"err":{"InstructionError":[5,{"Custom":1}]},"status":{"Err":{"InstructionError":[5,{"Custom":1}]}},"confirmationStatus":"confirmed"}]}`
+)
+
+func TestMintWithMetaplex(t *testing.T) {
+	standIn, client := newRPCStandIn(t, statusPending, statusProcessed, statusConfirmed)
+	creator, _ := newTestWallet(t)
+
+	result, err := client.mintWithMetaplex(t.Context(), MintOptions{
+		CreatorWallet: creator,
+		MetadataURI:   "ar://Zt2QJp1tVBu3CmD8JxQz1kDVRsz6pYuMhgqGwlPCmSs",
+		ArweaveTxID:   "kq8yhRFJqUXxaVqbI0r5mK0eiI5OxzJjAJCqxm4E0Fo",
+		Title:         "Sunset at the pier, the longest title anyone ever wrote",
+	})
+	if err != nil {
+		t.Fatalf("mint: %v", err)
+	}
+
+	if len(standIn.sent) != 1 {
+		t.Fatalf("sent %d transactions, want 1", len(standIn.sent))
+	}
+	tx := standIn.sent[0]
+
+	if err := tx.VerifySignatures(); err != nil {
+		t.Fatalf("signatures: %v", err)
+	}
+	if result.Signature != tx.Signatures[0].String() {
+		t.Errorf("signature = %s, want %s", result.Signature, tx.Signatures[0])
+	}
+	// Solana's packet size limit
+	if encoded, _ := tx.MarshalBinary(); len(encoded) > 1232 {
+		t.Errorf("transaction is %d bytes, over the 1232 byte limit", len(encoded))
+	}
+	if tx.Message.RecentBlockhash.String() != recordedBlockhash {
+		t.Errorf("blockhash = %s", tx.Message.RecentBlockhash)
+	}
+
+	payer := client.minting.payer.PublicKey()
+	mint := solana.MustPublicKeyFromBase58(result.MintAddress)
+	if !tx.IsSigner(payer) || !tx.IsSigner(mint) || tx.Message.AccountKeys[0] != payer {
+		t.Fatalf("payer and mint must sign, payer first")
+	}
+
+	wantPrograms := []solana.PublicKey{
+		solana.ComputeBudget,
+		solana.ComputeBudget,
+		solana.SystemProgramID,
+		solana.TokenProgramID,
+		solana.SPLAssociatedTokenAccountProgramID,
+		solana.TokenProgramID,
+		solana.TokenMetadataProgramID,
+		solana.TokenMetadataProgramID,
+	}
+	if len(tx.Message.Instructions) != len(wantPrograms) {
+		t.Fatalf("%d instructions, want %d", len(tx.Message.Instructions), len(wantPrograms))
+	}
+	for i, want := range wantPrograms {
+		if got := tx.Message.AccountKeys[tx.Message.Instructions[i].ProgramIDIndex]; got != want {
+			t.Errorf("instruction %d program = %s, want %s", i, got, want)
+		}
+	}
+
+	metadata, _, _ := solana.FindTokenMetadataAddress(mint)
+	edition, _, _ := findMasterEditionAddress(mint)
+
+	createMetadata := tx.Message.Instructions[6]
+	accounts, _ := createMetadata.ResolveInstructionAccounts(&tx.Message)
+	if accounts[0].PublicKey != metadata || !accounts[0].IsWritable {
+		t.Errorf("metadata account = %s, want writable %s", accounts[0].PublicKey, metadata)
+	}
+
+	data := []byte(createMetadata.Data)
+	if data[0] != createMetadataAccountV3 {
+		t.Errorf("metadata discriminator = %d", data[0])
+	}
+	// The name is cut to the on-chain limit of 32 bytes
+	if !bytes.Contains(data, []byte("\x20\x00\x00\x00Sunset at the pier, the longest ")) {
+		t.Errorf("metadata name not truncated to 32 bytes")
+	}
+	if !bytes.Contains(data, append(payer.Bytes(), 1, 5)) {
+		t.Errorf("platform creator should be verified with a 5%% share")
+	}
+	if !bytes.Contains(data, append(solana.MustPublicKeyFromBase58(creator).Bytes(), 0, 95)) {
+		t.Errorf("user creator should be unverified with a 95%% share")
+	}
+
+	createEdition := tx.Message.Instructions[7]
+	accounts, _ = createEdition.ResolveInstructionAccounts(&tx.Message)
+	if accounts[0].PublicKey != edition {
+		t.Errorf("edition account = %s, want %s", accounts[0].PublicKey, edition)
+	}
+	if !bytes.Equal(createEdition.Data, []byte{createMasterEditionV3, 1, 0, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("edition data = %v, want max supply Some(0)", []byte(createEdition.Data))
+	}
+
+	if got := standIn.calls["getSignatureStatuses"]; got != 3 {
+		t.Errorf("polled %d times, want 3 (pending, processed, confirmed)", got)
+	}
+}
+
+func TestMintResendsExpiredTransaction(t *testing.T) {
+	// The block height passes the last valid one on the second poll, and the
+	// history lookup doesn't find the transaction either
+	standIn, client := newRPCStandIn(t, statusPending, statusPending, statusPending, statusConfirmed)
+	creator, _ := newTestWallet(t)
+
+	result, err := client.mintWithMetaplex(t.Context(), MintOptions{CreatorWallet: creator, MetadataURI: "ar://metadata", Title: "Moment"})
+	if err != nil {
+		t.Fatalf("mint: %v", err)
+	}
+	if len(standIn.sent) != 2 {
+		t.Fatalf("sent %d times, want 2", len(standIn.sent))
+	}
+	// The mint keypair is kept, so both sends can't land
+	first, second := standIn.sent[0], standIn.sent[1]
+	mint := solana.MustPublicKeyFromBase58(result.MintAddress)
+	if !first.IsSigner(mint) || !second.IsSigner(mint) {
+		t.Errorf("re-send should reuse mint %s", mint)
+	}
+	if result.Confirmation != TxConfirmed || result.LastValidBlockHeight != recordedLastValidBlockHeight {
+		t.Errorf("confirmation = %s at %d", result.Confirmation, result.LastValidBlockHeight)
+	}
+}
+
+func TestTransactionStatuses(t *testing.T) {
+	_, client := newRPCStandIn(t, `{"context":{"slot":339010900},"value":[`+
+		`{"slot":339010824,"confirmations":null,"err":null,"status":{"Ok":null},"confirmationStatus":"finalized"},`+
+		`null,`+
+		`{"slot":339010830,"confirmations":1,"err":{"InstructionError":[5,{"Custom":1}]},"status":{"Err":{"InstructionError":[5,{"Custom":1}]}},"confirmationStatus":"confirmed"}]}`)
+
+	signatures := make([]string, 3)
+	for i := range signatures {
+		signatures[i] = solana.SignatureFromBytes(bytes.Repeat([]byte{byte(i + 1)}, 64)).String()
+	}
+
+	statuses, err := client.TransactionStatuses(t.Context(), signatures)
+	if err != nil {
+		t.Fatalf("statuses: %v", err)
+	}
+
+	want := []string{TxFinalized, TxPending, TxFailed}
+	for i, status := range statuses {
+		if status.Signature != signatures[i] || status.Status != want[i] {
+			t.Errorf("status %d = %s %s, want %s", i, status.Signature, status.Status, want[i])
+		}
+	}
+	if statuses[0].Slot != 339010824 || statuses[2].Err == "" {
+		t.Errorf("slot and error not reported: %+v", statuses)
+	}
+}
+
+func TestMintWithMetaplexFailures(t *testing.T) {
+	creator, _ := newTestWallet(t)
+	opts := MintOptions{CreatorWallet: creator, MetadataURI: "ar://metadata", Title: "Moment"}
+
+	t.Run("transaction error", func(t *testing.T) {
+		_, client := newRPCStandIn(t, statusFailed)
+		if _, err := client.mintWithMetaplex(t.Context(), opts); err == nil {
+			t.Fatal("want error for a failed transaction")
+		}
+	})
+
+	t.Run("blockhash expired", func(t *testing.T) {
+		standIn, client := newRPCStandIn(t, statusPending)
+		_, err := client.mintWithMetaplex(t.Context(), opts)
+		if !errors.Is(err, ErrBlockhashExpired) {
+			t.Fatalf("err = %v, want ErrBlockhashExpired", err)
+		}
+		if len(standIn.sent) != maxSends {
+			t.Errorf("sent %d times, want %d", len(standIn.sent), maxSends)
+		}
+	})
+
+	t.Run("no payer", func(t *testing.T) {
+		standIn, client := newRPCStandIn(t, statusConfirmed)
+		client.minting.payer = nil
+		if _, err := client.mintWithMetaplex(t.Context(), opts); !errors.Is(err, ErrNoPayer) {
+			t.Fatalf("err = %v, want ErrNoPayer", err)
+		}
+		if len(standIn.calls) != 0 {
+			t.Errorf("made RPC calls without a payer: %v", standIn.calls)
+		}
+	})
+}
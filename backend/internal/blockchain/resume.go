@@ -0,0 +1,126 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// PendingMint is a signed mint transaction that may not have landed yet.
+// It is saved before the transaction is sent, so a mint retried after a
+// timeout, a crash or a shutdown resumes it instead of minting again.
+type PendingMint struct {
+	Signature            string `json:"signature"`
+	LastValidBlockHeight uint64 `json:"last_valid_block_height"`
+	// Transaction is the signed transaction, base64 encoded, re-sent as is
+	// while its blockhash is valid
+	Transaction string `json:"transaction"`
+	// MintKey is the secret key of a Metaplex mint's new mint account.
+	// Signing again with it keeps the mint address, so two transactions of
+	// the same mint can never both land.
+	MintKey string `json:"mint_key,omitempty"`
+	// MerkleTree is the tree a compressed mint went into; its leaf is
+	// looked up again once the transaction landed
+	MerkleTree string `json:"merkle_tree,omitempty"`
+}
+
+// saveBeforeSend adapts opts.OnSigned for sendAndConfirm. mint holds what
+// the mint needs to be resumed; each transaction is saved along with it.
+func (opts MintOptions) saveBeforeSend(ctx context.Context, mint PendingMint) func(tx *solana.Transaction, lastValidBlockHeight uint64) error {
+	if opts.OnSigned == nil {
+		return nil
+	}
+	return func(tx *solana.Transaction, lastValidBlockHeight uint64) error {
+		encoded, err := tx.ToBase64()
+		if err != nil {
+			return fmt.Errorf("failed to encode transaction: %w", err)
+		}
+
+		pending := mint
+		pending.Signature = tx.Signatures[0].String()
+		pending.LastValidBlockHeight = lastValidBlockHeight
+		pending.Transaction = encoded
+		return opts.OnSigned(ctx, &pending)
+	}
+}
+
+// resumeTransaction finds out what became of a saved transaction. One that
+// landed is waited for until it reaches the configured commitment; one that
+// is unknown but whose blockhash is still valid is sent again, since it may
+// never have reached a leader. ErrMintNotLanded is only returned once the
+// transaction failed, or the finalized block height passed its last valid
+// one while the transaction, and mint if set, are nowhere on chain.
+func (s *SolanaClient) resumeTransaction(ctx context.Context, pending *PendingMint, mint solana.PublicKey) (*sentTransaction, error) {
+	signature, err := solana.SignatureFromBase58(pending.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid saved signature: %w", err)
+	}
+
+	// The height is read first: a transaction still unknown after it passed
+	// the last valid one can't land anymore
+	height, err := s.BlockHeight(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses, err := s.TransactionStatuses(ctx, []string{pending.Signature})
+	if err != nil {
+		return nil, err
+	}
+	status := statuses[0]
+
+	sent := &sentTransaction{
+		signature:            signature,
+		lastValidBlockHeight: pending.LastValidBlockHeight,
+		status:               status.Status,
+	}
+
+	switch {
+	case status.Status == TxFailed:
+		return nil, fmt.Errorf("transaction %s failed: %s: %w", signature, status.Err, ErrMintNotLanded)
+
+	case status.Status != TxPending:
+		log.Printf("🔁 Resuming transaction %s (%s)", signature, status.Status)
+		if commitmentReached(rpc.ConfirmationStatusType(status.Status), s.minting.commitment) {
+			return sent, nil
+		}
+
+	case height > pending.LastValidBlockHeight:
+		// Only the transaction can create the mint account, so finding it
+		// proves the transaction landed even if the node lost its status
+		if !mint.IsZero() {
+			_, err := s.rpcClient.GetAccountInfoWithOpts(ctx, mint, &rpc.GetAccountInfoOpts{Commitment: rpc.CommitmentFinalized})
+			if err == nil {
+				sent.status = TxFinalized
+				return sent, nil
+			}
+			if !errors.Is(err, rpc.ErrNotFound) {
+				return nil, fmt.Errorf("failed to look up mint %s: %w", mint, err)
+			}
+		}
+		return nil, fmt.Errorf("transaction %s expired: %w", signature, ErrMintNotLanded)
+
+	default:
+		tx, err := solana.TransactionFromBase64(pending.Transaction)
+		if err != nil {
+			return nil, fmt.Errorf("invalid saved transaction: %w", err)
+		}
+		// Preflight would reject a copy that already landed; the status
+		// poll below tells either way
+		if _, err := s.rpcClient.SendTransactionWithOpts(ctx, tx, rpc.TransactionOpts{SkipPreflight: true}); err != nil {
+			log.Printf("⚠️  Failed to re-send transaction %s: %v", signature, err)
+		} else {
+			log.Printf("🔁 Transaction re-sent: %s", signature)
+		}
+	}
+
+	confirmation, err := s.waitForConfirmation(ctx, signature, pending.LastValidBlockHeight)
+	if err != nil {
+		return nil, err
+	}
+	sent.status = string(confirmation)
+	return sent, nil
+}
@@ -0,0 +1,188 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+var errSaveFailed = errors.New("database unavailable")
+
+// signPending signs a mint without sending it, as a worker that saved the
+// transaction and then crashed would leave it
+func signPending(t *testing.T, standIn *rpcStandIn, client *SolanaClient, opts MintOptions) *PendingMint {
+	t.Helper()
+
+	var pending *PendingMint
+	opts.OnSigned = func(ctx context.Context, p *PendingMint) error {
+		pending = p
+		return errSaveFailed
+	}
+	if _, err := client.mintWithMetaplex(t.Context(), opts); !errors.Is(err, errSaveFailed) {
+		t.Fatalf("err = %v, want the save error", err)
+	}
+	if pending == nil || len(standIn.sent) != 0 {
+		t.Fatalf("a transaction that couldn't be saved must not be sent")
+	}
+	return pending
+}
+
+func TestMintSavesBeforeSending(t *testing.T) {
+	standIn, client := newRPCStandIn(t, statusConfirmed)
+	creator, _ := newTestWallet(t)
+
+	var saved []*PendingMint
+	result, err := client.mintWithMetaplex(t.Context(), MintOptions{
+		CreatorWallet: creator,
+		MetadataURI:   "ar://metadata",
+		Title:         "Moment",
+		OnSigned: func(ctx context.Context, p *PendingMint) error {
+			if len(standIn.sent) != 0 {
+				t.Error("transaction sent before it was saved")
+			}
+			saved = append(saved, p)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("mint: %v", err)
+	}
+
+	if len(saved) != 1 {
+		t.Fatalf("saved %d transactions, want 1", len(saved))
+	}
+	pending := saved[0]
+	if pending.Signature != result.Signature || pending.LastValidBlockHeight != recordedLastValidBlockHeight {
+		t.Errorf("saved %s until %d, want %s until %d", pending.Signature, pending.LastValidBlockHeight, result.Signature, recordedLastValidBlockHeight)
+	}
+	if key, err := solana.PrivateKeyFromBase58(pending.MintKey); err != nil || key.PublicKey().String() != result.MintAddress {
+		t.Errorf("saved mint key doesn't match mint %s", result.MintAddress)
+	}
+	if tx, err := solana.TransactionFromBase64(pending.Transaction); err != nil || tx.Signatures[0].String() != result.Signature {
+		t.Errorf("saved transaction isn't the one sent: %v", err)
+	}
+}
+
+func TestMintResumesPendingTransaction(t *testing.T) {
+	creator, _ := newTestWallet(t)
+	opts := MintOptions{CreatorWallet: creator, MetadataURI: "ar://metadata", Title: "Moment"}
+
+	t.Run("landed", func(t *testing.T) {
+		standIn, client := newRPCStandIn(t, statusConfirmed)
+		pending := signPending(t, standIn, client, opts)
+
+		resumed := opts
+		resumed.Pending = pending
+		result, err := client.mintWithMetaplex(t.Context(), resumed)
+		if err != nil {
+			t.Fatalf("resume: %v", err)
+		}
+		if len(standIn.sent) != 0 || result.Signature != pending.Signature || result.Confirmation != TxConfirmed {
+			t.Errorf("sent %d, result = %+v, want the pending transaction confirmed", len(standIn.sent), result)
+		}
+	})
+
+	t.Run("re-sent while valid", func(t *testing.T) {
+		standIn, client := newRPCStandIn(t, statusPending, statusConfirmed)
+		pending := signPending(t, standIn, client, opts)
+
+		resumed := opts
+		resumed.Pending = pending
+		resumed.OnSigned = func(ctx context.Context, p *PendingMint) error {
+			t.Error("a transaction that may still land must not be signed again")
+			return nil
+		}
+		result, err := client.mintWithMetaplex(t.Context(), resumed)
+		if err != nil {
+			t.Fatalf("resume: %v", err)
+		}
+		if len(standIn.sent) != 1 || standIn.sent[0].Signatures[0].String() != pending.Signature {
+			t.Fatalf("want the saved transaction re-sent as is")
+		}
+		if result.Signature != pending.Signature {
+			t.Errorf("signature = %s, want %s", result.Signature, pending.Signature)
+		}
+	})
+
+	t.Run("mint account on chain", func(t *testing.T) {
+		standIn, client := newRPCStandIn(t, statusPending)
+		pending := signPending(t, standIn, client, opts)
+		standIn.blockHeight = recordedLastValidBlockHeight
+		mint, _ := solana.PrivateKeyFromBase58(pending.MintKey)
+		standIn.mints = map[string]bool{mint.PublicKey().String(): true}
+
+		resumed := opts
+		resumed.Pending = pending
+		result, err := client.mintWithMetaplex(t.Context(), resumed)
+		if err != nil {
+			t.Fatalf("resume: %v", err)
+		}
+		if len(standIn.sent) != 0 || result.MintAddress != mint.PublicKey().String() || result.Confirmation != TxFinalized {
+			t.Errorf("sent %d, result = %+v, want mint %s finalized", len(standIn.sent), result, mint.PublicKey())
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		standIn, client := newRPCStandIn(t, statusPending, statusConfirmed)
+		pending := signPending(t, standIn, client, opts)
+		standIn.blockHeight = recordedLastValidBlockHeight
+		standIn.mints = map[string]bool{}
+
+		var saved *PendingMint
+		resumed := opts
+		resumed.Pending = pending
+		resumed.OnSigned = func(ctx context.Context, p *PendingMint) error {
+			saved = p
+			return nil
+		}
+		result, err := client.mintWithMetaplex(t.Context(), resumed)
+		if err != nil {
+			t.Fatalf("resume: %v", err)
+		}
+
+		mint, _ := solana.PrivateKeyFromBase58(pending.MintKey)
+		if len(standIn.sent) != 1 || !standIn.sent[0].IsSigner(mint.PublicKey()) {
+			t.Fatalf("want one new transaction for the same mint")
+		}
+		if saved == nil || saved.Signature == pending.Signature || saved.MintKey != pending.MintKey {
+			t.Errorf("the new transaction should be saved with the same mint key")
+		}
+		if result.MintAddress != mint.PublicKey().String() || result.Signature != saved.Signature {
+			t.Errorf("result = %+v, want mint %s", result, mint.PublicKey())
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		standIn, client := newRPCStandIn(t, statusFailed, statusConfirmed)
+		pending := signPending(t, standIn, client, opts)
+
+		resumed := opts
+		resumed.Pending = pending
+		result, err := client.mintWithMetaplex(t.Context(), resumed)
+		if err != nil {
+			t.Fatalf("resume: %v", err)
+		}
+		if len(standIn.sent) != 1 || result.Signature == pending.Signature {
+			t.Errorf("a failed transaction should be signed again")
+		}
+	})
+
+	t.Run("resume only", func(t *testing.T) {
+		standIn, client := newRPCStandIn(t, statusPending)
+		pending := signPending(t, standIn, client, opts)
+		standIn.blockHeight = recordedLastValidBlockHeight
+		standIn.mints = map[string]bool{}
+
+		resumed := opts
+		resumed.Pending = pending
+		resumed.ResumeOnly = true
+		if _, err := client.mintWithMetaplex(t.Context(), resumed); !errors.Is(err, ErrMintNotLanded) {
+			t.Fatalf("err = %v, want ErrMintNotLanded", err)
+		}
+		if len(standIn.sent) != 0 {
+			t.Errorf("resume only sent %d transactions", len(standIn.sent))
+		}
+	})
+}
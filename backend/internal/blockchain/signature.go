@@ -0,0 +1,40 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// DecodeSignature decodes a wallet signature sent as base58 (Phantom, Solflare)
+// or base64 (mobile wallet adapter)
+func DecodeSignature(encoded string) ([]byte, error) {
+	if sig, err := solana.SignatureFromBase58(encoded); err == nil {
+		return sig[:], nil
+	}
+
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if raw, err := enc.DecodeString(encoded); err == nil && len(raw) == ed25519.SignatureSize {
+			return raw, nil
+		}
+	}
+
+	return nil, fmt.Errorf("signature must be a base58 or base64 encoded %d byte ed25519 signature", ed25519.SignatureSize)
+}
+
+// VerifyWalletSignature checks an ed25519 signature over message against a
+// base58 wallet address (a Solana wallet address is its ed25519 public key)
+func VerifyWalletSignature(walletAddress string, message []byte, signature []byte) (bool, error) {
+	pubKey, err := solana.PublicKeyFromBase58(walletAddress)
+	if err != nil {
+		return false, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	if len(signature) != ed25519.SignatureSize {
+		return false, nil
+	}
+
+	return ed25519.Verify(ed25519.PublicKey(pubKey.Bytes()), message, signature), nil
+}
@@ -0,0 +1,90 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+func newTestWallet(t *testing.T) (string, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return solana.PublicKeyFromBytes(pub).String(), priv
+}
+
+func TestVerifyWalletSignature(t *testing.T) {
+	wallet, priv := newTestWallet(t)
+	otherWallet, otherPriv := newTestWallet(t)
+
+	message := []byte("now.ink wants you to sign in with your Solana account:\n" + wallet + "\n\nNonce: abc123\nIssued At: 2025-11-05T01:23:45Z")
+	signature := ed25519.Sign(priv, message)
+
+	tampered := append([]byte{}, signature...)
+	tampered[0] ^= 0xff
+
+	tests := []struct {
+		name      string
+		wallet    string
+		message   []byte
+		signature []byte
+		want      bool
+		wantErr   bool
+	}{
+		{name: "valid signature", wallet: wallet, message: message, signature: signature, want: true},
+		{name: "signed by another wallet", wallet: wallet, message: message, signature: ed25519.Sign(otherPriv, message)},
+		{name: "claimed by another wallet", wallet: otherWallet, message: message, signature: signature},
+		{name: "different message", wallet: wallet, message: append(message, '!'), signature: signature},
+		{name: "tampered signature", wallet: wallet, message: message, signature: tampered},
+		{name: "zero signature", wallet: wallet, message: message, signature: make([]byte, ed25519.SignatureSize)},
+		{name: "short signature", wallet: wallet, message: message, signature: signature[:32]},
+		{name: "invalid wallet", wallet: "not-a-wallet", message: message, signature: signature, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyWalletSignature(tt.wallet, tt.message, tt.signature)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("valid = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSignature(t *testing.T) {
+	_, priv := newTestWallet(t)
+	signature := ed25519.Sign(priv, []byte("hello"))
+
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr bool
+	}{
+		{name: "base58", encoded: solana.SignatureFromBytes(signature).String()},
+		{name: "base64", encoded: base64.StdEncoding.EncodeToString(signature)},
+		{name: "base64 raw url", encoded: base64.RawURLEncoding.EncodeToString(signature)},
+		{name: "empty", encoded: "", wantErr: true},
+		{name: "wrong length", encoded: base64.StdEncoding.EncodeToString(signature[:10]), wantErr: true},
+		{name: "garbage", encoded: "%%%not-a-signature%%%", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeSignature(tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != string(signature) {
+				t.Errorf("decoded signature does not match original")
+			}
+		})
+	}
+}
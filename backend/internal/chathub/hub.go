@@ -0,0 +1,212 @@
+package chathub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/services/chat"
+	"github.com/alexcolls/now.ink/backend/internal/wshub"
+)
+
+// Peer roles. Only the broadcaster can moderate.
+const (
+	RoleBroadcaster = "broadcaster"
+	RoleViewer      = "viewer"
+)
+
+// Message types sent by clients
+const (
+	TypeMessage  = "message"
+	TypeDelete   = "delete"
+	TypeTimeout  = "timeout"
+	TypeBan      = "ban"
+	TypeUnban    = "unban"
+	TypeSlowMode = "slow_mode"
+)
+
+// Message types sent by the server. Accepted messages are echoed to
+// everyone as TypeMessage and a changed slow mode as TypeSlowMode.
+const (
+	TypeWelcome        = "welcome"
+	TypeMessageDeleted = "message_deleted"
+	TypeUserTimedOut   = "user_timed_out"
+	TypeUserBanned     = "user_banned"
+	TypeUserUnbanned   = "user_unbanned"
+	TypeStreamEnded    = "stream_ended"
+	TypeError          = "error"
+)
+
+// historySize is how many recent messages a joining peer receives
+const historySize = 50
+
+// dbTimeout bounds the chat service calls made for one message
+const dbTimeout = 5 * time.Second
+
+// Message is a message sent by the server
+type Message map[string]interface{}
+
+// request is a message sent by a client
+type request struct {
+	Type      string `json:"type"`
+	Text      string `json:"text"`
+	MessageID int64  `json:"message_id"`
+	UserID    string `json:"user_id"`
+	Seconds   int    `json:"seconds"`
+}
+
+// Hub fans out the chat of live streams to their connected peers. Every
+// message goes through the chat service first, which stores it and applies
+// bans, timeouts, slow mode and rate limits.
+type Hub struct {
+	rooms   *wshub.Rooms
+	service *chat.Service
+}
+
+// NewHub creates an empty chat hub backed by service
+func NewHub(service *chat.Service) *Hub {
+	return &Hub{
+		rooms:   wshub.NewRooms("Chat", nil),
+		service: service,
+	}
+}
+
+// join adds a peer to its stream's room and sends it the recent history
+func (h *Hub) join(p *wshub.Peer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	history, err := h.service.History(ctx, p.StreamID, 0, historySize)
+	if err != nil {
+		return err
+	}
+	slowMode, err := h.service.SlowMode(ctx, p.StreamID)
+	if err != nil {
+		return err
+	}
+
+	h.rooms.Lock()
+	defer h.rooms.Unlock()
+
+	h.rooms.AddLocked(p)
+	h.rooms.SendLocked(p, Message{
+		"type":              TypeWelcome,
+		"stream_id":         p.StreamID,
+		"role":              p.Role,
+		"slow_mode_seconds": slowMode,
+		"history":           history,
+	})
+	return nil
+}
+
+// handle applies one client request
+func (h *Hub) handle(p *wshub.Peer, req request) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if req.Type != TypeMessage && p.Role != RoleBroadcaster {
+		h.rooms.Reply(p, errorMessage("only the broadcaster can moderate chat"))
+		return
+	}
+
+	switch req.Type {
+	case TypeMessage:
+		msg, err := h.service.Post(ctx, p.StreamID, p.UserID, req.Text, p.Role == RoleBroadcaster)
+		if err != nil {
+			h.replyError(p, err)
+			return
+		}
+		h.rooms.Broadcast(p.StreamID, Message{"type": TypeMessage, "message": msg})
+
+	case TypeDelete:
+		if err := h.service.Delete(ctx, p.StreamID, req.MessageID, p.UserID); err != nil {
+			h.replyError(p, err)
+			return
+		}
+		h.rooms.Broadcast(p.StreamID, Message{"type": TypeMessageDeleted, "message_id": req.MessageID})
+
+	case TypeTimeout, TypeBan:
+		duration := time.Duration(req.Seconds) * time.Second
+		if req.Type == TypeBan {
+			duration = 0
+		} else if duration <= 0 {
+			h.rooms.Reply(p, errorMessage("seconds required"))
+			return
+		}
+
+		restriction, err := h.service.Restrict(ctx, p.StreamID, req.UserID, p.UserID, duration)
+		if err != nil {
+			h.replyError(p, err)
+			return
+		}
+
+		if restriction.ExpiresAt != nil {
+			h.rooms.Broadcast(p.StreamID, Message{"type": TypeUserTimedOut, "user_id": req.UserID, "until": restriction.ExpiresAt})
+		} else {
+			h.rooms.Broadcast(p.StreamID, Message{"type": TypeUserBanned, "user_id": req.UserID})
+			h.disconnectUser(p.StreamID, req.UserID, chat.ErrBanned)
+		}
+
+	case TypeUnban:
+		if err := h.service.Unrestrict(ctx, p.StreamID, req.UserID); err != nil {
+			h.replyError(p, err)
+			return
+		}
+		h.rooms.Broadcast(p.StreamID, Message{"type": TypeUserUnbanned, "user_id": req.UserID})
+
+	case TypeSlowMode:
+		if err := h.service.SetSlowMode(ctx, p.StreamID, req.Seconds); err != nil {
+			h.replyError(p, err)
+			return
+		}
+		h.rooms.Broadcast(p.StreamID, Message{"type": TypeSlowMode, "seconds": req.Seconds})
+
+	default:
+		h.rooms.Reply(p, errorMessage("unsupported message type"))
+	}
+}
+
+// disconnectUser drops a user's connections to a stream's chat
+func (h *Hub) disconnectUser(streamID, userID string, reason error) {
+	h.rooms.Lock()
+	defer h.rooms.Unlock()
+
+	for _, p := range h.rooms.PeersLocked(streamID) {
+		if p.UserID == userID {
+			h.rooms.SendLocked(p, errorMessage(reason.Error()))
+			h.rooms.RemoveLocked(p)
+		}
+	}
+}
+
+// CloseStream tells every peer of a stream that it ended and disconnects
+// them. It is called when the stream is ended through the API or by the
+// heartbeat reaper.
+func (h *Hub) CloseStream(streamID, reason string) {
+	h.rooms.Lock()
+	defer h.rooms.Unlock()
+
+	h.rooms.CloseLocked(streamID, Message{"type": TypeStreamEnded, "reason": reason})
+}
+
+// replyError reports a rejected request. Rule violations are the client's
+// to fix; anything else is logged and reported generically.
+func (h *Hub) replyError(p *wshub.Peer, err error) {
+	for _, known := range []error{
+		chat.ErrInvalidMessage, chat.ErrBanned, chat.ErrTimedOut, chat.ErrSlowMode,
+		chat.ErrRateLimited, chat.ErrMessageNotFound, chat.ErrInvalidModeration,
+	} {
+		if errors.Is(err, known) {
+			h.rooms.Reply(p, errorMessage(err.Error()))
+			return
+		}
+	}
+
+	log.Printf("⚠️  Chat request from %s failed: %v", p.ID, err)
+	h.rooms.Reply(p, errorMessage("chat is unavailable, try again"))
+}
+
+func errorMessage(text string) Message {
+	return Message{"type": TypeError, "error": text}
+}
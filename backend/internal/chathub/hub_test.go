@@ -0,0 +1,107 @@
+package chathub
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/alexcolls/now.ink/backend/internal/services/chat"
+	"github.com/alexcolls/now.ink/backend/internal/wshub"
+)
+
+// joinTestPeer puts a connectionless peer in a stream's room without the
+// history lookup join does
+func joinTestPeer(h *Hub, userID, role string) *wshub.Peer {
+	p := wshub.NewPeer(nil, "stream-1", userID, role, 8)
+	h.rooms.Lock()
+	h.rooms.AddLocked(p)
+	h.rooms.Unlock()
+	return p
+}
+
+// next returns the next message queued for a peer
+func next(t *testing.T, p *wshub.Peer) Message {
+	t.Helper()
+
+	select {
+	case data, ok := <-p.Outbox():
+		if !ok {
+			t.Fatalf("peer %s was closed", p.UserID)
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("undecodable message %s: %v", data, err)
+		}
+		return msg
+	default:
+		t.Fatalf("no message queued for peer %s", p.UserID)
+		return nil
+	}
+}
+
+// The requests below are all turned down before the chat service reaches
+// the database
+func TestHubRejectsRequests(t *testing.T) {
+	h := NewHub(chat.NewService())
+	broadcaster := joinTestPeer(h, "1b4e28ba-2fa1-11d2-883f-0016d3cca427", RoleBroadcaster)
+	viewer := joinTestPeer(h, "8c0f2a36-5f55-4b7e-9d7c-4a1f0f1d2b6e", RoleViewer)
+
+	tests := []struct {
+		name string
+		from *wshub.Peer
+		req  request
+		want string
+	}{
+		{"viewer bans", viewer, request{Type: TypeBan, UserID: broadcaster.UserID}, "only the broadcaster can moderate chat"},
+		{"viewer sets slow mode", viewer, request{Type: TypeSlowMode, Seconds: 30}, "only the broadcaster can moderate chat"},
+		{"timeout without seconds", broadcaster, request{Type: TypeTimeout, UserID: viewer.UserID}, "seconds required"},
+		{"ban unknown user", broadcaster, request{Type: TypeBan, UserID: "someone"}, "invalid moderation action: invalid user_id"},
+		{"ban self", broadcaster, request{Type: TypeBan, UserID: broadcaster.UserID}, "invalid moderation action: cannot restrict yourself"},
+		{"slow mode too long", broadcaster, request{Type: TypeSlowMode, Seconds: 3600}, "invalid moderation action: slow mode is 0 to 600 seconds"},
+		{"empty message", viewer, request{Type: TypeMessage, Text: "  "}, "invalid chat message: messages must be 1 to 500 characters"},
+		{"unknown type", broadcaster, request{Type: "shout"}, "unsupported message type"},
+	}
+	for _, tt := range tests {
+		h.handle(tt.from, tt.req)
+
+		msg := next(t, tt.from)
+		if msg["type"] != TypeError || msg["error"] != tt.want {
+			t.Errorf("%s: got %v, want error %q", tt.name, msg, tt.want)
+		}
+		for _, p := range []*wshub.Peer{broadcaster, viewer} {
+			if len(p.Outbox()) != 0 {
+				t.Errorf("%s: rejected request reached %s", tt.name, p.Role)
+			}
+		}
+	}
+}
+
+func TestHubDisconnectUser(t *testing.T) {
+	h := NewHub(chat.NewService())
+	broadcaster := joinTestPeer(h, "owner", RoleBroadcaster)
+	phone := joinTestPeer(h, "viewer", RoleViewer)
+	laptop := joinTestPeer(h, "viewer", RoleViewer)
+
+	h.disconnectUser("stream-1", "viewer", chat.ErrBanned)
+
+	for _, p := range []*wshub.Peer{phone, laptop} {
+		if msg := next(t, p); msg["type"] != TypeError || msg["error"] != chat.ErrBanned.Error() {
+			t.Errorf("banned peer got %v", msg)
+		}
+		if _, ok := <-p.Outbox(); ok {
+			t.Error("banned peer should be disconnected")
+		}
+	}
+
+	h.rooms.Broadcast("stream-1", Message{"type": TypeUserBanned, "user_id": "viewer"})
+	if msg := next(t, broadcaster); msg["type"] != TypeUserBanned {
+		t.Errorf("broadcaster got %v", msg)
+	}
+
+	h.CloseStream("stream-1", "ended")
+	if msg := next(t, broadcaster); msg["type"] != TypeStreamEnded {
+		t.Errorf("broadcaster got %v, want stream_ended", msg)
+	}
+	if h.rooms.Len() != 0 {
+		t.Error("closed stream should be dropped")
+	}
+}
@@ -0,0 +1,41 @@
+package chathub
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/alexcolls/now.ink/backend/internal/wshub"
+	"github.com/gofiber/contrib/websocket"
+)
+
+const (
+	// maxMessageSize bounds one client request
+	maxMessageSize = 4 * 1024
+	// sendBuffer is how many messages may queue for a peer
+	sendBuffer = 64
+)
+
+// Serve runs a chat connection. The route must have set the "stream_id",
+// "user_id" and "chat_role" locals before upgrading.
+func (h *Hub) Serve(conn *websocket.Conn) {
+	streamID, _ := conn.Locals("stream_id").(string)
+	userID, _ := conn.Locals("user_id").(string)
+	role, _ := conn.Locals("chat_role").(string)
+
+	p := wshub.NewPeer(conn, streamID, userID, role, sendBuffer)
+	if err := h.join(p); err != nil {
+		log.Printf("⚠️  Failed to join chat of stream %s: %v", streamID, err)
+		p.Reject(errorMessage("chat is unavailable"), websocket.CloseInternalServerErr, "chat is unavailable")
+		return
+	}
+
+	h.rooms.Serve(p, maxMessageSize, func(data []byte) {
+		var req request
+		if err := json.Unmarshal(data, &req); err != nil {
+			h.rooms.Reply(p, errorMessage("invalid message"))
+			return
+		}
+
+		h.handle(p, req)
+	})
+}
@@ -0,0 +1,6 @@
+-- now.ink Sign-In-With-Solana login messages
+-- Each nonce now carries the full SIWS message the wallet is asked to sign
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS message TEXT;
+
+COMMENT ON COLUMN sessions.message IS 'Sign-In-With-Solana message issued with the nonce, verified byte for byte on login';
@@ -0,0 +1,25 @@
+-- now.ink refresh tokens
+-- Short-lived access tokens are paired with rotating refresh tokens. Every
+-- login starts a token family; each refresh rotates the token within the
+-- family and revoking the family logs that device out.
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    family_id UUID NOT NULL,
+    parent_id UUID REFERENCES refresh_tokens(id) ON DELETE SET NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    wallet_address VARCHAR(44) NOT NULL,
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    rotated_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user ON refresh_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
+
+COMMENT ON TABLE refresh_tokens IS 'Rotating refresh tokens, stored as SHA-256 hashes';
+COMMENT ON COLUMN refresh_tokens.family_id IS 'Login the token descends from; access tokens carry it as the sid claim';
+COMMENT ON COLUMN refresh_tokens.rotated_at IS 'Set when the token is exchanged; presenting it again revokes the whole family';
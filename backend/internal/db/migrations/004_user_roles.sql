@@ -0,0 +1,11 @@
+-- now.ink user roles
+-- Roles decide which scopes a wallet login receives (see models.DefaultScopes)
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user';
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('user', 'moderator', 'admin'));
+
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role) WHERE role != 'user';
+
+COMMENT ON COLUMN users.role IS 'user, moderator or admin - moderators and admins get the admin route group';
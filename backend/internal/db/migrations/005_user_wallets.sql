@@ -0,0 +1,25 @@
+-- now.ink linked wallets
+-- A user may log in with any linked wallet. users.wallet_address mirrors the
+-- primary wallet, which is the one NFTs are minted to.
+
+CREATE TABLE IF NOT EXISTS user_wallets (
+    wallet_address VARCHAR(44) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_wallets_user ON user_wallets(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_wallets_primary ON user_wallets(user_id) WHERE is_primary;
+
+-- Every existing user starts with their login wallet as primary
+INSERT INTO user_wallets (wallet_address, user_id, is_primary, created_at)
+SELECT wallet_address, id, TRUE, created_at FROM users
+ON CONFLICT (wallet_address) DO NOTHING;
+
+-- Nonces are issued either for login or for linking another wallet
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS purpose VARCHAR(10) NOT NULL DEFAULT 'login';
+
+COMMENT ON TABLE user_wallets IS 'Wallets a user has proven control of; any of them can be used to log in';
+COMMENT ON COLUMN user_wallets.is_primary IS 'Primary wallet receives minted NFTs; mirrored in users.wallet_address';
+COMMENT ON COLUMN sessions.purpose IS 'login or link - a nonce is only accepted by the flow it was issued for';
@@ -0,0 +1,22 @@
+-- now.ink API keys
+-- Named, scoped credentials for bots, integrations and server-to-server
+-- access. Only a SHA-256 hash of each key is stored.
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(16) UNIQUE NOT NULL,
+    key_hash VARCHAR(64) UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    rate_limit_per_minute INT NOT NULL DEFAULT 600,
+    created_at TIMESTAMP DEFAULT NOW(),
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user ON api_keys(user_id);
+
+COMMENT ON TABLE api_keys IS 'Per-client API keys, sent in the X-API-Key header';
+COMMENT ON COLUMN api_keys.prefix IS 'Non-secret key prefix shown in listings and request logs';
@@ -0,0 +1,13 @@
+-- now.ink shared rate limit counters
+-- Used when RATE_LIMIT_STORE=postgres so every API instance shares limits
+
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_counters (
+    key TEXT NOT NULL,
+    window_start TIMESTAMP NOT NULL,
+    count INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (key, window_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_counters_window ON rate_limit_counters(window_start);
+
+COMMENT ON TABLE rate_limit_counters IS 'Fixed-window request counters per client key (IP, wallet or API key)';
@@ -0,0 +1,19 @@
+-- now.ink background job history
+-- Every run of a scheduled maintenance job is recorded here. Only the API
+-- instance holding the job's advisory lock runs it.
+
+CREATE TABLE IF NOT EXISTS job_runs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    job_name VARCHAR(64) NOT NULL,
+    instance_id VARCHAR(128) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'running',
+    details TEXT,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_job_started ON job_runs(job_name, started_at DESC);
+
+COMMENT ON TABLE job_runs IS 'Run history of in-process scheduled jobs';
+COMMENT ON COLUMN job_runs.status IS 'running, succeeded or failed';
@@ -0,0 +1,29 @@
+-- now.ink login sessions
+-- One row per login (refresh token family) so users can see and revoke the
+-- devices they are signed in on. The id is the refresh token family id that
+-- access tokens carry as their sid claim.
+
+CREATE TABLE IF NOT EXISTS auth_sessions (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    wallet_address VARCHAR(44) NOT NULL,
+    device_name VARCHAR(100),
+    user_agent TEXT,
+    ip_address VARCHAR(45),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_auth_sessions_user ON auth_sessions(user_id);
+
+-- Backfill logins that were made before sessions were recorded
+INSERT INTO auth_sessions (id, user_id, wallet_address, created_at, last_seen_at, revoked_at)
+SELECT family_id, user_id, MIN(wallet_address), MIN(created_at), MAX(created_at),
+       CASE WHEN BOOL_AND(revoked_at IS NOT NULL) THEN MAX(revoked_at) END
+FROM refresh_tokens
+GROUP BY family_id, user_id
+ON CONFLICT (id) DO NOTHING;
+
+COMMENT ON TABLE auth_sessions IS 'Logins per device; revoking one rejects its access and refresh tokens';
+COMMENT ON COLUMN auth_sessions.last_seen_at IS 'Last authenticated request, updated at most once a minute';
@@ -0,0 +1,18 @@
+-- now.ink stream heartbeats
+-- Broadcasters send periodic heartbeats while live; streams that stop sending
+-- them are ended by the scheduler. Every ended stream records why it ended.
+
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS last_heartbeat_at TIMESTAMP;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS end_reason VARCHAR(16);
+
+ALTER TABLE streams DROP CONSTRAINT IF EXISTS streams_end_reason_check;
+ALTER TABLE streams ADD CONSTRAINT streams_end_reason_check
+    CHECK (end_reason IS NULL OR end_reason IN ('user', 'timeout', 'admin'));
+
+-- Give streams that are live right now a grace period from the migration
+UPDATE streams SET last_heartbeat_at = NOW() WHERE is_live = true AND last_heartbeat_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_streams_live_heartbeat ON streams(last_heartbeat_at) WHERE is_live = TRUE;
+
+COMMENT ON COLUMN streams.last_heartbeat_at IS 'Last heartbeat from the broadcaster while live';
+COMMENT ON COLUMN streams.end_reason IS 'Why the stream ended: user, timeout (no heartbeat) or admin';
@@ -0,0 +1,21 @@
+-- now.ink viewer stats
+-- Viewer presence comes from signaling connections. Counts are kept in memory
+-- and written back in batches; peak and unique viewers stay on the stream
+-- after it ends for creator stats.
+
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS peak_viewers INT NOT NULL DEFAULT 0;
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS unique_viewers INT NOT NULL DEFAULT 0;
+
+UPDATE streams SET viewer_count = 0 WHERE viewer_count IS NULL OR is_live = false;
+
+CREATE TABLE IF NOT EXISTS stream_viewers (
+    stream_id UUID NOT NULL REFERENCES streams(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    first_seen_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (stream_id, user_id)
+);
+
+COMMENT ON TABLE stream_viewers IS 'Distinct users who watched each stream';
+COMMENT ON COLUMN streams.viewer_count IS 'Current concurrent viewers while live, 0 once ended';
+COMMENT ON COLUMN streams.peak_viewers IS 'Most concurrent viewers at any time';
+COMMENT ON COLUMN streams.unique_viewers IS 'Distinct users who watched';
@@ -0,0 +1,28 @@
+-- now.ink resumable uploads
+-- Recordings are uploaded in chunks so a dropped mobile connection can resume
+-- from the last stored offset instead of starting over. Incomplete uploads
+-- expire and are removed by the scheduler.
+
+CREATE TABLE IF NOT EXISTS stream_uploads (
+    id UUID PRIMARY KEY,
+    stream_id UUID NOT NULL REFERENCES streams(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    size BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    content_type VARCHAR(64) NOT NULL,
+    file_path TEXT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'uploading',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP,
+    CONSTRAINT stream_uploads_status_check CHECK (status IN ('uploading', 'completed', 'consumed')),
+    CONSTRAINT stream_uploads_offset_check CHECK (upload_offset >= 0 AND upload_offset <= size)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stream_uploads_stream ON stream_uploads(stream_id);
+CREATE INDEX IF NOT EXISTS idx_stream_uploads_expires_at ON stream_uploads(expires_at) WHERE status <> 'consumed';
+
+COMMENT ON TABLE stream_uploads IS 'Resumable (tus-style) recording uploads';
+COMMENT ON COLUMN stream_uploads.upload_offset IS 'Bytes stored so far; the next chunk must start here';
+COMMENT ON COLUMN stream_uploads.status IS 'uploading, completed (all bytes stored) or consumed (handed to the mint path)';
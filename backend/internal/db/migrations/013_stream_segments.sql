@@ -0,0 +1,30 @@
+-- now.ink live segment ingest
+-- Broadcasters push fMP4 segments (HLS or CMAF) while live so a recording
+-- exists even if the phone dies mid-stream. When the stream ends the
+-- segments are concatenated in order into the file the mint path consumes.
+
+CREATE TABLE IF NOT EXISTS stream_segments (
+    stream_id UUID NOT NULL REFERENCES streams(id) ON DELETE CASCADE,
+    seq INT NOT NULL,
+    size BIGINT NOT NULL,
+    sha256 VARCHAR(64) NOT NULL,
+    duration_ms INT,
+    file_path TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (stream_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS stream_recordings (
+    stream_id UUID PRIMARY KEY REFERENCES streams(id) ON DELETE CASCADE,
+    file_path TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    segment_count INT NOT NULL,
+    missing_segments INT NOT NULL DEFAULT 0,
+    duration_ms BIGINT,
+    assembled_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+COMMENT ON TABLE stream_segments IS 'Media segments pushed while live; removed once assembled';
+COMMENT ON COLUMN stream_segments.seq IS 'Segment order; -1 is the fMP4 init segment';
+COMMENT ON TABLE stream_recordings IS 'Recordings assembled server-side from live segments';
+COMMENT ON COLUMN stream_recordings.missing_segments IS 'Gaps in the sequence that never arrived';
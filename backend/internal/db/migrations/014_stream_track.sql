@@ -0,0 +1,8 @@
+-- now.ink stream location tracks
+-- streams.location is only where a stream started; broadcasters walk while
+-- streaming, so GPS samples are appended to a trajectory. Each vertex's M
+-- value is the sample time in epoch seconds.
+
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS track GEOMETRY(LINESTRINGM, 4326);
+
+COMMENT ON COLUMN streams.track IS 'GPS trajectory starting at location; M is the sample time (epoch seconds)';
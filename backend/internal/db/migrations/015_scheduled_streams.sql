@@ -0,0 +1,18 @@
+-- now.ink scheduled streams
+-- Broadcasters can announce a stream ahead of time with a planned start
+-- time and place. A scheduled stream goes live when it is started, or
+-- expires if it never does. is_live stays in step with status = 'live'.
+
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'live';
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS scheduled_start_at TIMESTAMP;
+
+UPDATE streams SET status = 'ended' WHERE is_live = false AND status = 'live';
+
+ALTER TABLE streams DROP CONSTRAINT IF EXISTS streams_status_check;
+ALTER TABLE streams ADD CONSTRAINT streams_status_check
+    CHECK (status IN ('scheduled', 'live', 'ended', 'expired'));
+
+CREATE INDEX IF NOT EXISTS idx_streams_scheduled ON streams(scheduled_start_at) WHERE status = 'scheduled';
+
+COMMENT ON COLUMN streams.status IS 'scheduled, live, ended, or expired (scheduled but never started)';
+COMMENT ON COLUMN streams.scheduled_start_at IS 'Planned start time of a scheduled stream';
@@ -0,0 +1,32 @@
+-- now.ink live chat
+-- Each live stream has a chat channel over WebSocket. Messages are kept so
+-- the chat can be exported with the minted recording; broadcasters can
+-- delete messages, time out or ban viewers, and turn on slow mode.
+
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS chat_slow_mode_seconds INT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS chat_messages (
+    id BIGSERIAL PRIMARY KEY,
+    stream_id UUID NOT NULL REFERENCES streams(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    deleted_at TIMESTAMP,
+    deleted_by UUID REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS chat_restrictions (
+    stream_id UUID NOT NULL REFERENCES streams(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    restricted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (stream_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_messages_stream ON chat_messages(stream_id, id);
+CREATE INDEX IF NOT EXISTS idx_chat_messages_user ON chat_messages(stream_id, user_id, created_at DESC);
+
+COMMENT ON COLUMN streams.chat_slow_mode_seconds IS 'Minimum seconds between chat messages from one viewer (0 = off)';
+COMMENT ON TABLE chat_messages IS 'Live chat history; deleted messages are kept but hidden';
+COMMENT ON TABLE chat_restrictions IS 'Chat timeouts (expires_at set) and bans (expires_at NULL)';
@@ -0,0 +1,33 @@
+-- now.ink mint jobs
+-- Saving a stream queues a mint job instead of uploading and minting inside
+-- the request. Workers run each step (video upload, metadata upload, mint)
+-- and record its result, so a restart resumes from the last completed step.
+
+CREATE TABLE IF NOT EXISTS mint_jobs (
+    id UUID PRIMARY KEY,
+    stream_id UUID REFERENCES streams(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',
+    request JSONB NOT NULL,
+    arweave_tx_id VARCHAR(64),
+    metadata_uri TEXT,
+    mint_address VARCHAR(64),
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    locked_by TEXT,
+    locked_until TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP,
+    CONSTRAINT mint_jobs_status_check
+        CHECK (status IN ('queued', 'video_uploaded', 'metadata_uploaded', 'minting', 'minted', 'failed'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_mint_jobs_pending ON mint_jobs(next_attempt_at)
+    WHERE status NOT IN ('minted', 'failed');
+CREATE INDEX IF NOT EXISTS idx_mint_jobs_stream ON mint_jobs(stream_id);
+
+COMMENT ON TABLE mint_jobs IS 'Durable mint pipeline; each step stores its result before the next runs';
+COMMENT ON COLUMN mint_jobs.request IS 'The mint request (video path, title, location, ...) as queued';
+COMMENT ON COLUMN mint_jobs.locked_until IS 'Lease of the worker running the job; expired leases are picked up again';
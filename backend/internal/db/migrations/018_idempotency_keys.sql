@@ -0,0 +1,38 @@
+-- now.ink idempotent minting
+-- Clients may send an Idempotency-Key with a save; a retry with the same key
+-- gets the stored response back instead of running again. Independently of
+-- keys, a stream has at most one mint that hasn't failed.
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope TEXT NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code INT,
+    content_type VARCHAR(128),
+    location TEXT,
+    body BYTEA,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created ON idempotency_keys(created_at);
+
+-- Keep the oldest of any duplicate mints queued before the index existed
+UPDATE mint_jobs j
+SET status = 'failed', last_error = 'duplicate mint for stream', completed_at = NOW(), updated_at = NOW()
+WHERE j.status <> 'failed'
+  AND EXISTS (
+      SELECT 1 FROM mint_jobs o
+      WHERE o.stream_id = j.stream_id
+        AND o.status <> 'failed'
+        AND (o.created_at, o.id) < (j.created_at, j.id)
+  );
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_mint_jobs_active_stream ON mint_jobs(stream_id)
+    WHERE status <> 'failed';
+
+COMMENT ON TABLE idempotency_keys IS 'Responses stored per Idempotency-Key, replayed to retries';
+COMMENT ON COLUMN idempotency_keys.scope IS 'Who the key belongs to and which route it was used on';
+COMMENT ON COLUMN idempotency_keys.request_hash IS 'SHA-256 of the request; reusing a key for a different request is rejected';
+COMMENT ON COLUMN idempotency_keys.status_code IS 'NULL while the first request is still running';
@@ -0,0 +1,31 @@
+-- now.ink compressed NFTs
+-- With SOLANA_MINT_MODE=compressed moments are minted as Bubblegum compressed
+-- NFTs into platform owned Merkle trees. A compressed NFT has no mint
+-- account: mint_address holds its asset ID and it lives at a leaf of a tree.
+
+CREATE TABLE IF NOT EXISTS merkle_trees (
+    address VARCHAR(44) PRIMARY KEY,
+    max_depth INT NOT NULL,
+    max_buffer_size INT NOT NULL,
+    canopy_depth INT NOT NULL,
+    network VARCHAR(20) NOT NULL,
+    create_signature VARCHAR(88),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    retired_at TIMESTAMP
+);
+
+-- One tree is filled at a time per network
+CREATE UNIQUE INDEX IF NOT EXISTS idx_merkle_trees_active ON merkle_trees(network)
+    WHERE retired_at IS NULL;
+
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS compressed BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS merkle_tree VARCHAR(44);
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS leaf_index BIGINT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_nfts_leaf ON nfts(merkle_tree, leaf_index)
+    WHERE merkle_tree IS NOT NULL;
+
+COMMENT ON TABLE merkle_trees IS 'Merkle trees compressed NFTs are minted into';
+COMMENT ON COLUMN merkle_trees.retired_at IS 'Set once the tree is full; NULL for the tree being filled';
+COMMENT ON COLUMN nfts.compressed IS 'Bubblegum compressed NFT; mint_address is the asset ID';
+COMMENT ON COLUMN nfts.leaf_index IS 'Position of a compressed NFT in merkle_tree';
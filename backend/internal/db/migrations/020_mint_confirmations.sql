@@ -0,0 +1,25 @@
+-- now.ink mint confirmation tracking
+-- A mint is saved once its transaction reaches SOLANA_COMMITMENT. A watcher
+-- keeps polling the signature until it is finalized; a mint whose
+-- transaction failed or expired after all is marked as such and queued
+-- again.
+
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS mint_signature VARCHAR(88);
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS last_valid_block_height BIGINT;
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS confirmation_status VARCHAR(20) NOT NULL DEFAULT 'untracked'
+    CHECK (confirmation_status IN ('untracked', 'pending', 'processed', 'confirmed', 'finalized', 'failed', 'expired'));
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS confirmation_slot BIGINT;
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS confirmation_error TEXT;
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS confirmation_checked_at TIMESTAMP;
+ALTER TABLE nfts ADD COLUMN IF NOT EXISTS finalized_at TIMESTAMP;
+
+ALTER TABLE streams ADD COLUMN IF NOT EXISTS mint_status VARCHAR(20);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_nfts_signature ON nfts(mint_signature);
+CREATE INDEX IF NOT EXISTS idx_nfts_unsettled ON nfts(created_at)
+    WHERE confirmation_status IN ('pending', 'processed', 'confirmed');
+
+COMMENT ON COLUMN nfts.mint_signature IS 'Transaction that minted the NFT; NULL for mock and legacy mints';
+COMMENT ON COLUMN nfts.last_valid_block_height IS 'Block height after which the mint transaction can no longer land';
+COMMENT ON COLUMN nfts.confirmation_status IS 'Commitment the mint transaction reached, or failed/expired; untracked without a signature';
+COMMENT ON COLUMN streams.mint_status IS 'Confirmation status of the stream''s mint';
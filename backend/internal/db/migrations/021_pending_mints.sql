@@ -0,0 +1,14 @@
+-- now.ink pending mint transactions
+-- The mint step saves each signed transaction on its job before sending
+-- it. A job retried after a timeout, a lost lease or a crash checks that
+-- transaction and re-sends it instead of minting a second NFT; the mint is
+-- only signed again, with the same mint keypair, once the transaction
+-- provably failed or expired without landing.
+
+ALTER TABLE mint_jobs ADD COLUMN IF NOT EXISTS pending_mint JSONB;
+ALTER TABLE mint_jobs ADD COLUMN IF NOT EXISTS mint_signature VARCHAR(88);
+ALTER TABLE mint_jobs ADD COLUMN IF NOT EXISTS last_valid_block_height BIGINT;
+
+COMMENT ON COLUMN mint_jobs.pending_mint IS 'Signed mint transaction and mint keypair of the last send; cleared once finalized';
+COMMENT ON COLUMN mint_jobs.mint_signature IS 'Signature of the last mint transaction sent';
+COMMENT ON COLUMN mint_jobs.last_valid_block_height IS 'Block height after which the last mint transaction can no longer land';
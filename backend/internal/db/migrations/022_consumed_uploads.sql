@@ -0,0 +1,10 @@
+-- now.ink consumed upload cleanup
+-- A consumed upload's file is only kept while its mint job needs it: the
+-- scheduler deletes it once the job is minted, and a job that fails hands
+-- the upload back as completed so the stream can be saved from it again.
+-- Jobs are found by the video path in their request.
+
+CREATE INDEX IF NOT EXISTS idx_mint_jobs_video_path ON mint_jobs((request->>'video_url'));
+CREATE INDEX IF NOT EXISTS idx_stream_uploads_consumed ON stream_uploads(file_path) WHERE status = 'consumed';
+
+COMMENT ON COLUMN stream_uploads.status IS 'uploading, completed (all bytes stored) or consumed (handed to a mint job; removed once it is minted, completed again if it fails)';
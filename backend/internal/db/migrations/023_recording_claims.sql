@@ -0,0 +1,16 @@
+-- now.ink recording assembly claims
+-- Assembling a recording claims the stream's row first and concatenates the
+-- segments afterwards, outside any transaction, so a long recording doesn't
+-- hold a connection and the segment lock. A claim that outlives its timeout
+-- was abandoned and is taken over by the next request.
+
+ALTER TABLE stream_recordings ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'assembled';
+ALTER TABLE stream_recordings ADD COLUMN IF NOT EXISTS claimed_by VARCHAR(64);
+ALTER TABLE stream_recordings ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP;
+
+ALTER TABLE stream_recordings DROP CONSTRAINT IF EXISTS stream_recordings_status_check;
+ALTER TABLE stream_recordings ADD CONSTRAINT stream_recordings_status_check CHECK (status IN ('assembling', 'assembled'));
+
+COMMENT ON COLUMN stream_recordings.status IS 'assembling (claimed, segments being concatenated) or assembled';
+COMMENT ON COLUMN stream_recordings.claimed_by IS 'Claim of the request assembling the recording';
+COMMENT ON COLUMN stream_recordings.claimed_until IS 'When an unfinished claim is considered abandoned';
@@ -0,0 +1,124 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Errors returned by Begin
+var (
+	ErrKeyReused  = errors.New("idempotency key was used for a different request")
+	ErrInProgress = errors.New("a request with this idempotency key is still in progress")
+)
+
+// Response is a stored response
+type Response struct {
+	StatusCode  int
+	ContentType string
+	Location    string
+	Body        []byte
+}
+
+// Store keeps keys in the idempotency_keys table, shared by every API
+// instance using the same database
+type Store struct {
+	db *sql.DB
+	// staleAfter is how long an unfinished request holds its key; after
+	// that the process is assumed to have died and a retry may run
+	staleAfter time.Duration
+}
+
+// NewStore creates a store backed by the idempotency_keys table
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db, staleAfter: 15 * time.Minute}
+}
+
+// Begin claims key for a request. It returns nil when the caller should run
+// the request and then Complete or Release the key, or the stored response
+// when the key was already used for the same request.
+func (s *Store) Begin(ctx context.Context, scope, key, requestHash string) (*Response, error) {
+	query := `
+		INSERT INTO idempotency_keys (scope, key, request_hash)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (scope, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, created_at = NOW()
+		WHERE idempotency_keys.status_code IS NULL
+		  AND idempotency_keys.request_hash = EXCLUDED.request_hash
+		  AND idempotency_keys.created_at < NOW() - $4::FLOAT8 * INTERVAL '1 second'
+		RETURNING key
+	`
+
+	var claimed string
+	err := s.db.QueryRowContext(ctx, query, scope, key, requestHash, s.staleAfter.Seconds()).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	// The key is taken; replay it if it finished
+	var storedHash string
+	var statusCode sql.NullInt64
+	var contentType, location sql.NullString
+	var body []byte
+
+	err = s.db.QueryRowContext(ctx,
+		`SELECT request_hash, status_code, content_type, location, body FROM idempotency_keys WHERE scope = $1 AND key = $2`,
+		scope, key,
+	).Scan(&storedHash, &statusCode, &contentType, &location, &body)
+	if err == sql.ErrNoRows {
+		// Released by a failed request in the meantime; the client retries
+		return nil, ErrInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if storedHash != requestHash {
+		return nil, ErrKeyReused
+	}
+	if !statusCode.Valid {
+		return nil, ErrInProgress
+	}
+
+	return &Response{
+		StatusCode:  int(statusCode.Int64),
+		ContentType: contentType.String,
+		Location:    location.String,
+		Body:        body,
+	}, nil
+}
+
+// Complete stores the response for a key claimed with Begin
+func (s *Store) Complete(ctx context.Context, scope, key string, resp *Response) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $3, content_type = $4, location = NULLIF($5, ''), body = $6, completed_at = NOW()
+		WHERE scope = $1 AND key = $2
+	`
+
+	_, err := s.db.ExecContext(ctx, query, scope, key, resp.StatusCode, resp.ContentType, resp.Location, resp.Body)
+	return err
+}
+
+// Release frees a key claimed with Begin without storing a response, so the
+// request can be retried
+func (s *Store) Release(ctx context.Context, scope, key string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND status_code IS NULL`,
+		scope, key,
+	)
+	return err
+}
+
+// Sweep deletes keys created before cutoff
+func (s *Store) Sweep(ctx context.Context, cutoff time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE created_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
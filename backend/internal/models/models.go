@@ -14,10 +14,52 @@ type User struct {
 	Bio           *string    `json:"bio,omitempty"`
 	AvatarURL     *string    `json:"avatar_url,omitempty"`
 	IsPremium     bool       `json:"is_premium"`
+	Role          string     `json:"role"`
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
 }
 
+// User roles
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Token scopes limit what an access token may do
+const (
+	ScopeStreamsWrite    = "streams:write"
+	ScopeNFTsMint        = "nfts:mint"
+	ScopeSocialWrite     = "social:write"
+	ScopeAdminModeration = "admin:moderation"
+	ScopeAdminOps        = "admin:ops"
+)
+
+// IsValidRole reports whether role is a known user role
+func IsValidRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
+}
+
+// DefaultScopes returns the scopes granted to a wallet login with the given role
+func DefaultScopes(role string) []string {
+	scopes := []string{ScopeStreamsWrite, ScopeNFTsMint, ScopeSocialWrite}
+	switch role {
+	case RoleModerator:
+		scopes = append(scopes, ScopeAdminModeration)
+	case RoleAdmin:
+		scopes = append(scopes, ScopeAdminModeration, ScopeAdminOps)
+	}
+	return scopes
+}
+
+// UserWallet represents a wallet linked to a user
+type UserWallet struct {
+	WalletAddress string    `json:"wallet_address"`
+	UserID        uuid.UUID `json:"user_id"`
+	IsPrimary     bool      `json:"is_primary"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
 // Stream represents a live or recorded stream
 type Stream struct {
 	ID              uuid.UUID  `json:"id"`
@@ -65,10 +107,25 @@ type Session struct {
 	ID            uuid.UUID `json:"id"`
 	WalletAddress string    `json:"wallet_address"`
 	Nonce         string    `json:"nonce"`
+	Message       string    `json:"message"`
 	CreatedAt     time.Time `json:"created_at"`
 	ExpiresAt     time.Time `json:"expires_at"`
 }
 
+// SIWSMessage is a Sign-In-With-Solana (CAIP-122) login message
+type SIWSMessage struct {
+	Domain         string     `json:"domain"`
+	Address        string     `json:"address"`
+	Statement      string     `json:"statement,omitempty"`
+	URI            string     `json:"uri"`
+	Version        string     `json:"version"`
+	ChainID        string     `json:"chain_id"`
+	Nonce          string     `json:"nonce"`
+	IssuedAt       time.Time  `json:"issued_at"`
+	ExpirationTime *time.Time `json:"expiration_time,omitempty"`
+	RequestID      string     `json:"request_id,omitempty"`
+}
+
 // StartStreamRequest is the request body for starting a stream
 type StartStreamRequest struct {
 	Title     *string  `json:"title,omitempty"`
@@ -90,7 +147,10 @@ type GetNonceRequest struct {
 
 // GetNonceResponse is the response for getting an auth nonce
 type GetNonceResponse struct {
-	Nonce string `json:"nonce"`
+	Nonce     string      `json:"nonce"`
+	Message   string      `json:"message"`
+	Fields    SIWSMessage `json:"fields"`
+	ExpiresAt time.Time   `json:"expires_at"`
 }
 
 // VerifyWalletRequest is the request body for wallet verification
@@ -98,10 +158,96 @@ type VerifyWalletRequest struct {
 	WalletAddress string `json:"wallet_address"`
 	Signature     string `json:"signature"`
 	Nonce         string `json:"nonce"`
+	Message       string `json:"message,omitempty"`
+	DeviceName    string `json:"device_name,omitempty"`
 }
 
 // VerifyWalletResponse is the response for wallet verification
 type VerifyWalletResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+	User             User      `json:"user"`
+}
+
+// RefreshToken represents a stored (hashed) refresh token
+type RefreshToken struct {
+	ID            uuid.UUID  `json:"id"`
+	FamilyID      uuid.UUID  `json:"family_id"`
+	ParentID      *uuid.UUID `json:"parent_id,omitempty"`
+	UserID        uuid.UUID  `json:"user_id"`
+	WalletAddress string     `json:"wallet_address"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RotatedAt     *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+}
+
+// AuthSession is one login of a user on a device
+type AuthSession struct {
+	ID            uuid.UUID  `json:"id"`
+	UserID        uuid.UUID  `json:"user_id"`
+	WalletAddress string     `json:"wallet_address"`
+	DeviceName    string     `json:"device_name,omitempty"`
+	UserAgent     string     `json:"user_agent,omitempty"`
+	IPAddress     string     `json:"ip_address,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	LastSeenAt    time.Time  `json:"last_seen_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	Current       bool       `json:"current"`
+}
+
+// RefreshTokenRequest is the request body for refreshing an access token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenResponse is the response for a token refresh
+type TokenResponse struct {
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// UpdateUserRoleRequest is the request body for changing a user's role
+type UpdateUserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// LinkWalletRequest is the request body for linking another wallet
+type LinkWalletRequest struct {
+	WalletAddress string `json:"wallet_address"`
+	Signature     string `json:"signature"`
+	Nonce         string `json:"nonce"`
+	Message       string `json:"message,omitempty"`
+}
+
+// APIKey represents a named, scoped API key. The key itself is only returned
+// once, when it is created.
+type APIKey struct {
+	ID                 uuid.UUID  `json:"id"`
+	UserID             uuid.UUID  `json:"user_id"`
+	Name               string     `json:"name"`
+	Prefix             string     `json:"prefix"`
+	Scopes             []string   `json:"scopes"`
+	RateLimitPerMinute int        `json:"rate_limit_per_minute"`
+	CreatedAt          time.Time  `json:"created_at"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt         *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt          *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreateAPIKeyRequest is the request body for creating an API key
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreateAPIKeyResponse is the response for creating an API key
+type CreateAPIKeyResponse struct {
+	Key    string `json:"key"`
+	APIKey APIKey `json:"api_key"`
 }
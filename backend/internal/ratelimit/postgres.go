@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore is a Store shared by every API instance using the same
+// database
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore creates a store backed by the rate_limit_counters table
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Increment implements Store
+func (p *PostgresStore) Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	windowStart := time.Now().UTC().Truncate(window)
+
+	query := `
+		INSERT INTO rate_limit_counters (key, window_start, count)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (key, window_start) DO UPDATE SET count = rate_limit_counters.count + 1
+		RETURNING count
+	`
+
+	var count int
+	if err := p.db.QueryRowContext(ctx, query, key, windowStart).Scan(&count); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return count, windowStart.Add(window), nil
+}
+
+// Sweep deletes counters whose window started before cutoff
+func (p *PostgresStore) Sweep(ctx context.Context, cutoff time.Time) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM rate_limit_counters WHERE window_start < $1`, cutoff)
+	return err
+}
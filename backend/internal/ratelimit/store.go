@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store counts hits per key in fixed windows. The in-memory store is enough
+// for a single API instance; deployments with several instances need a
+// shared store (see PostgresStore) so limits hold across all of them.
+type Store interface {
+	// Increment records one hit for key in the current window and returns
+	// the number of hits so far and when the window resets
+	Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+}
+
+// MemoryStore is a Store kept in process memory
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]*counter
+	lastSweep time.Time
+}
+
+type counter struct {
+	count   int
+	resetAt time.Time
+}
+
+// NewMemoryStore creates an in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters:  map[string]*counter{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Increment implements Store
+func (m *MemoryStore) Increment(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.sweep(now)
+
+	c, ok := m.counters[key]
+	if !ok || !now.Before(c.resetAt) {
+		c = &counter{resetAt: now.Truncate(window).Add(window)}
+		m.counters[key] = c
+	}
+
+	c.count++
+	return c.count, c.resetAt, nil
+}
+
+// sweep drops expired counters at most once a minute so memory stays bounded
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	for key, c := range m.counters {
+		if !now.Before(c.resetAt) {
+			delete(m.counters, key)
+		}
+	}
+	m.lastSweep = now
+}
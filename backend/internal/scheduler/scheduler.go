@@ -0,0 +1,252 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/google/uuid"
+)
+
+// Job is a named task that runs periodically
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// Jitter adds a random delay of up to this much before every run so
+	// instances started together don't all race for the lock at once
+	Jitter  time.Duration
+	Timeout time.Duration
+	// Run does the work and returns a short summary for the run history
+	Run func(ctx context.Context) (string, error)
+}
+
+// Run is one recorded execution of a job
+type Run struct {
+	ID         uuid.UUID  `json:"id"`
+	JobName    string     `json:"job_name"`
+	InstanceID string     `json:"instance_id"`
+	Status     string     `json:"status"`
+	Details    string     `json:"details,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// JobInfo describes a registered job
+type JobInfo struct {
+	Name     string `json:"name"`
+	Interval string `json:"interval"`
+	Jitter   string `json:"jitter"`
+}
+
+// Scheduler runs jobs in the background of the API process. Each run takes a
+// Postgres advisory lock so only one instance runs a given job at a time, and
+// is skipped if any instance finished the job less than an interval ago.
+type Scheduler struct {
+	jobs       []Job
+	instanceID string
+	wg         sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler for jobs
+func NewScheduler(jobs ...Job) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		jobs:       jobs,
+		instanceID: fmt.Sprintf("%s-%d", host, os.Getpid()),
+	}
+}
+
+// Jobs lists the registered jobs
+func (s *Scheduler) Jobs() []JobInfo {
+	infos := make([]JobInfo, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		infos = append(infos, JobInfo{
+			Name:     job.Name,
+			Interval: job.Interval.String(),
+			Jitter:   job.Jitter.String(),
+		})
+	}
+	return infos
+}
+
+// Start launches every job loop; they stop when ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, job)
+	}
+	log.Printf("⏰ Scheduler started with %d jobs", len(s.jobs))
+}
+
+// Wait blocks until all job loops have stopped
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	defer s.wg.Done()
+
+	for {
+		delay := job.Interval
+		if job.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(job.Jitter)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if err := s.runOnce(ctx, job); err != nil {
+			log.Printf("⚠️  Job %s: %v", job.Name, err)
+		}
+	}
+}
+
+// runOnce runs job if this instance gets its advisory lock and the job is due
+func (s *Scheduler) runOnce(ctx context.Context, job Job) error {
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	lockKey := advisoryLockKey(job.Name)
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, lockKey).Scan(&locked); err != nil {
+		return fmt.Errorf("failed to take lock: %w", err)
+	}
+	if !locked {
+		// Another instance is running this job
+		return nil
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	// The lock only keeps runs from overlapping; every instance's loop still
+	// fires once per interval, so skip if another instance just ran the job
+	var recent bool
+	query := `
+		SELECT EXISTS(
+			SELECT 1 FROM job_runs
+			WHERE job_name = $1 AND finished_at > NOW() - make_interval(secs => $2)
+		)
+	`
+	if err := conn.QueryRowContext(ctx, query, job.Name, job.Interval.Seconds()).Scan(&recent); err != nil {
+		return fmt.Errorf("failed to check recent runs: %w", err)
+	}
+	if recent {
+		return nil
+	}
+
+	run := &Run{
+		ID:         uuid.New(),
+		JobName:    job.Name,
+		InstanceID: s.instanceID,
+		Status:     "running",
+		StartedAt:  time.Now(),
+	}
+
+	_, err = db.DB.ExecContext(ctx,
+		`INSERT INTO job_runs (id, job_name, instance_id, status, started_at) VALUES ($1, $2, $3, $4, $5)`,
+		run.ID, run.JobName, run.InstanceID, run.Status, run.StartedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record run: %w", err)
+	}
+
+	runCtx := ctx
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, job.Timeout)
+		defer cancel()
+	}
+
+	details, runErr := safeRun(runCtx, job)
+
+	run.Status = "succeeded"
+	var errText sql.NullString
+	if runErr != nil {
+		run.Status = "failed"
+		errText = sql.NullString{String: runErr.Error(), Valid: true}
+	}
+
+	_, err = db.DB.ExecContext(context.Background(),
+		`UPDATE job_runs SET status = $1, details = $2, error = $3, finished_at = NOW() WHERE id = $4`,
+		run.Status, details, errText, run.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record run result: %w", err)
+	}
+
+	return runErr
+}
+
+// safeRun keeps a panicking job from taking the API process down
+func safeRun(ctx context.Context, job Job) (details string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+// advisoryLockKey maps a job name to a stable advisory lock key
+func advisoryLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("nowink:job:" + name))
+	return int64(h.Sum64())
+}
+
+// RecentRuns returns the latest runs across all jobs, or of one job when
+// jobName is set
+func RecentRuns(ctx context.Context, jobName string, limit int) ([]*Run, error) {
+	query := `
+		SELECT id, job_name, instance_id, status, details, error, started_at, finished_at
+		FROM job_runs
+		WHERE $1 = '' OR job_name = $1
+		ORDER BY started_at DESC
+		LIMIT $2
+	`
+
+	rows, err := db.DB.QueryContext(ctx, query, jobName, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []*Run{}
+	for rows.Next() {
+		run := &Run{}
+		var details, errText sql.NullString
+		var finishedAt sql.NullTime
+
+		err := rows.Scan(
+			&run.ID, &run.JobName, &run.InstanceID, &run.Status,
+			&details, &errText, &run.StartedAt, &finishedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		run.Details = details.String
+		run.Error = errText.String
+		if finishedAt.Valid {
+			run.FinishedAt = &finishedAt.Time
+		}
+
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
@@ -0,0 +1,381 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/alexcolls/now.ink/backend/internal/ratelimit"
+	"github.com/google/uuid"
+)
+
+// Chat limits
+const (
+	// MaxMessageLength is the longest message in characters
+	MaxMessageLength = 500
+	// MaxSlowMode is the longest slow mode a broadcaster can set
+	MaxSlowMode = 10 * time.Minute
+	// MaxTimeout is the longest a viewer can be timed out; bans are permanent
+	MaxTimeout = 7 * 24 * time.Hour
+)
+
+// Chat errors
+var (
+	ErrInvalidMessage    = errors.New("invalid chat message")
+	ErrBanned            = errors.New("banned from this chat")
+	ErrTimedOut          = errors.New("timed out in this chat")
+	ErrSlowMode          = errors.New("slow mode is on")
+	ErrRateLimited       = errors.New("sending messages too fast")
+	ErrMessageNotFound   = errors.New("chat message not found")
+	ErrInvalidModeration = errors.New("invalid moderation action")
+)
+
+// Service stores live chat and enforces its moderation rules
+type Service struct {
+	rateLimit    ratelimit.Store
+	messageLimit int
+	rateWindow   time.Duration
+	bannedWords  *regexp.Regexp
+}
+
+// NewService creates a new chat service
+func NewService() *Service {
+	messageLimit, err := strconv.Atoi(os.Getenv("CHAT_MESSAGES_PER_WINDOW"))
+	if err != nil || messageLimit <= 0 {
+		messageLimit = 5
+	}
+
+	rateWindow, err := time.ParseDuration(os.Getenv("CHAT_RATE_WINDOW"))
+	if err != nil || rateWindow <= 0 {
+		rateWindow = 10 * time.Second
+	}
+
+	return &Service{
+		rateLimit:    ratelimit.NewMemoryStore(),
+		messageLimit: messageLimit,
+		rateWindow:   rateWindow,
+		bannedWords:  bannedWordsPattern(os.Getenv("CHAT_BANNED_WORDS")),
+	}
+}
+
+// SetRateLimitStore replaces the default in-memory store, e.g. with a shared
+// store when running several API instances
+func (s *Service) SetRateLimitStore(store ratelimit.Store) {
+	s.rateLimit = store
+}
+
+// bannedWordsPattern matches any of a comma-separated list of words.
+// Longer words are tried first, so a word that starts with a shorter banned
+// word is still found as a whole.
+func bannedWordsPattern(list string) *regexp.Regexp {
+	var words []string
+	for _, word := range strings.Split(list, ",") {
+		if word = strings.TrimSpace(word); word != "" {
+			words = append(words, regexp.QuoteMeta(word))
+		}
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(words, func(a, b string) int { return len(b) - len(a) })
+	return regexp.MustCompile(`(?i)(?:` + strings.Join(words, "|") + `)`)
+}
+
+// maskBannedWords replaces banned words standing on their own with
+// asterisks. RE2's \b only knows ASCII word characters, so word boundaries
+// are checked here against Unicode letters, marks and digits.
+func (s *Service) maskBannedWords(body string) string {
+	if s.bannedWords == nil {
+		return body
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range s.bannedWords.FindAllStringIndex(body, -1) {
+		start, end := loc[0], loc[1]
+		before, _ := utf8.DecodeLastRuneInString(body[:start])
+		after, _ := utf8.DecodeRuneInString(body[end:])
+		if isWordRune(before) || isWordRune(after) {
+			continue
+		}
+		b.WriteString(body[last:start])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(body[start:end])))
+		last = end
+	}
+	b.WriteString(body[last:])
+	return b.String()
+}
+
+// isWordRune reports whether r can be part of a word
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// Message is one chat message
+type Message struct {
+	ID        int64     `json:"id"`
+	StreamID  string    `json:"stream_id"`
+	UserID    string    `json:"user_id"`
+	Username  string    `json:"username,omitempty"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Restriction is a viewer's timeout, or a ban when ExpiresAt is nil
+type Restriction struct {
+	StreamID  string     `json:"stream_id"`
+	UserID    string     `json:"user_id"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Transcript is a stream's chat as exported with its recording
+type Transcript struct {
+	StreamID   string     `json:"stream_id"`
+	ExportedAt time.Time  `json:"exported_at"`
+	Messages   []*Message `json:"messages"`
+}
+
+// Post checks a message against the stream's moderation rules and stores
+// it. Banned words are masked rather than rejected. The broadcaster is not
+// held to slow mode.
+func (s *Service) Post(ctx context.Context, streamID, userID, body string, isBroadcaster bool) (*Message, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || utf8.RuneCountInString(body) > MaxMessageLength {
+		return nil, fmt.Errorf("%w: messages must be 1 to %d characters", ErrInvalidMessage, MaxMessageLength)
+	}
+
+	// A store failure lets the message through rather than taking chat down
+	count, _, err := s.rateLimit.Increment(ctx, "chat:"+streamID+":"+userID, s.rateWindow)
+	if err != nil {
+		log.Printf("⚠️  Chat rate limit store error: %v", err)
+	} else if count > s.messageLimit {
+		return nil, ErrRateLimited
+	}
+
+	query := `
+		SELECT r.user_id IS NOT NULL, r.expires_at,
+		       GREATEST(0, s.chat_slow_mode_seconds - EXTRACT(EPOCH FROM (NOW() - MAX(m.created_at))))::INT
+		FROM streams s
+		LEFT JOIN chat_restrictions r
+		       ON r.stream_id = s.id AND r.user_id = $2 AND (r.expires_at IS NULL OR r.expires_at > NOW())
+		LEFT JOIN chat_messages m
+		       ON m.stream_id = s.id AND m.user_id = $2
+		WHERE s.id = $1
+		GROUP BY s.id, r.user_id, r.expires_at
+	`
+
+	var restricted bool
+	var expiresAt sql.NullTime
+	var wait sql.NullInt64
+	if err := db.DB.QueryRowContext(ctx, query, streamID, userID).Scan(&restricted, &expiresAt, &wait); err != nil {
+		return nil, fmt.Errorf("failed to check chat rules: %w", err)
+	}
+	if err := checkRules(restricted, expiresAt, wait.Int64, isBroadcaster); err != nil {
+		return nil, err
+	}
+
+	body = s.maskBannedWords(body)
+
+	insert := `
+		INSERT INTO chat_messages (stream_id, user_id, body)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, (SELECT username FROM users WHERE id = $2)
+	`
+
+	msg := &Message{StreamID: streamID, UserID: userID, Body: body}
+	var username sql.NullString
+	if err := db.DB.QueryRowContext(ctx, insert, streamID, userID, body).Scan(&msg.ID, &msg.CreatedAt, &username); err != nil {
+		return nil, fmt.Errorf("failed to store chat message: %w", err)
+	}
+	msg.Username = username.String
+
+	return msg, nil
+}
+
+// checkRules applies a sender's ban or timeout (a restriction expiring at
+// expiresAt, or never) and the seconds slow mode still makes them wait
+func checkRules(restricted bool, expiresAt sql.NullTime, wait int64, isBroadcaster bool) error {
+	if restricted {
+		if expiresAt.Valid {
+			return fmt.Errorf("%w until %s", ErrTimedOut, expiresAt.Time.Format(time.RFC3339))
+		}
+		return ErrBanned
+	}
+	if !isBroadcaster && wait > 0 {
+		return fmt.Errorf("%w: wait %ds", ErrSlowMode, wait)
+	}
+	return nil
+}
+
+// Delete hides a message from the stream's chat and its export
+func (s *Service) Delete(ctx context.Context, streamID string, messageID int64, moderatorID string) error {
+	query := `
+		UPDATE chat_messages
+		SET deleted_at = NOW(), deleted_by = $3
+		WHERE id = $1 AND stream_id = $2 AND deleted_at IS NULL
+	`
+
+	result, err := db.DB.ExecContext(ctx, query, messageID, streamID, moderatorID)
+	if err != nil {
+		return fmt.Errorf("failed to delete chat message: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+// Restrict times a viewer out for duration, or bans them when duration is 0.
+// A new restriction replaces the previous one.
+func (s *Service) Restrict(ctx context.Context, streamID, userID, moderatorID string, duration time.Duration) (*Restriction, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, fmt.Errorf("%w: invalid user_id", ErrInvalidModeration)
+	}
+	if userID == moderatorID {
+		return nil, fmt.Errorf("%w: cannot restrict yourself", ErrInvalidModeration)
+	}
+	if duration < 0 || duration > MaxTimeout {
+		return nil, fmt.Errorf("%w: timeouts last up to %s", ErrInvalidModeration, MaxTimeout)
+	}
+
+	query := `
+		INSERT INTO chat_restrictions (stream_id, user_id, restricted_by, expires_at)
+		VALUES ($1, $2, $3, CASE WHEN $4::FLOAT8 > 0 THEN NOW() + make_interval(secs => $4::FLOAT8) END)
+		ON CONFLICT (stream_id, user_id) DO UPDATE
+		SET restricted_by = EXCLUDED.restricted_by, expires_at = EXCLUDED.expires_at, created_at = NOW()
+		RETURNING expires_at
+	`
+
+	restriction := &Restriction{StreamID: streamID, UserID: userID}
+	var expiresAt sql.NullTime
+	err := db.DB.QueryRowContext(ctx, query, streamID, userID, moderatorID, duration.Seconds()).Scan(&expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restrict chat user: %w", err)
+	}
+	if expiresAt.Valid {
+		restriction.ExpiresAt = &expiresAt.Time
+	}
+
+	return restriction, nil
+}
+
+// Unrestrict lifts a viewer's timeout or ban
+func (s *Service) Unrestrict(ctx context.Context, streamID, userID string) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return fmt.Errorf("%w: invalid user_id", ErrInvalidModeration)
+	}
+
+	_, err := db.DB.ExecContext(ctx, `DELETE FROM chat_restrictions WHERE stream_id = $1 AND user_id = $2`, streamID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to lift chat restriction: %w", err)
+	}
+	return nil
+}
+
+// IsBanned reports whether a user is banned (not just timed out) from a
+// stream's chat
+func (s *Service) IsBanned(ctx context.Context, streamID, userID string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM chat_restrictions WHERE stream_id = $1 AND user_id = $2 AND expires_at IS NULL)`
+
+	var banned bool
+	err := db.DB.QueryRowContext(ctx, query, streamID, userID).Scan(&banned)
+	return banned, err
+}
+
+// SetSlowMode sets the minimum gap between one viewer's messages; 0 turns
+// slow mode off
+func (s *Service) SetSlowMode(ctx context.Context, streamID string, seconds int) error {
+	if seconds < 0 || seconds > int(MaxSlowMode.Seconds()) {
+		return fmt.Errorf("%w: slow mode is 0 to %d seconds", ErrInvalidModeration, int(MaxSlowMode.Seconds()))
+	}
+
+	_, err := db.DB.ExecContext(ctx, `UPDATE streams SET chat_slow_mode_seconds = $2 WHERE id = $1`, streamID, seconds)
+	if err != nil {
+		return fmt.Errorf("failed to set slow mode: %w", err)
+	}
+	return nil
+}
+
+// SlowMode returns a stream's slow mode in seconds
+func (s *Service) SlowMode(ctx context.Context, streamID string) (int, error) {
+	var seconds int
+	err := db.DB.QueryRowContext(ctx, `SELECT chat_slow_mode_seconds FROM streams WHERE id = $1`, streamID).Scan(&seconds)
+	return seconds, err
+}
+
+// History returns up to limit messages before the message beforeID (or the
+// latest when beforeID is 0), oldest first
+func (s *Service) History(ctx context.Context, streamID string, beforeID int64, limit int) ([]*Message, error) {
+	query := `
+		SELECT * FROM (
+			SELECT m.id, m.user_id, u.username, m.body, m.created_at
+			FROM chat_messages m
+			LEFT JOIN users u ON u.id = m.user_id
+			WHERE m.stream_id = $1 AND m.deleted_at IS NULL AND ($2::BIGINT = 0 OR m.id < $2::BIGINT)
+			ORDER BY m.id DESC
+			LIMIT $3
+		) recent
+		ORDER BY id ASC
+	`
+
+	return s.queryMessages(ctx, streamID, query, streamID, beforeID, limit)
+}
+
+// Export returns the whole chat of a stream for archiving with its recording
+func (s *Service) Export(ctx context.Context, streamID string) (*Transcript, error) {
+	query := `
+		SELECT m.id, m.user_id, u.username, m.body, m.created_at
+		FROM chat_messages m
+		LEFT JOIN users u ON u.id = m.user_id
+		WHERE m.stream_id = $1 AND m.deleted_at IS NULL
+		ORDER BY m.id ASC
+	`
+
+	messages, err := s.queryMessages(ctx, streamID, query, streamID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transcript{
+		StreamID:   streamID,
+		ExportedAt: time.Now(),
+		Messages:   messages,
+	}, nil
+}
+
+func (s *Service) queryMessages(ctx context.Context, streamID, query string, args ...interface{}) ([]*Message, error) {
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*Message{}
+	for rows.Next() {
+		msg := &Message{StreamID: streamID}
+		var username sql.NullString
+		if err := rows.Scan(&msg.ID, &msg.UserID, &username, &msg.Body, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		msg.Username = username.String
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
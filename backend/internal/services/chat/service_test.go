@@ -0,0 +1,107 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaskBannedWords(t *testing.T) {
+	s := &Service{bannedWords: bannedWordsPattern(" darn, heck ,mierda,scheiß,darnit")}
+
+	tests := map[string]string{
+		"well darn it":        "well **** it",
+		"DARN, Heck!":         "****, ****!",
+		"darnit":              "******",
+		"darned good":         "darned good",
+		"hecktic_heck":        "hecktic_heck",
+		"¡mierda!":            "¡******!",
+		"mierdaña":            "mierdaña",
+		"ñmierda":             "ñmierda",
+		"scheiß drauf":        "****** drauf",
+		"2heck heck2 (heck)":  "2heck heck2 (****)",
+		"nothing to see here": "nothing to see here",
+	}
+	for body, want := range tests {
+		if got := s.maskBannedWords(body); got != want {
+			t.Errorf("mask(%q) = %q, want %q", body, got, want)
+		}
+	}
+
+	if got := (&Service{}).maskBannedWords("darn"); got != "darn" {
+		t.Errorf("no banned words: mask = %q", got)
+	}
+	if bannedWordsPattern(" , ") != nil {
+		t.Error("an empty list should not compile a pattern")
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name          string
+		restricted    bool
+		expiresAt     sql.NullTime
+		wait          int64
+		isBroadcaster bool
+		want          error
+	}{
+		{"allowed", false, sql.NullTime{}, 0, false, nil},
+		{"banned", true, sql.NullTime{}, 0, false, ErrBanned},
+		{"timed out", true, sql.NullTime{Time: until, Valid: true}, 0, false, ErrTimedOut},
+		{"broadcaster banned", true, sql.NullTime{}, 0, true, ErrBanned},
+		{"slow mode", false, sql.NullTime{}, 12, false, ErrSlowMode},
+		{"broadcaster in slow mode", false, sql.NullTime{}, 12, true, nil},
+	}
+	for _, tt := range tests {
+		err := checkRules(tt.restricted, tt.expiresAt, tt.wait, tt.isBroadcaster)
+		if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	err := checkRules(false, sql.NullTime{}, 12, false)
+	if !strings.Contains(err.Error(), "wait 12s") {
+		t.Errorf("slow mode error %q should say how long to wait", err)
+	}
+}
+
+// The checks below run before the database is touched
+func TestModerationValidation(t *testing.T) {
+	s := NewService()
+	ctx := context.Background()
+	viewer := "8c0f2a36-5f55-4b7e-9d7c-4a1f0f1d2b6e"
+	moderator := "1b4e28ba-2fa1-11d2-883f-0016d3cca427"
+
+	if _, err := s.Restrict(ctx, "stream", "not-a-uuid", moderator, time.Minute); !errors.Is(err, ErrInvalidModeration) {
+		t.Errorf("invalid user: err = %v", err)
+	}
+	if _, err := s.Restrict(ctx, "stream", moderator, moderator, 0); !errors.Is(err, ErrInvalidModeration) {
+		t.Errorf("self ban: err = %v", err)
+	}
+	if _, err := s.Restrict(ctx, "stream", viewer, moderator, MaxTimeout+time.Second); !errors.Is(err, ErrInvalidModeration) {
+		t.Errorf("long timeout: err = %v", err)
+	}
+	if _, err := s.Restrict(ctx, "stream", viewer, moderator, -time.Second); !errors.Is(err, ErrInvalidModeration) {
+		t.Errorf("negative timeout: err = %v", err)
+	}
+	if err := s.Unrestrict(ctx, "stream", "not-a-uuid"); !errors.Is(err, ErrInvalidModeration) {
+		t.Errorf("unrestrict invalid user: err = %v", err)
+	}
+
+	for _, seconds := range []int{-1, int(MaxSlowMode.Seconds()) + 1} {
+		if err := s.SetSlowMode(ctx, "stream", seconds); !errors.Is(err, ErrInvalidModeration) {
+			t.Errorf("slow mode %d: err = %v", seconds, err)
+		}
+	}
+
+	for _, body := range []string{"", "   ", strings.Repeat("é", MaxMessageLength+1)} {
+		if _, err := s.Post(ctx, "stream", viewer, body, false); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("post %d chars: err = %v", len(body), err)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package nft
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/blockchain"
+	"github.com/alexcolls/now.ink/backend/internal/db"
+)
+
+// confirmationUntracked is the status of mints without a transaction to
+// watch: mock mints and mints from before signatures were stored
+const confirmationUntracked = "untracked"
+
+// watchBatch is how many unsettled mints a watcher run checks
+const watchBatch = 500
+
+// unknownExpiryAge is when a pending mint saved without its last valid
+// block height counts as expired. A blockhash lives for 150 blocks, about a
+// minute, so a transaction the node still doesn't know by then never landed.
+const unknownExpiryAge = time.Hour
+
+// ErrNFTNotFound is returned for unknown mint addresses
+var ErrNFTNotFound = errors.New("NFT not found")
+
+// Confirmation is how far an NFT's mint transaction got on chain
+type Confirmation struct {
+	MintAddress string     `json:"mint_address"`
+	Signature   string     `json:"signature,omitempty"`
+	Status      string     `json:"status"`
+	Slot        *int64     `json:"slot,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Network     string     `json:"network"`
+	CheckedAt   *time.Time `json:"checked_at,omitempty"`
+	FinalizedAt *time.Time `json:"finalized_at,omitempty"`
+	// Final is set once the status won't change anymore
+	Final bool `json:"final"`
+}
+
+// WatchResult summarizes a watcher run
+type WatchResult struct {
+	Checked   int
+	Finalized int
+	// Requeued mints failed or expired after being saved and are minted again
+	Requeued int
+}
+
+// confirmationStatus is the status a new mint is saved with
+func confirmationStatus(mint *blockchain.MintResult) string {
+	if mint.Signature == "" {
+		return confirmationUntracked
+	}
+	if mint.Confirmation == "" {
+		return blockchain.TxPending
+	}
+	return mint.Confirmation
+}
+
+// GetConfirmation returns the confirmation state of an NFT's mint
+func (s *Service) GetConfirmation(ctx context.Context, mintAddress string) (*Confirmation, error) {
+	query := `
+		SELECT mint_address, mint_signature, confirmation_status, confirmation_slot, confirmation_error,
+		       confirmation_checked_at, finalized_at
+		FROM nfts
+		WHERE mint_address = $1
+	`
+
+	c := &Confirmation{Network: s.solanaClient.GetNetwork()}
+	var signature, confirmationError sql.NullString
+	var slot sql.NullInt64
+	var checkedAt, finalizedAt sql.NullTime
+
+	err := db.DB.QueryRowContext(ctx, query, mintAddress).Scan(
+		&c.MintAddress,
+		&signature,
+		&c.Status,
+		&slot,
+		&confirmationError,
+		&checkedAt,
+		&finalizedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrNFTNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.Signature = signature.String
+	c.Error = confirmationError.String
+	if slot.Valid {
+		c.Slot = &slot.Int64
+	}
+	if checkedAt.Valid {
+		c.CheckedAt = &checkedAt.Time
+	}
+	if finalizedAt.Valid {
+		c.FinalizedAt = &finalizedAt.Time
+	}
+	switch c.Status {
+	case blockchain.TxFinalized, blockchain.TxFailed, blockchain.TxExpired, confirmationUntracked:
+		c.Final = true
+	}
+
+	return c, nil
+}
+
+// unsettledMint is a saved mint whose transaction isn't finalized yet
+type unsettledMint struct {
+	mintAddress          string
+	signature            string
+	lastValidBlockHeight uint64
+	status               string
+	// aged is set once the mint is older than unknownExpiryAge
+	aged bool
+}
+
+// WatchConfirmations polls the transactions of mints that aren't finalized
+// yet and records how far they got. A mint whose transaction failed, or
+// expired without landing, is queued to be minted again.
+func (s *Service) WatchConfirmations(ctx context.Context) (*WatchResult, error) {
+	mints, err := unsettledMints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &WatchResult{Checked: len(mints)}
+	if len(mints) == 0 {
+		return result, nil
+	}
+
+	signatures := make([]string, len(mints))
+	for i, m := range mints {
+		signatures[i] = m.signature
+	}
+
+	statuses, err := s.solanaClient.TransactionStatuses(ctx, signatures)
+	if err != nil {
+		return nil, err
+	}
+
+	var height uint64
+	for i, m := range mints {
+		status := statuses[i]
+
+		switch status.Status {
+		case blockchain.TxFailed:
+			if err := s.requeueMint(ctx, m, blockchain.TxFailed, status.Err); err != nil {
+				return result, err
+			}
+			result.Requeued++
+
+		case blockchain.TxPending:
+			// A transaction the cluster confirmed doesn't get rolled back; if
+			// the node lost track of it, keep asking rather than mint twice
+			if m.status == blockchain.TxConfirmed || (m.lastValidBlockHeight == 0 && !m.aged) {
+				if err := touchConfirmation(ctx, m.mintAddress); err != nil {
+					return result, err
+				}
+				continue
+			}
+			if m.lastValidBlockHeight == 0 {
+				if err := s.requeueMint(ctx, m, blockchain.TxExpired, "transaction unknown long after its blockhash expired"); err != nil {
+					return result, err
+				}
+				result.Requeued++
+				continue
+			}
+			if height == 0 {
+				if height, err = s.solanaClient.BlockHeight(ctx); err != nil {
+					return result, err
+				}
+			}
+			if height <= m.lastValidBlockHeight {
+				if err := touchConfirmation(ctx, m.mintAddress); err != nil {
+					return result, err
+				}
+				continue
+			}
+			if err := s.requeueMint(ctx, m, blockchain.TxExpired, "transaction expired before it was finalized"); err != nil {
+				return result, err
+			}
+			result.Requeued++
+
+		default:
+			if err := updateConfirmation(ctx, m.mintAddress, status); err != nil {
+				return result, err
+			}
+			if status.Status == blockchain.TxFinalized {
+				result.Finalized++
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// unsettledMints returns the oldest mints still waiting for finality
+func unsettledMints(ctx context.Context) ([]*unsettledMint, error) {
+	query := `
+		SELECT mint_address, mint_signature, COALESCE(last_valid_block_height, 0), confirmation_status,
+		       created_at < NOW() - $2::FLOAT8 * INTERVAL '1 second'
+		FROM nfts
+		WHERE confirmation_status IN ('pending', 'processed', 'confirmed')
+		  AND mint_signature IS NOT NULL
+		ORDER BY created_at
+		LIMIT $1
+	`
+
+	rows, err := db.DB.QueryContext(ctx, query, watchBatch, unknownExpiryAge.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unsettled mints: %w", err)
+	}
+	defer rows.Close()
+
+	mints := []*unsettledMint{}
+	for rows.Next() {
+		m := &unsettledMint{}
+		if err := rows.Scan(&m.mintAddress, &m.signature, &m.lastValidBlockHeight, &m.status, &m.aged); err != nil {
+			return nil, err
+		}
+		mints = append(mints, m)
+	}
+
+	return mints, rows.Err()
+}
+
+// updateConfirmation records the commitment a mint reached on the NFT and
+// its stream
+func updateConfirmation(ctx context.Context, mintAddress string, status blockchain.TransactionStatus) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE nfts
+		SET confirmation_status = $2, confirmation_slot = $3, confirmation_checked_at = NOW(),
+		    finalized_at = CASE WHEN $2 = 'finalized' THEN NOW() END
+		WHERE mint_address = $1
+	`
+	if _, err := tx.ExecContext(ctx, query, mintAddress, status.Status, int64(status.Slot)); err != nil {
+		return fmt.Errorf("failed to update confirmation: %w", err)
+	}
+
+	// A finalized mint won't be resumed, so its signed transaction and mint
+	// keypair aren't needed anymore
+	if status.Status == blockchain.TxFinalized {
+		_, err := tx.ExecContext(ctx, `UPDATE mint_jobs SET pending_mint = NULL WHERE mint_address = $1`, mintAddress)
+		if err != nil {
+			return fmt.Errorf("failed to update mint job: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE streams SET mint_status = $2 WHERE nft_mint_address = $1`,
+		mintAddress, status.Status,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update stream: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// touchConfirmation records that a mint was checked and is still pending
+func touchConfirmation(ctx context.Context, mintAddress string) error {
+	_, err := db.DB.ExecContext(ctx,
+		`UPDATE nfts SET confirmation_checked_at = NOW() WHERE mint_address = $1`,
+		mintAddress,
+	)
+	return err
+}
+
+// requeueMint marks a mint whose transaction failed or expired, detaches it
+// from its stream and sends its job back to the mint step. The job still
+// has the transaction saved: it checks it again and only then signs the
+// same mint again. The uploads are reused.
+func (s *Service) requeueMint(ctx context.Context, m *unsettledMint, status, reason string) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The leaf of a compressed mint that never landed is free again
+	query := `
+		UPDATE nfts
+		SET confirmation_status = $2, confirmation_error = NULLIF($3, ''), confirmation_checked_at = NOW(),
+		    leaf_index = NULL
+		WHERE mint_address = $1
+	`
+	if _, err := tx.ExecContext(ctx, query, m.mintAddress, status, reason); err != nil {
+		return fmt.Errorf("failed to update confirmation: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE streams SET nft_mint_address = NULL, mint_status = $2 WHERE nft_mint_address = $1`,
+		m.mintAddress, status,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update stream: %w", err)
+	}
+
+	query = `
+		UPDATE mint_jobs
+		SET status = $2, mint_address = NULL, attempts = 0, last_error = $3, completed_at = NULL,
+		    next_attempt_at = NOW(), updated_at = NOW()
+		WHERE mint_address = $1 AND status = $4
+	`
+	result, err := tx.ExecContext(ctx, query, m.mintAddress, JobMinting, fmt.Sprintf("mint %s %s: %s", m.signature, status, reason), JobMinted)
+	if err != nil {
+		return fmt.Errorf("failed to requeue mint job: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		log.Printf("🔁 Mint %s %s, minting again", m.mintAddress, status)
+		s.wakeWorkers()
+	} else {
+		log.Printf("⚠️  Mint %s %s and has no job to retry", m.mintAddress, status)
+	}
+
+	return nil
+}
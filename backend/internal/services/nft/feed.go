@@ -3,6 +3,7 @@ package nft
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/alexcolls/now.ink/backend/internal/db"
 )
@@ -10,8 +11,10 @@ import (
 // FeedItem represents an NFT in a user's feed
 type FeedItem struct {
 	NFTDetails
-	CreatorUsername *string `json:"creator_username"`
-	CreatorAvatar   *string `json:"creator_avatar"`
+	Views           int       `json:"views"`
+	CreatedAt       time.Time `json:"created_at"`
+	CreatorUsername *string   `json:"creator_username"`
+	CreatorAvatar   *string   `json:"creator_avatar"`
 }
 
 // GetUserFeed gets chronological feed from users that userID follows
@@ -33,9 +36,11 @@ func (s *Service) GetUserFeed(ctx context.Context, userID string, limit, offset
 			u.username,
 			u.avatar_url
 		FROM nfts n
-		INNER JOIN users u ON n.creator_wallet = u.wallet_address
+		INNER JOIN user_wallets w ON n.creator_wallet = w.wallet_address
+		INNER JOIN users u ON w.user_id = u.id
 		INNER JOIN follows f ON u.id = f.following_id
 		WHERE f.follower_id = $1
+		  AND n.confirmation_status NOT IN ('failed', 'expired')
 		ORDER BY n.created_at DESC
 		LIMIT $2 OFFSET $3
 	`
@@ -81,10 +86,10 @@ func (s *Service) GetUserFeed(ctx context.Context, userID string, limit, offset
 			item.VideoURL = videoURL.String
 		}
 		if thumbnailURL.Valid {
-			item.ThumbnailURL = &thumbnailURL.String
+			item.ThumbnailURL = thumbnailURL.String
 		}
 		if durationSeconds.Valid {
-			item.DurationSeconds = int(durationSeconds.Int64)
+			item.Duration = int(durationSeconds.Int64)
 		}
 		if views.Valid {
 			item.Views = int(views.Int64)
@@ -0,0 +1,611 @@
+package nft
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/blockchain"
+	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/google/uuid"
+)
+
+// Mint job states, in pipeline order
+const (
+	JobQueued           = "queued"
+	JobVideoUploaded    = "video_uploaded"
+	JobMetadataUploaded = "metadata_uploaded"
+	JobMinting          = "minting"
+	JobMinted           = "minted"
+	JobFailed           = "failed"
+)
+
+// Mint job errors
+var (
+	// ErrJobNotFound is returned for unknown mint jobs and jobs of other users
+	ErrJobNotFound = errors.New("mint job not found")
+	// ErrMintExists is returned when the stream already has a mint that
+	// hasn't failed; see StreamMintJob
+	ErrMintExists = errors.New("stream already has a mint")
+)
+
+// errLeaseLost means another worker took the job over
+var errLeaseLost = errors.New("mint job lease lost")
+
+// MintJob is a queued mint and how far it got
+type MintJob struct {
+	ID            string     `json:"job_id"`
+	StreamID      string     `json:"stream_id,omitempty"`
+	Status        string     `json:"status"`
+	ArweaveTxID   string     `json:"arweave_tx_id,omitempty"`
+	MetadataURI   string     `json:"metadata_uri,omitempty"`
+	MintAddress   string     `json:"mint_address,omitempty"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+
+	request MintRequest
+	// lease identifies this claim of the job; every update made while the
+	// job runs is guarded by it
+	lease string
+	// pending is the last mint transaction sent, resumed by a retry
+	pending *blockchain.PendingMint
+}
+
+// Done reports whether the job reached a final state
+func (j *MintJob) Done() bool {
+	return j.Status == JobMinted || j.Status == JobFailed
+}
+
+// jobConfig tunes the mint workers
+type jobConfig struct {
+	workers      int
+	pollInterval time.Duration
+	lease        time.Duration
+	maxAttempts  int
+	// workerID names this instance in lease tokens
+	workerID string
+}
+
+func loadJobConfig() jobConfig {
+	cfg := jobConfig{
+		workers:      2,
+		pollInterval: 5 * time.Second,
+		lease:        10 * time.Minute,
+		maxAttempts:  5,
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("MINT_WORKERS")); err == nil && n >= 0 {
+		cfg.workers = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("MINT_POLL_INTERVAL")); err == nil && d > 0 {
+		cfg.pollInterval = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("MINT_JOB_LEASE")); err == nil && d > 0 {
+		cfg.lease = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("MINT_MAX_ATTEMPTS")); err == nil && n > 0 {
+		cfg.maxAttempts = n
+	}
+
+	host, _ := os.Hostname()
+	cfg.workerID = fmt.Sprintf("%s-%d", host, os.Getpid())
+
+	return cfg
+}
+
+// EnqueueMint queues a mint and returns at once. The workers pick it up,
+// on this instance or any other. A stream is only minted once: while it has
+// a queued, running or finished mint, ErrMintExists is returned.
+func (s *Service) EnqueueMint(ctx context.Context, req *MintRequest, userID string) (*MintJob, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode mint request: %w", err)
+	}
+
+	query := `
+		INSERT INTO mint_jobs (id, stream_id, user_id, status, request)
+		VALUES ($1, NULLIF($2, '')::UUID, $3, $4, $5)
+		ON CONFLICT (stream_id) WHERE status <> 'failed' DO NOTHING
+		RETURNING next_attempt_at, created_at, updated_at
+	`
+
+	job := &MintJob{
+		ID:       uuid.New().String(),
+		StreamID: req.StreamID,
+		Status:   JobQueued,
+		request:  *req,
+	}
+	var nextAttemptAt time.Time
+
+	err = db.DB.QueryRowContext(ctx, query, job.ID, req.StreamID, userID, JobQueued, payload).Scan(
+		&nextAttemptAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrMintExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue mint: %w", err)
+	}
+	job.NextAttemptAt = &nextAttemptAt
+
+	s.wakeWorkers()
+
+	return job, nil
+}
+
+// wakeWorkers gets an idle worker to pick up a due job rather than waiting
+// for its next poll
+func (s *Service) wakeWorkers() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// GetMintJob returns a mint job owned by userID
+func (s *Service) GetMintJob(ctx context.Context, jobID, userID string) (*MintJob, error) {
+	id, err := uuid.Parse(jobID)
+	if err != nil {
+		return nil, ErrJobNotFound
+	}
+
+	return queryMintJob(ctx, `WHERE id = $1 AND user_id::text = $2`, id, userID)
+}
+
+// StreamMintJob returns the stream's mint that hasn't failed, queued,
+// running or done, or nil if there is none
+func (s *Service) StreamMintJob(ctx context.Context, streamID string) (*MintJob, error) {
+	job, err := queryMintJob(ctx, `WHERE stream_id::text = $1 AND status <> 'failed'`, streamID)
+	if errors.Is(err, ErrJobNotFound) {
+		return nil, nil
+	}
+	return job, err
+}
+
+func queryMintJob(ctx context.Context, where string, args ...interface{}) (*MintJob, error) {
+	query := `
+		SELECT id, stream_id, status, arweave_tx_id, metadata_uri, mint_address,
+		       attempts, last_error, next_attempt_at, created_at, updated_at, completed_at
+		FROM mint_jobs
+	` + where
+
+	job := &MintJob{}
+	var streamID, arweaveTxID, metadataURI, mintAddress, lastError sql.NullString
+	var nextAttemptAt time.Time
+	var completedAt sql.NullTime
+
+	err := db.DB.QueryRowContext(ctx, query, args...).Scan(
+		&job.ID,
+		&streamID,
+		&job.Status,
+		&arweaveTxID,
+		&metadataURI,
+		&mintAddress,
+		&job.Attempts,
+		&lastError,
+		&nextAttemptAt,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&completedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	job.StreamID = streamID.String
+	job.ArweaveTxID = arweaveTxID.String
+	job.MetadataURI = metadataURI.String
+	job.MintAddress = mintAddress.String
+	job.LastError = lastError.String
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+	if !job.Done() {
+		job.NextAttemptAt = &nextAttemptAt
+	}
+
+	return job, nil
+}
+
+// RunMintWorkers processes queued mints until ctx is cancelled. Every
+// instance runs its own workers; jobs are leased in the database, so each
+// is worked on by one worker at a time and a crashed worker's jobs are
+// picked up again once the lease runs out.
+func (s *Service) RunMintWorkers(ctx context.Context) {
+	if s.jobs.workers == 0 {
+		return
+	}
+
+	log.Printf("📦 Starting %d mint worker(s)", s.jobs.workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.jobs.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.mintWorker(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Service) mintWorker(ctx context.Context) {
+	ticker := time.NewTicker(s.jobs.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain whatever is due before sleeping
+		for ctx.Err() == nil {
+			job, err := s.claimMintJob(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("⚠️  Failed to claim mint job: %v", err)
+				}
+				break
+			}
+			if job == nil {
+				break
+			}
+			s.runMintJob(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// claimMintJob leases the next due job, or returns nil if there is none.
+// Each claim gets its own lease token, so a worker whose lease lapsed can't
+// go on updating a job another worker, on this instance or any other, has
+// claimed since.
+func (s *Service) claimMintJob(ctx context.Context) (*MintJob, error) {
+	query := `
+		UPDATE mint_jobs
+		SET locked_by = $1, locked_until = NOW() + $2::FLOAT8 * INTERVAL '1 second', updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM mint_jobs
+			WHERE status NOT IN ('minted', 'failed')
+			  AND next_attempt_at <= NOW()
+			  AND (locked_until IS NULL OR locked_until < NOW())
+			ORDER BY next_attempt_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, stream_id, status, request, arweave_tx_id, metadata_uri, attempts, pending_mint
+	`
+
+	job := &MintJob{lease: fmt.Sprintf("%s/%s", s.jobs.workerID, uuid.New())}
+	var streamID, arweaveTxID, metadataURI sql.NullString
+	var payload, pending []byte
+
+	err := db.DB.QueryRowContext(ctx, query, job.lease, s.jobs.lease.Seconds()).Scan(
+		&job.ID,
+		&streamID,
+		&job.Status,
+		&payload,
+		&arweaveTxID,
+		&metadataURI,
+		&job.Attempts,
+		&pending,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	job.StreamID = streamID.String
+	job.ArweaveTxID = arweaveTxID.String
+	job.MetadataURI = metadataURI.String
+
+	if err := json.Unmarshal(payload, &job.request); err != nil {
+		// A request that can't be read will never mint
+		s.failMintJob(ctx, job, fmt.Errorf("invalid mint request: %w", err), true)
+		return nil, nil
+	}
+	if pending != nil {
+		job.pending = &blockchain.PendingMint{}
+		if err := json.Unmarshal(pending, job.pending); err != nil {
+			// Without it the job can't tell whether its transaction landed,
+			// and minting again could mint twice
+			s.failMintJob(ctx, job, fmt.Errorf("invalid pending mint: %w", err), true)
+			return nil, nil
+		}
+	}
+
+	return job, nil
+}
+
+// runMintJob runs the job's remaining steps, saving each result before
+// starting the next. A job interrupted while minting is retried from the
+// mint step, which resumes the transaction it last sent.
+func (s *Service) runMintJob(ctx context.Context, job *MintJob) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	leaseDone := make(chan struct{})
+	go func() {
+		s.keepLease(jobCtx, job, cancel)
+		close(leaseDone)
+	}()
+	defer func() {
+		cancel()
+		<-leaseDone
+	}()
+
+	req := &job.request
+
+	for {
+		var err error
+
+		switch job.Status {
+		case JobQueued:
+			var txID string
+			if txID, err = s.uploadVideo(jobCtx, req); err == nil {
+				job.ArweaveTxID = txID
+				err = s.advanceMintJob(jobCtx, job, JobVideoUploaded, "arweave_tx_id", txID)
+			}
+		case JobVideoUploaded:
+			var uri string
+			if uri, err = s.uploadMetadata(jobCtx, req, job.ArweaveTxID); err == nil {
+				job.MetadataURI = uri
+				err = s.advanceMintJob(jobCtx, job, JobMetadataUploaded, "metadata_uri", uri)
+			}
+		case JobMetadataUploaded:
+			err = s.advanceMintJob(jobCtx, job, JobMinting, "", "")
+		case JobMinting:
+			var result *blockchain.MintResult
+			if result, err = s.mintToken(jobCtx, job); err == nil {
+				err = s.completeMintJob(jobCtx, job, result)
+			}
+		default:
+			return
+		}
+
+		if errors.Is(err, errLeaseLost) {
+			log.Printf("⚠️  Mint job %s was taken over by another worker", job.ID)
+			return
+		}
+		if err != nil {
+			s.failMintJob(ctx, job, err, false)
+			return
+		}
+		if job.Status == JobMinted {
+			log.Printf("✅ Mint job %s minted %s", job.ID, job.MintAddress)
+			return
+		}
+	}
+}
+
+// keepLease extends the job's lease until ctx is done, and cancels the job
+// if another worker has taken it over
+func (s *Service) keepLease(ctx context.Context, job *MintJob, cancel context.CancelFunc) {
+	ticker := time.NewTicker(s.jobs.lease / 3)
+	defer ticker.Stop()
+
+	query := `
+		UPDATE mint_jobs
+		SET locked_until = NOW() + $3::FLOAT8 * INTERVAL '1 second'
+		WHERE id = $1 AND locked_by = $2
+	`
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			result, err := db.DB.ExecContext(ctx, query, job.ID, job.lease, s.jobs.lease.Seconds())
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("⚠️  Failed to extend lease of mint job %s: %v", job.ID, err)
+				}
+				continue
+			}
+			if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// advanceMintJob records a finished step and, if set, its result column
+func (s *Service) advanceMintJob(ctx context.Context, job *MintJob, status, column, value string) error {
+	query := `UPDATE mint_jobs SET status = $3, updated_at = NOW() WHERE id = $1 AND locked_by = $2`
+	args := []interface{}{job.ID, job.lease, status}
+	if column != "" {
+		query = fmt.Sprintf(`UPDATE mint_jobs SET status = $3, %s = $4, updated_at = NOW() WHERE id = $1 AND locked_by = $2`, column)
+		args = append(args, value)
+	}
+
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update mint job: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return errLeaseLost
+	}
+
+	job.Status = status
+	return nil
+}
+
+// savePendingMint records a signed mint transaction on the job before it
+// is sent
+func (s *Service) savePendingMint(ctx context.Context, job *MintJob, pending *blockchain.PendingMint) error {
+	payload, err := json.Marshal(pending)
+	if err != nil {
+		return fmt.Errorf("failed to encode pending mint: %w", err)
+	}
+
+	query := `
+		UPDATE mint_jobs
+		SET pending_mint = $3, mint_signature = $4, last_valid_block_height = $5, updated_at = NOW()
+		WHERE id = $1 AND locked_by = $2
+	`
+
+	result, err := db.DB.ExecContext(ctx, query, job.ID, job.lease, payload, pending.Signature, int64(pending.LastValidBlockHeight))
+	if err != nil {
+		return fmt.Errorf("failed to update mint job: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return errLeaseLost
+	}
+
+	job.pending = pending
+	return nil
+}
+
+// completeMintJob saves the NFT, points the stream at it and closes the job
+// in one transaction. The job keeps its pending mint until the transaction
+// is finalized, in case the confirmation watcher sends it back.
+func (s *Service) completeMintJob(ctx context.Context, job *MintJob, mint *blockchain.MintResult) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE mint_jobs
+		SET status = $3, mint_address = $4, pending_mint = CASE WHEN $5 THEN NULL ELSE pending_mint END,
+		    last_error = NULL, locked_by = NULL, locked_until = NULL, completed_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND locked_by = $2
+	`
+
+	settled := confirmationStatus(mint) == blockchain.TxFinalized
+	result, err := tx.ExecContext(ctx, query, job.ID, job.lease, JobMinted, mint.MintAddress, settled)
+	if err != nil {
+		return fmt.Errorf("failed to update mint job: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return errLeaseLost
+	}
+
+	if err := saveNFTToDatabase(ctx, tx, &job.request, mint, job.MetadataURI, job.ArweaveTxID); err != nil {
+		return fmt.Errorf("failed to save NFT: %w", err)
+	}
+
+	if job.StreamID != "" {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE streams SET nft_mint_address = $2, arweave_tx_id = $3, mint_status = $4 WHERE id = $1`,
+			job.StreamID, mint.MintAddress, job.ArweaveTxID, confirmationStatus(mint),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update stream: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	job.Status = JobMinted
+	job.MintAddress = mint.MintAddress
+	return nil
+}
+
+// failMintJob schedules a retry with backoff, or fails the job for good
+// once it runs out of attempts. A job stopped by shutdown is handed back
+// without using up an attempt. A job whose last transaction may still land
+// isn't failed: it keeps checking the transaction, without signing a new
+// one, until it landed or provably didn't.
+func (s *Service) failMintJob(ctx context.Context, job *MintJob, cause error, permanent bool) {
+	// ctx may be the cancelled one; the job still has to be released
+	releaseCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if ctx.Err() != nil {
+		_, err := db.DB.ExecContext(releaseCtx,
+			`UPDATE mint_jobs SET locked_by = NULL, locked_until = NULL, updated_at = NOW() WHERE id = $1 AND locked_by = $2`,
+			job.ID, job.lease,
+		)
+		if err != nil {
+			log.Printf("⚠️  Failed to release mint job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	attempts := job.Attempts + 1
+	inFlight := job.pending != nil && !errors.Is(cause, blockchain.ErrMintNotLanded)
+	if permanent || (attempts >= s.jobs.maxAttempts && !inFlight) {
+		log.Printf("❌ Mint job %s failed: %v", job.ID, cause)
+		result, err := db.DB.ExecContext(releaseCtx, `
+			UPDATE mint_jobs
+			SET status = $3, attempts = $4, last_error = $5, locked_by = NULL, locked_until = NULL,
+			    completed_at = NOW(), updated_at = NOW()
+			WHERE id = $1 AND locked_by = $2
+		`, job.ID, job.lease, JobFailed, attempts, cause.Error())
+		if err != nil {
+			log.Printf("⚠️  Failed to update mint job %s: %v", job.ID, err)
+			return
+		}
+		if rows, err := result.RowsAffected(); err == nil && rows > 0 {
+			returnUpload(releaseCtx, job)
+		}
+		return
+	}
+
+	backoff := retryBackoff(attempts)
+	log.Printf("⚠️  Mint job %s attempt %d failed, retrying in %s: %v", job.ID, attempts, backoff, cause)
+	_, err := db.DB.ExecContext(releaseCtx, `
+		UPDATE mint_jobs
+		SET attempts = $3, last_error = $4, next_attempt_at = NOW() + $5::FLOAT8 * INTERVAL '1 second',
+		    locked_by = NULL, locked_until = NULL, updated_at = NOW()
+		WHERE id = $1 AND locked_by = $2
+	`, job.ID, job.lease, attempts, cause.Error(), backoff.Seconds())
+	if err != nil {
+		log.Printf("⚠️  Failed to update mint job %s: %v", job.ID, err)
+	}
+}
+
+// returnUpload hands the resumable upload a failed job was minting back to
+// its owner, so the stream can be saved again from the same upload. The
+// upload gets its original lifetime again, counted from now.
+func returnUpload(ctx context.Context, job *MintJob) {
+	if job.request.VideoURL == "" {
+		return
+	}
+
+	query := `
+		UPDATE stream_uploads
+		SET status = 'completed', expires_at = GREATEST(expires_at, NOW() + (expires_at - created_at)), updated_at = NOW()
+		WHERE file_path = $1 AND status = 'consumed'
+	`
+	if _, err := db.DB.ExecContext(ctx, query, job.request.VideoURL); err != nil {
+		log.Printf("⚠️  Failed to return upload of mint job %s: %v", job.ID, err)
+	}
+}
+
+// retryBackoff doubles from 30s up to 30m
+func retryBackoff(attempts int) time.Duration {
+	backoff := 30 * time.Second
+	for i := 1; i < attempts && backoff < 30*time.Minute; i++ {
+		backoff *= 2
+	}
+	if backoff > 30*time.Minute {
+		backoff = 30 * time.Minute
+	}
+	return backoff
+}
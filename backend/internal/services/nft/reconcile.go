@@ -0,0 +1,32 @@
+package nft
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alexcolls/now.ink/backend/internal/db"
+)
+
+// ReconcileMintStatus copies mint results recorded in nfts onto their
+// streams. Mint jobs write both tables in one transaction, but NFTs minted
+// before the job pipeline may have a stream that still looks unminted.
+// Mints whose transaction failed or expired are left out.
+func (s *Service) ReconcileMintStatus(ctx context.Context) (int64, error) {
+	query := `
+		UPDATE streams s
+		SET nft_mint_address = n.mint_address,
+		    mint_status = n.confirmation_status,
+		    arweave_tx_id = COALESCE(s.arweave_tx_id, NULLIF(REPLACE(n.video_url, 'ar://', ''), n.video_url))
+		FROM nfts n
+		WHERE n.stream_id = s.id
+		  AND n.confirmation_status NOT IN ('failed', 'expired')
+		  AND s.nft_mint_address IS DISTINCT FROM n.mint_address
+	`
+
+	result, err := db.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reconcile mint status: %w", err)
+	}
+
+	return result.RowsAffected()
+}
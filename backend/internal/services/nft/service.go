@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/alexcolls/now.ink/backend/internal/blockchain"
@@ -15,20 +16,31 @@ import (
 type Service struct {
 	solanaClient  *blockchain.SolanaClient
 	arweaveClient *storage.ArweaveClient
+	jobs          jobConfig
+	wake          chan struct{}
 }
 
 // NewService creates a new NFT service
 func NewService() *Service {
 	solanaClient, _ := blockchain.NewSolanaClient()
 	arweaveClient, _ := storage.NewArweaveClient()
+	solanaClient.SetTreeStore(&treeStore{network: solanaClient.GetNetwork()})
 	return &Service{
 		solanaClient:  solanaClient,
 		arweaveClient: arweaveClient,
+		jobs:          loadJobConfig(),
+		wake:          make(chan struct{}, 1),
 	}
 }
 
+// execer is satisfied by both the pool and a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
 // MintRequest represents the data needed to mint an NFT
 type MintRequest struct {
+	StreamID    string    `json:"stream_id,omitempty"`
 	VideoURL    string    `json:"video_url"`
 	Title       string    `json:"title"`
 	UserWallet  string    `json:"user_wallet"`
@@ -36,21 +48,23 @@ type MintRequest struct {
 	Longitude   float64   `json:"longitude"`
 	Duration    int       `json:"duration_seconds"`
 	Timestamp   time.Time `json:"timestamp"`
+	Path        *Path     `json:"path,omitempty"`
+	// ChatTranscript is archived next to the video when set
+	ChatTranscript interface{} `json:"chat_transcript,omitempty"`
 }
 
-// MintResponse represents the minting result
-type MintResponse struct {
-	MintAddress  string    `json:"mint_address"`
-	MetadataURI  string    `json:"metadata_uri"`
-	ArweaveHash  string    `json:"arweave_hash"`
-	Transaction  string    `json:"transaction"`
-	Status       string    `json:"status"`
-	MintedAt     time.Time `json:"minted_at"`
+// Path is the route a stream took when its broadcaster moved
+type Path struct {
+	StartLatitude  float64 `json:"start_latitude"`
+	StartLongitude float64 `json:"start_longitude"`
+	EndLatitude    float64 `json:"end_latitude"`
+	EndLongitude   float64 `json:"end_longitude"`
+	LengthMeters   float64 `json:"length_meters"`
 }
 
-// Mint mints a new NFT on Solana
-func (s *Service) Mint(ctx context.Context, req *MintRequest) (*MintResponse, error) {
-	// 1. Upload video to Arweave
+// uploadVideo is the first mint step: it stores the video on Arweave and
+// returns the transaction ID
+func (s *Service) uploadVideo(ctx context.Context, req *MintRequest) (string, error) {
 	videoMetadata := storage.VideoMetadata{
 		Title:     req.Title,
 		Creator:   req.UserWallet,
@@ -62,12 +76,29 @@ func (s *Service) Mint(ctx context.Context, req *MintRequest) (*MintResponse, er
 
 	videoTxID, err := s.arweaveClient.UploadVideo(ctx, req.VideoURL, videoMetadata)
 	if err != nil {
-		return nil, fmt.Errorf("failed to upload video to Arweave: %w", err)
+		return "", fmt.Errorf("failed to upload video to Arweave: %w", err)
 	}
 
+	return videoTxID, nil
+}
+
+// uploadMetadata is the second mint step: it stores the NFT metadata (and
+// the chat transcript, if any) on Arweave and returns the metadata URI
+func (s *Service) uploadMetadata(ctx context.Context, req *MintRequest, videoTxID string) (string, error) {
 	videoArweaveURL := fmt.Sprintf("ar://%s", videoTxID)
 
-	// 2. Create and upload metadata JSON to Arweave
+	files := []storage.MetadataFile{
+		{URI: videoArweaveURL, Type: "video/mp4"},
+	}
+
+	if req.ChatTranscript != nil {
+		chatTxID, err := s.arweaveClient.UploadJSON(ctx, req.ChatTranscript, "chat-transcript")
+		if err != nil {
+			return "", fmt.Errorf("failed to upload chat transcript to Arweave: %w", err)
+		}
+		files = append(files, storage.MetadataFile{URI: fmt.Sprintf("ar://%s", chatTxID), Type: "application/json"})
+	}
+
 	nftMetadata := storage.NFTMetadata{
 		Name:                 req.Title,
 		Symbol:               "NOWINK",
@@ -80,13 +111,10 @@ func (s *Service) Mint(ctx context.Context, req *MintRequest) (*MintResponse, er
 			{TraitType: "Longitude", Value: req.Longitude},
 			{TraitType: "Timestamp", Value: req.Timestamp.Format(time.RFC3339)},
 			{TraitType: "Duration", Value: req.Duration},
-			{TraitType: "Location Type", Value: "GPS Coordinate"},
 		},
 		Properties: storage.MetadataProperties{
 			Category: "video",
-			Files: []storage.MetadataFile{
-				{URI: videoArweaveURL, Type: "video/mp4"},
-			},
+			Files:    files,
 			Creators: []storage.MetadataCreator{
 				{Address: "PLATFORM_WALLET", Share: 5},  // Platform 5%
 				{Address: req.UserWallet, Share: 95},     // User 95%
@@ -94,22 +122,46 @@ func (s *Service) Mint(ctx context.Context, req *MintRequest) (*MintResponse, er
 		},
 	}
 
+	if req.Path != nil {
+		nftMetadata.Attributes = append(nftMetadata.Attributes,
+			storage.MetadataAttribute{TraitType: "Start", Value: fmt.Sprintf("%.6f, %.6f", req.Path.StartLatitude, req.Path.StartLongitude)},
+			storage.MetadataAttribute{TraitType: "End", Value: fmt.Sprintf("%.6f, %.6f", req.Path.EndLatitude, req.Path.EndLongitude)},
+			storage.MetadataAttribute{TraitType: "Path Length (m)", Value: math.Round(req.Path.LengthMeters)},
+			storage.MetadataAttribute{TraitType: "Location Type", Value: "GPS Track"},
+		)
+	} else {
+		nftMetadata.Attributes = append(nftMetadata.Attributes,
+			storage.MetadataAttribute{TraitType: "Location Type", Value: "GPS Coordinate"},
+		)
+	}
+
 	metadataTxID, err := s.arweaveClient.UploadMetadata(ctx, nftMetadata)
 	if err != nil {
-		return nil, fmt.Errorf("failed to upload metadata to Arweave: %w", err)
+		return "", fmt.Errorf("failed to upload metadata to Arweave: %w", err)
 	}
 
-	metadataURI := fmt.Sprintf("ar://%s", metadataTxID)
+	return fmt.Sprintf("ar://%s", metadataTxID), nil
+}
 
-	// 3. Mint NFT on Solana with Arweave URIs
+// mintToken is the last mint step: it mints the NFT on Solana pointing at
+// the Arweave metadata. Each transaction is saved on the job before it is
+// sent, and a retried job resumes the one it saved. A job out of attempts
+// only finds out what became of it.
+func (s *Service) mintToken(ctx context.Context, job *MintJob) (*blockchain.MintResult, error) {
+	req := &job.request
 	mintOpts := blockchain.MintOptions{
 		CreatorWallet: req.UserWallet,
-		MetadataURI:   metadataURI,
-		ArweaveTxID:   videoTxID,
+		MetadataURI:   job.MetadataURI,
+		ArweaveTxID:   job.ArweaveTxID,
 		Title:         req.Title,
 		Latitude:      req.Latitude,
 		Longitude:     req.Longitude,
 		Duration:      req.Duration,
+		Pending:       job.pending,
+		ResumeOnly:    job.Attempts >= s.jobs.maxAttempts,
+		OnSigned: func(ctx context.Context, pending *blockchain.PendingMint) error {
+			return s.savePendingMint(ctx, job, pending)
+		},
 	}
 
 	result, err := s.solanaClient.MintNFT(ctx, mintOpts)
@@ -117,34 +169,36 @@ func (s *Service) Mint(ctx context.Context, req *MintRequest) (*MintResponse, er
 		return nil, fmt.Errorf("failed to mint NFT: %w", err)
 	}
 
-	// Save to database
-	err = s.saveNFTToDatabase(ctx, req, result.MintAddress, metadataURI, videoTxID)
-	if err != nil {
-		// Log error but don't fail - NFT was already minted
-		fmt.Printf("⚠️  Failed to save NFT to database: %v\n", err)
-	}
-
-	return &MintResponse{
-		MintAddress: result.MintAddress,
-		MetadataURI: metadataURI,
-		ArweaveHash: videoTxID,
-		Transaction: "pending",
-		Status:      result.Status,
-		MintedAt:    time.Now(),
-	}, nil
+	return result, nil
 }
 
-// saveNFTToDatabase saves the minted NFT information to the database
-func (s *Service) saveNFTToDatabase(ctx context.Context, req *MintRequest, mintAddress, metadataURI, arweaveTxID string) error {
+// saveNFTToDatabase saves the minted NFT information to the database. A
+// retried job may save the same mint twice, which is ignored. A mint
+// signed again after its transaction failed or expired takes over the
+// failed row.
+func saveNFTToDatabase(ctx context.Context, conn execer, req *MintRequest, mint *blockchain.MintResult, metadataURI, arweaveTxID string) error {
 	query := `
-		INSERT INTO nfts (id, mint_address, metadata_uri, creator_wallet, title, latitude, longitude, timestamp, duration_seconds, video_url, created_at)
-		VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+		INSERT INTO nfts (id, stream_id, mint_address, metadata_uri, creator_wallet, title, latitude, longitude, timestamp, duration_seconds, video_url,
+		                  compressed, merkle_tree, leaf_index, mint_signature, last_valid_block_height, confirmation_status, created_at)
+		VALUES (gen_random_uuid(), NULLIF($1, '')::UUID, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, ''), $13,
+		        NULLIF($14, ''), NULLIF($15, 0), $16, NOW())
+		ON CONFLICT (mint_address) DO UPDATE
+		SET leaf_index = EXCLUDED.leaf_index, mint_signature = EXCLUDED.mint_signature,
+		    last_valid_block_height = EXCLUDED.last_valid_block_height, confirmation_status = EXCLUDED.confirmation_status,
+		    confirmation_slot = NULL, confirmation_error = NULL, confirmation_checked_at = NULL, finalized_at = NULL
+		WHERE nfts.confirmation_status IN ('failed', 'expired')
 	`
 
 	videoURL := fmt.Sprintf("ar://%s", arweaveTxID)
 
-	_, err := db.DB.ExecContext(ctx, query,
-		mintAddress,
+	var leafIndex sql.NullInt64
+	if mint.Compressed {
+		leafIndex = sql.NullInt64{Int64: int64(mint.LeafIndex), Valid: true}
+	}
+
+	_, err := conn.ExecContext(ctx, query,
+		req.StreamID,
+		mint.MintAddress,
 		metadataURI,
 		req.UserWallet,
 		req.Title,
@@ -153,6 +207,12 @@ func (s *Service) saveNFTToDatabase(ctx context.Context, req *MintRequest, mintA
 		req.Timestamp,
 		req.Duration,
 		videoURL,
+		mint.Compressed,
+		mint.MerkleTree,
+		leafIndex,
+		mint.Signature,
+		int64(mint.LastValidBlockHeight),
+		confirmationStatus(mint),
 	)
 
 	return err
@@ -162,14 +222,14 @@ func (s *Service) saveNFTToDatabase(ctx context.Context, req *MintRequest, mintA
 func (s *Service) GetNFT(ctx context.Context, mintAddress string) (*NFTDetails, error) {
 	query := `
 		SELECT mint_address, metadata_uri, title, creator_wallet, latitude, longitude, 
-		       timestamp, duration_seconds, video_url, thumbnail_url
+		       timestamp, duration_seconds, video_url, thumbnail_url, compressed, merkle_tree, leaf_index, confirmation_status
 		FROM nfts
 		WHERE mint_address = $1
 	`
 
 	details := &NFTDetails{}
-	var title, videoURL, thumbnailURL sql.NullString
-	var durationSeconds sql.NullInt64
+	var title, videoURL, thumbnailURL, merkleTree sql.NullString
+	var durationSeconds, leafIndex sql.NullInt64
 
 	err := db.DB.QueryRowContext(ctx, query, mintAddress).Scan(
 		&details.MintAddress,
@@ -182,11 +242,15 @@ func (s *Service) GetNFT(ctx context.Context, mintAddress string) (*NFTDetails,
 		&durationSeconds,
 		&videoURL,
 		&thumbnailURL,
+		&details.Compressed,
+		&merkleTree,
+		&leafIndex,
+		&details.ConfirmationStatus,
 	)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("NFT not found")
+			return nil, ErrNFTNotFound
 		}
 		return nil, err
 	}
@@ -203,6 +267,10 @@ func (s *Service) GetNFT(ctx context.Context, mintAddress string) (*NFTDetails,
 	if durationSeconds.Valid {
 		details.Duration = int(durationSeconds.Int64)
 	}
+	if leafIndex.Valid {
+		details.MerkleTree = merkleTree.String
+		details.LeafIndex = &leafIndex.Int64
+	}
 
 	details.Symbol = "NOWINK"
 
@@ -213,9 +281,9 @@ func (s *Service) GetNFT(ctx context.Context, mintAddress string) (*NFTDetails,
 func (s *Service) ListNFTs(ctx context.Context, filters *NFTFilters) ([]*NFTDetails, error) {
 	query := `
 		SELECT mint_address, metadata_uri, title, creator_wallet, latitude, longitude,
-		       timestamp, duration_seconds, video_url, thumbnail_url
+		       timestamp, duration_seconds, video_url, thumbnail_url, compressed, merkle_tree, leaf_index, confirmation_status
 		FROM nfts
-		WHERE 1=1
+		WHERE confirmation_status NOT IN ('failed', 'expired')
 	`
 	args := []interface{}{}
 	argCount := 1
@@ -227,6 +295,13 @@ func (s *Service) ListNFTs(ctx context.Context, filters *NFTFilters) ([]*NFTDeta
 		argCount++
 	}
 
+	// All wallets linked to the user
+	if filters.CreatorUserID != "" {
+		query += fmt.Sprintf(" AND creator_wallet IN (SELECT wallet_address FROM user_wallets WHERE user_id::text = $%d)", argCount)
+		args = append(args, filters.CreatorUserID)
+		argCount++
+	}
+
 	if filters.StartDate != nil {
 		query += fmt.Sprintf(" AND timestamp >= $%d", argCount)
 		args = append(args, *filters.StartDate)
@@ -263,8 +338,8 @@ func (s *Service) ListNFTs(ctx context.Context, filters *NFTFilters) ([]*NFTDeta
 	nfts := []*NFTDetails{}
 	for rows.Next() {
 		details := &NFTDetails{}
-		var title, videoURL, thumbnailURL sql.NullString
-		var durationSeconds sql.NullInt64
+		var title, videoURL, thumbnailURL, merkleTree sql.NullString
+		var durationSeconds, leafIndex sql.NullInt64
 
 		err := rows.Scan(
 			&details.MintAddress,
@@ -277,6 +352,10 @@ func (s *Service) ListNFTs(ctx context.Context, filters *NFTFilters) ([]*NFTDeta
 			&durationSeconds,
 			&videoURL,
 			&thumbnailURL,
+			&details.Compressed,
+			&merkleTree,
+			&leafIndex,
+			&details.ConfirmationStatus,
 		)
 		if err != nil {
 			return nil, err
@@ -294,6 +373,10 @@ func (s *Service) ListNFTs(ctx context.Context, filters *NFTFilters) ([]*NFTDeta
 		if durationSeconds.Valid {
 			details.Duration = int(durationSeconds.Int64)
 		}
+		if leafIndex.Valid {
+			details.MerkleTree = merkleTree.String
+			details.LeafIndex = &leafIndex.Int64
+		}
 
 		details.Symbol = "NOWINK"
 		nfts = append(nfts, details)
@@ -315,16 +398,25 @@ type NFTDetails struct {
 	VideoURL     string    `json:"video_url"`
 	ThumbnailURL string    `json:"thumbnail_url"`
 	Duration     int       `json:"duration_seconds"`
+	// Compressed NFTs are located by tree and leaf; MintAddress is their
+	// asset ID
+	Compressed bool   `json:"compressed"`
+	MerkleTree string `json:"merkle_tree,omitempty"`
+	LeafIndex  *int64 `json:"leaf_index,omitempty"`
+	// ConfirmationStatus is how far the mint transaction got; see
+	// GetConfirmation
+	ConfirmationStatus string `json:"confirmation_status"`
 }
 
 // NFTFilters represents query filters for NFTs
 type NFTFilters struct {
-	Latitude  *float64  `json:"latitude"`
-	Longitude *float64  `json:"longitude"`
-	RadiusKm  float64   `json:"radius_km"`
-	StartDate *time.Time `json:"start_date"`
-	EndDate   *time.Time `json:"end_date"`
-	Creator   string    `json:"creator"`
-	Limit     int       `json:"limit"`
-	Offset    int       `json:"offset"`
+	Latitude      *float64   `json:"latitude"`
+	Longitude     *float64   `json:"longitude"`
+	RadiusKm      float64    `json:"radius_km"`
+	StartDate     *time.Time `json:"start_date"`
+	EndDate       *time.Time `json:"end_date"`
+	Creator       string     `json:"creator"`
+	CreatorUserID string     `json:"creator_user_id"`
+	Limit         int        `json:"limit"`
+	Offset        int        `json:"offset"`
 }
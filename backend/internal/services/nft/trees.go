@@ -0,0 +1,92 @@
+package nft
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/alexcolls/now.ink/backend/internal/blockchain"
+	"github.com/alexcolls/now.ink/backend/internal/db"
+)
+
+// treeStore keeps the platform's Merkle trees in the merkle_trees table
+type treeStore struct {
+	network string
+}
+
+// ActiveTree returns the tree being filled on the network
+func (t *treeStore) ActiveTree(ctx context.Context) (*blockchain.MerkleTree, error) {
+	query := `
+		SELECT address, max_depth, max_buffer_size, canopy_depth, COALESCE(create_signature, '')
+		FROM merkle_trees
+		WHERE network = $1 AND retired_at IS NULL
+	`
+
+	tree := &blockchain.MerkleTree{}
+	err := db.DB.QueryRowContext(ctx, query, t.network).Scan(
+		&tree.Address,
+		&tree.MaxDepth,
+		&tree.MaxBufferSize,
+		&tree.CanopyDepth,
+		&tree.Signature,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active merkle tree: %w", err)
+	}
+
+	return tree, nil
+}
+
+// AddTree records a new tree as the one being filled
+func (t *treeStore) AddTree(ctx context.Context, tree *blockchain.MerkleTree) error {
+	query := `
+		INSERT INTO merkle_trees (address, max_depth, max_buffer_size, canopy_depth, network, create_signature)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+	`
+
+	_, err := db.DB.ExecContext(ctx, query,
+		tree.Address,
+		tree.MaxDepth,
+		tree.MaxBufferSize,
+		tree.CanopyDepth,
+		t.network,
+		tree.Signature,
+	)
+	return err
+}
+
+// RetireTree marks a full tree so no more mints go into it
+func (t *treeStore) RetireTree(ctx context.Context, address string) error {
+	_, err := db.DB.ExecContext(ctx,
+		`UPDATE merkle_trees SET retired_at = NOW() WHERE address = $1 AND retired_at IS NULL`,
+		address,
+	)
+	return err
+}
+
+// LockTrees takes an advisory lock so only one instance creates a tree. The
+// lock belongs to the session, so it is held on a dedicated connection.
+func (t *treeStore) LockTrees(ctx context.Context) (func(), error) {
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte("nowink:merkle-tree:" + t.network))
+	lockKey := int64(h.Sum64())
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return func() {
+		conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+		conn.Close()
+	}, nil
+}
@@ -0,0 +1,461 @@
+package segment
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/google/uuid"
+)
+
+// InitSeq is the sequence number of the fMP4 init segment, which goes first
+const InitSeq = -1
+
+// Recording statuses
+const (
+	statusAssembling = "assembling"
+	statusAssembled  = "assembled"
+)
+
+// assemblyTimeout is how long a claim on a stream's assembly holds before
+// another request may take it over
+const assemblyTimeout = 10 * time.Minute
+
+// maxSeq bounds segment numbers (a day of 1s segments is 86400)
+const maxSeq = 1_000_000
+
+// Segment errors
+var (
+	ErrInvalidSegment   = errors.New("invalid segment")
+	ErrStreamTooLarge   = errors.New("stream recording too large")
+	ErrAlreadyAssembled = errors.New("recording already assembled")
+	ErrNoSegments       = errors.New("no segments were ingested for this stream")
+	ErrMissingInit      = errors.New("init segment was never ingested")
+	ErrAssembling       = errors.New("recording is being assembled")
+)
+
+// Service stores live segments and assembles them into recordings
+type Service struct {
+	dir     string
+	maxSize int64
+}
+
+// NewService creates a new segment service
+func NewService() *Service {
+	maxSize := int64(2 << 30)
+	if size, err := strconv.ParseInt(os.Getenv("SEGMENT_MAX_STREAM_SIZE"), 10, 64); err == nil && size > 0 {
+		maxSize = size
+	}
+
+	dir := os.Getenv("SEGMENT_DIR")
+	if dir == "" {
+		dir = "/tmp/nowink-videos/segments"
+	}
+
+	return &Service{dir: dir, maxSize: maxSize}
+}
+
+// Recording is a stream's server-side recording
+type Recording struct {
+	StreamID        uuid.UUID `json:"stream_id"`
+	FilePath        string    `json:"-"`
+	Size            int64     `json:"size"`
+	SegmentCount    int       `json:"segment_count"`
+	MissingSegments int       `json:"missing_segments"`
+	DurationMs      int64     `json:"duration_ms,omitempty"`
+	AssembledAt     time.Time `json:"assembled_at"`
+
+	status       string
+	claimedUntil time.Time
+}
+
+// assembled reports whether the recording was written, rather than claimed
+// by an assembly still in progress
+func (r *Recording) assembled() bool {
+	return r.status == statusAssembled
+}
+
+// Store saves one segment of a live stream. Re-sending a segment replaces
+// it, so clients can retry without tracking what arrived. The caller must
+// have checked that the stream is live and owned by the sender.
+func (s *Service) Store(ctx context.Context, streamID string, seq int, durationMs int, data []byte) error {
+	id, err := uuid.Parse(streamID)
+	if err != nil {
+		return fmt.Errorf("%w: invalid stream_id", ErrInvalidSegment)
+	}
+	if seq < InitSeq || seq > maxSeq {
+		return fmt.Errorf("%w: sequence out of range", ErrInvalidSegment)
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("%w: empty segment", ErrInvalidSegment)
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Concurrent segments must not both fit under the size cap, and none may
+	// land while the stream is being assembled
+	if err := lockSegments(ctx, tx, id); err != nil {
+		return err
+	}
+
+	var assembled bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM stream_recordings WHERE stream_id = $1)`, id).Scan(&assembled); err != nil {
+		return err
+	}
+	if assembled {
+		return ErrAlreadyAssembled
+	}
+
+	var stored int64
+	query := `SELECT COALESCE(SUM(size), 0) FROM stream_segments WHERE stream_id = $1 AND seq <> $2`
+	if err := tx.QueryRowContext(ctx, query, id, seq).Scan(&stored); err != nil {
+		return err
+	}
+	if stored+int64(len(data)) > s.maxSize {
+		return ErrStreamTooLarge
+	}
+
+	dir := filepath.Join(s.dir, id.String())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create segment directory: %w", err)
+	}
+
+	path := filepath.Join(dir, segmentFileName(seq))
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	var duration sql.NullInt64
+	if durationMs > 0 {
+		duration = sql.NullInt64{Int64: int64(durationMs), Valid: true}
+	}
+
+	query = `
+		INSERT INTO stream_segments (stream_id, seq, size, sha256, duration_ms, file_path)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (stream_id, seq) DO UPDATE
+		SET size = EXCLUDED.size, sha256 = EXCLUDED.sha256, duration_ms = EXCLUDED.duration_ms, created_at = NOW()
+	`
+	_, err = tx.ExecContext(ctx, query, id, seq, len(data), hex.EncodeToString(sum[:]), duration, path)
+	if err != nil {
+		return fmt.Errorf("failed to store segment: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// Assemble concatenates a stream's segments in order into one file and
+// removes the segments. fMP4 fragments appended to their init segment form
+// a playable fragmented MP4. It is idempotent: an already assembled stream
+// returns its recording.
+//
+// The stream is claimed in a short transaction first, which also stops new
+// segments from being stored; the files are concatenated without holding a
+// transaction or the segment lock, and the recording is stored once done.
+func (s *Service) Assemble(ctx context.Context, streamID string) (*Recording, error) {
+	id, err := uuid.Parse(streamID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid stream_id", ErrInvalidSegment)
+	}
+
+	recording, paths, claim, err := s.claimAssembly(ctx, id)
+	if err != nil || claim == "" {
+		return recording, err
+	}
+
+	// Each claim writes its own file, so a request that took over an
+	// abandoned claim never writes into the same file
+	tmp := fmt.Sprintf("%s.%s.tmp", recording.FilePath, claim)
+	size, err := concatFiles(tmp, paths)
+	if err == nil {
+		err = os.Rename(tmp, recording.FilePath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		releaseAssembly(id, claim)
+		return nil, err
+	}
+	recording.Size = size
+
+	if err := finishAssembly(ctx, recording, claim); err != nil {
+		if errors.Is(err, ErrAssembling) {
+			// Another request took over the claim; its recording wins
+			if assembled, err := getRecording(ctx, db.DB, id); err == nil && assembled.assembled() {
+				return assembled, nil
+			}
+		}
+		return nil, err
+	}
+
+	for _, path := range paths {
+		os.Remove(path)
+	}
+
+	return recording, nil
+}
+
+// claimAssembly returns the recording of an assembled stream, or claims the
+// stream for assembly and returns the recording to write, the segment files
+// in order and the claim
+func (s *Service) claimAssembly(ctx context.Context, id uuid.UUID) (*Recording, []string, string, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer tx.Rollback()
+
+	// Serialize claims of one stream across requests and instances
+	if err := lockSegments(ctx, tx, id); err != nil {
+		return nil, nil, "", err
+	}
+
+	if recording, err := getRecording(ctx, tx, id); err == nil {
+		if recording.assembled() {
+			return recording, nil, "", nil
+		}
+		if time.Now().Before(recording.claimedUntil) {
+			return nil, nil, "", ErrAssembling
+		}
+		// The claim was abandoned; take it over
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, "", err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT seq, file_path, duration_ms FROM stream_segments WHERE stream_id = $1 ORDER BY seq`, id)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	var seqs []int
+	var paths []string
+	var durationMs int64
+	for rows.Next() {
+		var seq int
+		var path string
+		var duration sql.NullInt64
+		if err := rows.Scan(&seq, &path, &duration); err != nil {
+			rows.Close()
+			return nil, nil, "", err
+		}
+		seqs = append(seqs, seq)
+		paths = append(paths, path)
+		durationMs += duration.Int64
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, "", err
+	}
+
+	if len(seqs) == 0 || (len(seqs) == 1 && seqs[0] == InitSeq) {
+		return nil, nil, "", ErrNoSegments
+	}
+	if seqs[0] != InitSeq {
+		return nil, nil, "", ErrMissingInit
+	}
+
+	// Count gaps between the first and last media segment
+	media := seqs[1:]
+	recording := &Recording{
+		StreamID:        id,
+		FilePath:        filepath.Join(s.dir, id.String(), "recording.mp4"),
+		SegmentCount:    len(media),
+		MissingSegments: media[len(media)-1] - media[0] + 1 - len(media),
+		DurationMs:      durationMs,
+	}
+
+	claim := uuid.New().String()
+	query := `
+		INSERT INTO stream_recordings (stream_id, file_path, size, segment_count, missing_segments, duration_ms, status, claimed_by, claimed_until)
+		VALUES ($1, $2, 0, $3, $4, $5, $6, $7, NOW() + $8::FLOAT8 * INTERVAL '1 second')
+		ON CONFLICT (stream_id) DO UPDATE
+		SET file_path = EXCLUDED.file_path, segment_count = EXCLUDED.segment_count,
+		    missing_segments = EXCLUDED.missing_segments, duration_ms = EXCLUDED.duration_ms,
+		    claimed_by = EXCLUDED.claimed_by, claimed_until = EXCLUDED.claimed_until
+	`
+	_, err = tx.ExecContext(ctx, query,
+		recording.StreamID,
+		recording.FilePath,
+		recording.SegmentCount,
+		recording.MissingSegments,
+		recording.DurationMs,
+		statusAssembling,
+		claim,
+		assemblyTimeout.Seconds(),
+	)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to claim recording: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, "", err
+	}
+
+	return recording, paths, claim, nil
+}
+
+// finishAssembly stores an assembled recording and removes its segments,
+// unless another request took over the claim
+func finishAssembly(ctx context.Context, recording *Recording, claim string) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	recording.AssembledAt = time.Now()
+	query := `
+		UPDATE stream_recordings
+		SET status = $3, size = $4, assembled_at = $5, claimed_by = NULL, claimed_until = NULL
+		WHERE stream_id = $1 AND claimed_by = $2 AND status = $6
+	`
+	result, err := tx.ExecContext(ctx, query, recording.StreamID, claim, statusAssembled, recording.Size, recording.AssembledAt, statusAssembling)
+	if err != nil {
+		return fmt.Errorf("failed to store recording: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrAssembling
+	}
+
+	// No segment can be stored once the stream is claimed, so all of them
+	// are in the recording
+	if _, err := tx.ExecContext(ctx, `DELETE FROM stream_segments WHERE stream_id = $1`, recording.StreamID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// releaseAssembly gives up a claim whose assembly failed, so the next
+// request can retry right away
+func releaseAssembly(id uuid.UUID, claim string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := db.DB.ExecContext(ctx,
+		`DELETE FROM stream_recordings WHERE stream_id = $1 AND claimed_by = $2 AND status = $3`,
+		id, claim, statusAssembling,
+	)
+	if err != nil {
+		log.Printf("⚠️  Failed to release assembly of stream %s: %v", id, err)
+	}
+}
+
+// GetRecording returns a stream's assembled recording
+func (s *Service) GetRecording(ctx context.Context, streamID string) (*Recording, error) {
+	id, err := uuid.Parse(streamID)
+	if err != nil {
+		return nil, ErrNoSegments
+	}
+
+	recording, err := getRecording(ctx, db.DB, id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !recording.assembled()) {
+		return nil, ErrNoSegments
+	}
+	return recording, err
+}
+
+// lockSegments takes the transaction-scoped lock on a stream's segments,
+// held by Store and by Assemble while it claims the stream
+func lockSegments(ctx context.Context, tx *sql.Tx, id uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('nowink:segments:' || $1::text))`, id)
+	return err
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func getRecording(ctx context.Context, conn queryRower, id uuid.UUID) (*Recording, error) {
+	query := `
+		SELECT stream_id, file_path, size, segment_count, missing_segments, duration_ms, assembled_at, status, claimed_until
+		FROM stream_recordings
+		WHERE stream_id = $1
+	`
+
+	recording := &Recording{}
+	var durationMs sql.NullInt64
+	var claimedUntil sql.NullTime
+	err := conn.QueryRowContext(ctx, query, id).Scan(
+		&recording.StreamID,
+		&recording.FilePath,
+		&recording.Size,
+		&recording.SegmentCount,
+		&recording.MissingSegments,
+		&durationMs,
+		&recording.AssembledAt,
+		&recording.status,
+		&claimedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	recording.DurationMs = durationMs.Int64
+	recording.claimedUntil = claimedUntil.Time
+	return recording, nil
+}
+
+// segmentFileName sorts the init segment first and media segments in order
+func segmentFileName(seq int) string {
+	if seq == InitSeq {
+		return "init.mp4"
+	}
+	return fmt.Sprintf("%07d.seg", seq)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into
+// place, so a retried segment never leaves a half-written file behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write segment: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write segment: %w", err)
+	}
+	return nil
+}
+
+// concatFiles writes the files in order into dst and returns its size
+func concatFiles(dst string, paths []string) (int64, error) {
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create recording: %w", err)
+	}
+	defer out.Close()
+
+	var size int64
+	for _, path := range paths {
+		in, err := os.Open(path)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read segment: %w", err)
+		}
+		n, err := io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return 0, fmt.Errorf("failed to write recording: %w", err)
+		}
+		size += n
+	}
+
+	if err := out.Sync(); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
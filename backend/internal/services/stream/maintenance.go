@@ -0,0 +1,88 @@
+package stream
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/google/uuid"
+)
+
+// Heartbeat records that the broadcaster of a live stream is still
+// connected. Only the stream's owner can send heartbeats.
+func (s *Service) Heartbeat(ctx context.Context, streamID, userID string) (time.Time, error) {
+	id, err := uuid.Parse(streamID)
+	if err != nil {
+		return time.Time{}, ErrNotFound
+	}
+
+	query := `
+		UPDATE streams
+		SET last_heartbeat_at = NOW()
+		WHERE id = $1 AND user_id::text = $2 AND is_live = true
+		RETURNING last_heartbeat_at
+	`
+
+	var lastHeartbeatAt time.Time
+	err = db.DB.QueryRowContext(ctx, query, id, userID).Scan(&lastHeartbeatAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// Work out whether the stream is missing, someone else's or over
+			access, err := checkAccess(ctx, id, userID)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if err := access.requireLive(); err != nil {
+				return time.Time{}, err
+			}
+			return time.Time{}, ErrNotFound
+		}
+		return time.Time{}, err
+	}
+
+	return lastHeartbeatAt, nil
+}
+
+// ReapAbandonedStreams ends live streams that haven't sent a heartbeat within
+// the heartbeat timeout. Clients that crash or lose connectivity never call
+// EndStream, so without this they would stay on the live map forever. The
+// stream is treated as having ended at its last heartbeat.
+func (s *Service) ReapAbandonedStreams(ctx context.Context) (int64, error) {
+	query := `
+		UPDATE streams
+		SET is_live = false,
+		    status = 'ended',
+		    ended_at = COALESCE(last_heartbeat_at, started_at),
+		    duration_seconds = EXTRACT(EPOCH FROM (COALESCE(last_heartbeat_at, started_at) - started_at))::INT,
+		    end_reason = $2,
+		    viewer_count = 0
+		WHERE is_live = true AND COALESCE(last_heartbeat_at, started_at) < $1
+		RETURNING id
+	`
+
+	rows, err := db.DB.QueryContext(ctx, query, time.Now().Add(-s.heartbeatTimeout), EndReasonTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reap streams: %w", err)
+	}
+	defer rows.Close()
+
+	var ended []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ended = append(ended, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ended {
+		s.notifyEnded(id, EndReasonTimeout)
+	}
+
+	return int64(len(ended)), nil
+}
@@ -0,0 +1,216 @@
+package stream
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/google/uuid"
+)
+
+// Stream statuses
+const (
+	StatusScheduled = "scheduled"
+	StatusLive      = "live"
+	StatusEnded     = "ended"
+	StatusExpired   = "expired"
+)
+
+// maxScheduleAhead is how far ahead a stream can be announced
+const maxScheduleAhead = 30 * 24 * time.Hour
+
+// Schedule errors
+var (
+	ErrNotStarted      = errors.New("stream has not started yet")
+	ErrScheduleExpired = errors.New("scheduled stream expired without starting")
+	ErrNotScheduled    = errors.New("stream is not scheduled")
+	ErrInvalidSchedule = errors.New("invalid schedule")
+)
+
+// liveErr explains why a stream in status can't be used as a live stream
+func liveErr(status string) error {
+	switch status {
+	case StatusLive:
+		return nil
+	case StatusScheduled:
+		return ErrNotStarted
+	case StatusExpired:
+		return ErrScheduleExpired
+	default:
+		return ErrAlreadyEnded
+	}
+}
+
+// CheckStarted returns an error if the stream never went live, so it has
+// nothing to record or save
+func (s *Stream) CheckStarted() error {
+	switch s.Status {
+	case StatusScheduled:
+		return ErrNotStarted
+	case StatusExpired:
+		return ErrScheduleExpired
+	}
+	return nil
+}
+
+// ScheduleStreamRequest announces a stream ahead of time
+type ScheduleStreamRequest struct {
+	UserID           string    `json:"user_id"`
+	Title            string    `json:"title"`
+	ScheduledStartAt time.Time `json:"scheduled_start_at"`
+	Latitude         float64   `json:"latitude"`
+	Longitude        float64   `json:"longitude"`
+	IsPublic         bool      `json:"is_public"`
+}
+
+// ScheduleGrace is how long after its planned start a scheduled stream can
+// still be started before it expires
+func (s *Service) ScheduleGrace() time.Duration {
+	return s.scheduleGrace
+}
+
+// ScheduleStream creates a stream in the scheduled state at its planned
+// time and place
+func (s *Service) ScheduleStream(ctx context.Context, req *ScheduleStreamRequest) (*Stream, error) {
+	userID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user_id: %w", err)
+	}
+
+	now := time.Now()
+	if !req.ScheduledStartAt.After(now) || req.ScheduledStartAt.After(now.Add(maxScheduleAhead)) {
+		return nil, fmt.Errorf("%w: scheduled_start_at must be in the next %d days", ErrInvalidSchedule, int(maxScheduleAhead.Hours()/24))
+	}
+	if req.Latitude < -90 || req.Latitude > 90 || req.Longitude < -180 || req.Longitude > 180 {
+		return nil, fmt.Errorf("%w: coordinates out of range", ErrInvalidSchedule)
+	}
+
+	// started_at holds the planned start until the stream goes live
+	query := `
+		INSERT INTO streams (id, user_id, title, is_live, status, is_public, started_at, scheduled_start_at, location, viewer_count, created_at)
+		VALUES ($1, $2, $3, false, 'scheduled', $4, $5, $5, ST_SetSRID(ST_MakePoint($6, $7), 4326), 0, $8)
+		RETURNING id
+	`
+
+	var id string
+	err = db.DB.QueryRowContext(ctx, query,
+		uuid.New(), userID, req.Title, req.IsPublic, req.ScheduledStartAt,
+		req.Longitude, req.Latitude, now,
+	).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to schedule stream: %w", err)
+	}
+
+	return s.GetStream(ctx, id, req.UserID)
+}
+
+// startScheduled takes one of the user's scheduled streams live. The
+// planned place is kept unless the request brings a location.
+func (s *Service) startScheduled(ctx context.Context, req *StartStreamRequest) (*Stream, error) {
+	id, err := uuid.Parse(req.StreamID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	hasLocation := req.Latitude != 0 || req.Longitude != 0
+	query := `
+		UPDATE streams
+		SET is_live = true,
+		    status = 'live',
+		    started_at = $3,
+		    last_heartbeat_at = $3,
+		    title = COALESCE(NULLIF($4, ''), title),
+		    location = CASE WHEN $5 THEN ST_SetSRID(ST_MakePoint($6, $7), 4326)::geography ELSE location END
+		WHERE id = $1 AND user_id::text = $2 AND status = 'scheduled' AND scheduled_start_at > $8
+		RETURNING id
+	`
+
+	now := time.Now()
+	var startedID string
+	err = db.DB.QueryRowContext(ctx, query,
+		id, req.UserID, now, req.Title, hasLocation, req.Longitude, req.Latitude, now.Add(-s.scheduleGrace),
+	).Scan(&startedID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Work out whether the stream is missing, someone else's, expired
+			// or not scheduled
+			access, err := checkAccess(ctx, id, req.UserID)
+			if err != nil {
+				return nil, err
+			}
+			if access.status == StatusScheduled || access.status == StatusExpired {
+				return nil, ErrScheduleExpired
+			}
+			return nil, ErrNotScheduled
+		}
+		return nil, fmt.Errorf("failed to start stream: %w", err)
+	}
+
+	return s.GetStream(ctx, startedID, req.UserID)
+}
+
+// ExpireScheduledStreams marks scheduled streams that were never started
+// within the grace period as expired
+func (s *Service) ExpireScheduledStreams(ctx context.Context) (int64, error) {
+	query := `
+		UPDATE streams
+		SET status = 'expired'
+		WHERE status = 'scheduled' AND scheduled_start_at < $1
+	`
+
+	result, err := db.DB.ExecContext(ctx, query, time.Now().Add(-s.scheduleGrace))
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire scheduled streams: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// ListUpcomingStreams returns public scheduled streams from users that
+// userID follows, soonest first
+func (s *Service) ListUpcomingStreams(ctx context.Context, userID string, limit, offset int) ([]*Stream, error) {
+	query := `
+		SELECT s.id, s.user_id, s.title, s.is_public, s.status, s.scheduled_start_at,
+		       ST_X(s.location::geometry) as longitude, ST_Y(s.location::geometry) as latitude
+		FROM streams s
+		INNER JOIN follows f ON f.following_id = s.user_id
+		WHERE f.follower_id::text = $1
+		  AND s.status = 'scheduled'
+		  AND s.is_public = true
+		  AND s.scheduled_start_at > $2
+		ORDER BY s.scheduled_start_at ASC
+		LIMIT $3 OFFSET $4
+	`
+
+	rows, err := db.DB.QueryContext(ctx, query, userID, time.Now().Add(-s.scheduleGrace), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	streams := []*Stream{}
+	for rows.Next() {
+		stream := &Stream{}
+		var scheduledStartAt time.Time
+		var dbUserID uuid.UUID
+
+		err := rows.Scan(
+			&stream.ID, &dbUserID, &stream.Title, &stream.IsPublic, &stream.Status, &scheduledStartAt,
+			&stream.Longitude, &stream.Latitude,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		stream.UserID = dbUserID.String()
+		stream.StartedAt = scheduledStartAt
+		stream.ScheduledStartAt = &scheduledStartAt
+
+		streams = append(streams, stream)
+	}
+
+	return streams, rows.Err()
+}
@@ -3,39 +3,105 @@ package stream
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/alexcolls/now.ink/backend/internal/db"
 	"github.com/google/uuid"
 )
 
+// Stream end reasons
+const (
+	EndReasonUser    = "user"
+	EndReasonTimeout = "timeout"
+	EndReasonAdmin   = "admin"
+)
+
+// Stream errors. Private streams of other users are reported as not found
+// so their existence isn't leaked.
+var (
+	ErrNotFound     = errors.New("stream not found")
+	ErrForbidden    = errors.New("not the owner of this stream")
+	ErrAlreadyEnded = errors.New("stream already ended")
+)
+
 // Service handles stream operations
-type Service struct{}
+type Service struct {
+	heartbeatTimeout time.Duration
+	scheduleGrace    time.Duration
+	endListeners     []func(streamID, reason string)
+	viewers          *viewerTracker
+}
 
 // NewService creates a new stream service
 func NewService() *Service {
-	return &Service{}
+	timeout, err := time.ParseDuration(os.Getenv("STREAM_HEARTBEAT_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		timeout = 90 * time.Second
+	}
+	grace, err := time.ParseDuration(os.Getenv("STREAM_SCHEDULE_GRACE"))
+	if err != nil || grace <= 0 {
+		grace = time.Hour
+	}
+	return &Service{
+		heartbeatTimeout: timeout,
+		scheduleGrace:    grace,
+		viewers:          newViewerTracker(),
+	}
+}
+
+// HeartbeatTimeout is how long a live stream may go without a heartbeat
+// before it is ended
+func (s *Service) HeartbeatTimeout() time.Duration {
+	return s.heartbeatTimeout
+}
+
+// OnStreamEnded registers fn to run whenever a stream ends, however it was
+// ended. Listeners must be registered before the service is used.
+func (s *Service) OnStreamEnded(fn func(streamID, reason string)) {
+	s.endListeners = append(s.endListeners, fn)
+}
+
+func (s *Service) notifyEnded(streamID, reason string) {
+	for _, fn := range s.endListeners {
+		fn(streamID, reason)
+	}
 }
 
 // Stream represents a live stream or recording
 type Stream struct {
-	ID          string    `json:"id"`
-	UserID      string    `json:"user_id"`
-	Title       string    `json:"title"`
-	IsLive      bool      `json:"is_live"`
-	IsPublic    bool      `json:"is_public"`
-	StartedAt   time.Time `json:"started_at"`
-	EndedAt     *time.Time `json:"ended_at,omitempty"`
-	Latitude    float64   `json:"latitude"`
-	Longitude   float64   `json:"longitude"`
-	ViewerCount int       `json:"viewer_count"`
-	MintAddress string    `json:"mint_address,omitempty"`
-	ArweaveHash string    `json:"arweave_hash,omitempty"`
-}
-
-// StartStreamRequest represents stream start data
+	ID              string     `json:"id"`
+	UserID          string     `json:"user_id"`
+	Title           string     `json:"title"`
+	IsLive          bool       `json:"is_live"`
+	Status          string     `json:"status"`
+	IsPublic        bool       `json:"is_public"`
+	StartedAt       time.Time  `json:"started_at"`
+	EndedAt         *time.Time `json:"ended_at,omitempty"`
+	Latitude        float64    `json:"latitude"`
+	Longitude       float64    `json:"longitude"`
+	ViewerCount     int        `json:"viewer_count"`
+	PeakViewers     int        `json:"peak_viewers"`
+	UniqueViewers   int        `json:"unique_viewers"`
+	MintAddress     string     `json:"mint_address,omitempty"`
+	ArweaveHash     string     `json:"arweave_hash,omitempty"`
+	EndReason       string     `json:"end_reason,omitempty"`
+	LastHeartbeatAt *time.Time `json:"last_heartbeat_at,omitempty"`
+	// ScheduledStartAt is when a scheduled stream is planned to go live
+	ScheduledStartAt *time.Time `json:"scheduled_start_at,omitempty"`
+	// Track is a GeoJSON Feature of the GPS trajectory, set by GetStream
+	Track json.RawMessage `json:"track,omitempty"`
+	// DistanceMeters is set by ListLiveStreams when searching an area
+	DistanceMeters *float64 `json:"distance_meters,omitempty"`
+}
+
+// StartStreamRequest represents stream start data. Setting StreamID starts
+// one of the user's scheduled streams instead of creating a new one.
 type StartStreamRequest struct {
+	StreamID  string  `json:"stream_id,omitempty"`
 	UserID    string  `json:"user_id"`
 	Title     string  `json:"title"`
 	Latitude  float64 `json:"latitude"`
@@ -45,6 +111,10 @@ type StartStreamRequest struct {
 
 // StartStream initiates a new live stream
 func (s *Service) StartStream(ctx context.Context, req *StartStreamRequest) (*Stream, error) {
+	if req.StreamID != "" {
+		return s.startScheduled(ctx, req)
+	}
+
 	streamID := uuid.New()
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
@@ -52,17 +122,17 @@ func (s *Service) StartStream(ctx context.Context, req *StartStreamRequest) (*St
 	}
 
 	query := `
-		INSERT INTO streams (id, user_id, title, is_live, is_public, started_at, location, viewer_count, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, ST_SetSRID(ST_MakePoint($7, $8), 4326), $9, $10)
+		INSERT INTO streams (id, user_id, title, is_live, status, is_public, started_at, location, viewer_count, created_at, last_heartbeat_at)
+		VALUES ($1, $2, $3, $4, 'live', $5, $6, ST_SetSRID(ST_MakePoint($7, $8), 4326), $9, $10, $6)
 		RETURNING id, user_id, title, is_live, is_public, started_at, ended_at, 
 		          ST_X(location::geometry) as longitude, ST_Y(location::geometry) as latitude,
-		          viewer_count, nft_mint_address, arweave_tx_id
+		          viewer_count, peak_viewers, unique_viewers, nft_mint_address, arweave_tx_id, end_reason, last_heartbeat_at, status, scheduled_start_at
 	`
 
 	now := time.Now()
 	stream := &Stream{}
-	var endedAt sql.NullTime
-	var mintAddress, arweaveTxID sql.NullString
+	var endedAt, lastHeartbeatAt, scheduledStartAt sql.NullTime
+	var mintAddress, arweaveTxID, endReason sql.NullString
 	var dbUserID uuid.UUID
 
 	err = db.DB.QueryRowContext(ctx, query,
@@ -71,7 +141,8 @@ func (s *Service) StartStream(ctx context.Context, req *StartStreamRequest) (*St
 	).Scan(
 		&stream.ID, &dbUserID, &stream.Title, &stream.IsLive, &stream.IsPublic,
 		&stream.StartedAt, &endedAt, &stream.Longitude, &stream.Latitude,
-		&stream.ViewerCount, &mintAddress, &arweaveTxID,
+		&stream.ViewerCount, &stream.PeakViewers, &stream.UniqueViewers, &mintAddress, &arweaveTxID, &endReason, &lastHeartbeatAt,
+		&stream.Status, &scheduledStartAt,
 	)
 
 	if err != nil {
@@ -88,54 +159,77 @@ func (s *Service) StartStream(ctx context.Context, req *StartStreamRequest) (*St
 	if arweaveTxID.Valid {
 		stream.ArweaveHash = arweaveTxID.String
 	}
+	if endReason.Valid {
+		stream.EndReason = endReason.String
+	}
+	if lastHeartbeatAt.Valid {
+		stream.LastHeartbeatAt = &lastHeartbeatAt.Time
+	}
+	if scheduledStartAt.Valid {
+		stream.ScheduledStartAt = &scheduledStartAt.Time
+	}
 
 	return stream, nil
 }
 
-// EndStream stops a live stream
-func (s *Service) EndStream(ctx context.Context, streamID string) (*Stream, error) {
+// EndStream stops one of the user's live streams
+func (s *Service) EndStream(ctx context.Context, streamID, userID string) (*Stream, error) {
+	return s.endStream(ctx, streamID, userID, EndReasonUser)
+}
+
+// AdminEndStream stops any live stream on behalf of a moderator
+func (s *Service) AdminEndStream(ctx context.Context, streamID string) (*Stream, error) {
+	return s.endStream(ctx, streamID, "", EndReasonAdmin)
+}
+
+// endStream stops a live stream, recording why it ended. An empty userID
+// skips the ownership check.
+func (s *Service) endStream(ctx context.Context, streamID, userID, reason string) (*Stream, error) {
 	id, err := uuid.Parse(streamID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid stream_id: %w", err)
+		return nil, ErrNotFound
 	}
 
-	// First get the stream to calculate duration
-	var startedAt time.Time
-	query := `SELECT started_at FROM streams WHERE id = $1 AND is_live = true`
-	err = db.DB.QueryRowContext(ctx, query, id).Scan(&startedAt)
+	// First check access and get the stream to calculate duration
+	access, err := checkAccess(ctx, id, userID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("stream not found or already ended")
-		}
+		return nil, err
+	}
+	if err := access.requireLive(); err != nil {
 		return nil, err
 	}
 
 	now := time.Now()
-	duration := int(now.Sub(startedAt).Seconds())
+	duration := int(now.Sub(access.startedAt).Seconds())
 
 	// Update stream
 	updateQuery := `
 		UPDATE streams 
-		SET is_live = false, ended_at = $1, duration_seconds = $2
-		WHERE id = $3
+		SET is_live = false, status = 'ended', ended_at = $1, duration_seconds = $2, end_reason = $4, viewer_count = 0
+		WHERE id = $3 AND is_live = true
 		RETURNING id, user_id, title, is_live, is_public, started_at, ended_at,
 		          ST_X(location::geometry) as longitude, ST_Y(location::geometry) as latitude,
-		          viewer_count, duration_seconds, nft_mint_address, arweave_tx_id
+		          viewer_count, peak_viewers, unique_viewers, duration_seconds, nft_mint_address, arweave_tx_id, end_reason, last_heartbeat_at, status, scheduled_start_at
 	`
 
 	stream := &Stream{}
-	var endedAt sql.NullTime
+	var endedAt, lastHeartbeatAt, scheduledStartAt sql.NullTime
 	var durationSeconds sql.NullInt64
-	var mintAddress, arweaveTxID sql.NullString
+	var mintAddress, arweaveTxID, endReason sql.NullString
 	var dbUserID uuid.UUID
 
-	err = db.DB.QueryRowContext(ctx, updateQuery, now, duration, id).Scan(
+	err = db.DB.QueryRowContext(ctx, updateQuery, now, duration, id, reason).Scan(
 		&stream.ID, &dbUserID, &stream.Title, &stream.IsLive, &stream.IsPublic,
 		&stream.StartedAt, &endedAt, &stream.Longitude, &stream.Latitude,
-		&stream.ViewerCount, &durationSeconds, &mintAddress, &arweaveTxID,
+		&stream.ViewerCount, &stream.PeakViewers, &stream.UniqueViewers, &durationSeconds, &mintAddress, &arweaveTxID, &endReason, &lastHeartbeatAt,
+		&stream.Status, &scheduledStartAt,
 	)
 
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// Ended concurrently since the access check
+			return nil, ErrAlreadyEnded
+		}
 		return nil, fmt.Errorf("failed to end stream: %w", err)
 	}
 
@@ -149,44 +243,70 @@ func (s *Service) EndStream(ctx context.Context, streamID string) (*Stream, erro
 	if arweaveTxID.Valid {
 		stream.ArweaveHash = arweaveTxID.String
 	}
+	if endReason.Valid {
+		stream.EndReason = endReason.String
+	}
+	if lastHeartbeatAt.Valid {
+		stream.LastHeartbeatAt = &lastHeartbeatAt.Time
+	}
+	if scheduledStartAt.Valid {
+		stream.ScheduledStartAt = &scheduledStartAt.Time
+	}
+
+	s.notifyEnded(stream.ID, reason)
 
 	return stream, nil
 }
 
-// GetStream retrieves a stream by ID
-func (s *Service) GetStream(ctx context.Context, streamID string) (*Stream, error) {
+// GetStream retrieves a stream by ID as seen by userID. Private streams are
+// only visible to their owner.
+func (s *Service) GetStream(ctx context.Context, streamID, userID string) (*Stream, error) {
 	id, err := uuid.Parse(streamID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid stream_id: %w", err)
+		return nil, ErrNotFound
 	}
 
 	query := `
 		SELECT id, user_id, title, is_live, is_public, started_at, ended_at,
 		       ST_X(location::geometry) as longitude, ST_Y(location::geometry) as latitude,
-		       viewer_count, duration_seconds, nft_mint_address, arweave_tx_id
+		       viewer_count, peak_viewers, unique_viewers, duration_seconds, nft_mint_address, arweave_tx_id, end_reason, last_heartbeat_at, status, scheduled_start_at,
+		       CASE WHEN track IS NOT NULL THEN json_build_object(
+		           'type', 'Feature',
+		           'geometry', ST_AsGeoJSON(track)::json,
+		           'properties', json_build_object(
+		               'timestamps', (SELECT json_agg(to_timestamp(ST_M(p.geom)) ORDER BY p.path) FROM ST_DumpPoints(track) p),
+		               'length_meters', ST_Length(track::geography)
+		           )
+		       ) END AS track
 		FROM streams
 		WHERE id = $1
 	`
 
 	stream := &Stream{}
-	var endedAt sql.NullTime
+	var endedAt, lastHeartbeatAt, scheduledStartAt sql.NullTime
 	var durationSeconds sql.NullInt64
-	var mintAddress, arweaveTxID sql.NullString
+	var mintAddress, arweaveTxID, endReason, track sql.NullString
 	var dbUserID uuid.UUID
 
 	err = db.DB.QueryRowContext(ctx, query, id).Scan(
 		&stream.ID, &dbUserID, &stream.Title, &stream.IsLive, &stream.IsPublic,
 		&stream.StartedAt, &endedAt, &stream.Longitude, &stream.Latitude,
-		&stream.ViewerCount, &durationSeconds, &mintAddress, &arweaveTxID,
+		&stream.ViewerCount, &stream.PeakViewers, &stream.UniqueViewers, &durationSeconds, &mintAddress, &arweaveTxID, &endReason, &lastHeartbeatAt,
+		&stream.Status, &scheduledStartAt,
+		&track,
 	)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("stream not found")
+			return nil, ErrNotFound
 		}
 		return nil, err
 	}
 
+	if !stream.IsPublic && dbUserID.String() != userID {
+		return nil, ErrNotFound
+	}
+
 	stream.UserID = dbUserID.String()
 	if endedAt.Valid {
 		stream.EndedAt = &endedAt.Time
@@ -197,23 +317,186 @@ func (s *Service) GetStream(ctx context.Context, streamID string) (*Stream, erro
 	if arweaveTxID.Valid {
 		stream.ArweaveHash = arweaveTxID.String
 	}
+	if endReason.Valid {
+		stream.EndReason = endReason.String
+	}
+	if lastHeartbeatAt.Valid {
+		stream.LastHeartbeatAt = &lastHeartbeatAt.Time
+	}
+	if scheduledStartAt.Valid {
+		stream.ScheduledStartAt = &scheduledStartAt.Time
+	}
+	if track.Valid {
+		stream.Track = json.RawMessage(track.String)
+	}
 
 	return stream, nil
 }
 
-// ListLiveStreams returns all currently live streams
-func (s *Service) ListLiveStreams(ctx context.Context, limit, offset int) ([]*Stream, error) {
-	query := `
+// streamAccess is what ownership checks need to know about a stream
+type streamAccess struct {
+	status    string
+	startedAt time.Time
+}
+
+// requireLive explains why a stream that isn't live can't be used as one
+func (a *streamAccess) requireLive() error {
+	return liveErr(a.status)
+}
+
+// checkAccess returns ErrNotFound if the stream doesn't exist (or is another
+// user's private stream) and ErrForbidden if userID doesn't own it. An empty
+// userID skips the ownership check.
+func checkAccess(ctx context.Context, id uuid.UUID, userID string) (*streamAccess, error) {
+	query := `SELECT user_id, is_public, status, started_at FROM streams WHERE id = $1`
+
+	access := &streamAccess{}
+	var ownerID uuid.UUID
+	var isPublic bool
+	err := db.DB.QueryRowContext(ctx, query, id).Scan(&ownerID, &isPublic, &access.status, &access.startedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if userID != "" && ownerID.String() != userID {
+		if !isPublic {
+			return nil, ErrNotFound
+		}
+		return nil, ErrForbidden
+	}
+
+	return access, nil
+}
+
+// Live stream sort orders
+const (
+	SortDistance = "distance"
+	SortRecent   = "recent"
+)
+
+// maxLiveRadiusKm bounds radius searches; wider areas should use a bbox
+const maxLiveRadiusKm = 1000
+
+// ErrInvalidFilter is returned for unusable live stream filters
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// BBox is a map viewport in degrees
+type BBox struct {
+	MinLongitude float64 `json:"min_longitude"`
+	MinLatitude  float64 `json:"min_latitude"`
+	MaxLongitude float64 `json:"max_longitude"`
+	MaxLatitude  float64 `json:"max_latitude"`
+}
+
+// LiveStreamFilters narrows live streams to an area. Distances are measured
+// from Latitude/Longitude when set, otherwise from the centre of BBox.
+type LiveStreamFilters struct {
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	RadiusKm  float64  `json:"radius_km"`
+	BBox      *BBox    `json:"bbox"`
+	Sort      string   `json:"sort"`
+	Limit     int      `json:"limit"`
+	Offset    int      `json:"offset"`
+}
+
+// validate checks the filters and fills in the default sort order
+func (f *LiveStreamFilters) validate() error {
+	if (f.Latitude == nil) != (f.Longitude == nil) {
+		return fmt.Errorf("%w: lat and lng must be given together", ErrInvalidFilter)
+	}
+	if f.Latitude != nil && (*f.Latitude < -90 || *f.Latitude > 90 || *f.Longitude < -180 || *f.Longitude > 180) {
+		return fmt.Errorf("%w: lat/lng out of range", ErrInvalidFilter)
+	}
+	if f.RadiusKm != 0 {
+		if f.Latitude == nil {
+			return fmt.Errorf("%w: radius_km requires lat and lng", ErrInvalidFilter)
+		}
+		if f.RadiusKm < 0 || f.RadiusKm > maxLiveRadiusKm {
+			return fmt.Errorf("%w: radius_km must be between 0 and %d", ErrInvalidFilter, maxLiveRadiusKm)
+		}
+	}
+	if b := f.BBox; b != nil {
+		if b.MinLatitude < -90 || b.MaxLatitude > 90 || b.MinLongitude < -180 || b.MaxLongitude > 180 {
+			return fmt.Errorf("%w: bbox out of range", ErrInvalidFilter)
+		}
+		if b.MinLatitude >= b.MaxLatitude || b.MinLongitude >= b.MaxLongitude {
+			return fmt.Errorf("%w: bbox must be min_lng,min_lat,max_lng,max_lat", ErrInvalidFilter)
+		}
+	}
+
+	hasOrigin := f.Latitude != nil || f.BBox != nil
+	switch f.Sort {
+	case "":
+		f.Sort = SortRecent
+		if hasOrigin {
+			f.Sort = SortDistance
+		}
+	case SortRecent:
+	case SortDistance:
+		if !hasOrigin {
+			return fmt.Errorf("%w: sorting by distance requires lat/lng or bbox", ErrInvalidFilter)
+		}
+	default:
+		return fmt.Errorf("%w: sort must be %q or %q", ErrInvalidFilter, SortDistance, SortRecent)
+	}
+
+	return nil
+}
+
+// ListLiveStreams returns currently live public streams, optionally within a
+// radius or bbox. Both filters use the GIST index on location, and distance
+// sorting uses its nearest-neighbour ordering.
+func (s *Service) ListLiveStreams(ctx context.Context, filters *LiveStreamFilters) ([]*Stream, error) {
+	if err := filters.validate(); err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// Distance origin
+	origin := "NULL::geography"
+	if filters.Latitude != nil {
+		origin = fmt.Sprintf("ST_SetSRID(ST_MakePoint(%s, %s), 4326)::geography", arg(*filters.Longitude), arg(*filters.Latitude))
+	} else if b := filters.BBox; b != nil {
+		origin = fmt.Sprintf("ST_SetSRID(ST_MakePoint(%s, %s), 4326)::geography",
+			arg((b.MinLongitude+b.MaxLongitude)/2), arg((b.MinLatitude+b.MaxLatitude)/2))
+	}
+
+	query := fmt.Sprintf(`
 		SELECT id, user_id, title, is_live, is_public, started_at, ended_at,
 		       ST_X(location::geometry) as longitude, ST_Y(location::geometry) as latitude,
-		       viewer_count, duration_seconds, nft_mint_address, arweave_tx_id
+		       viewer_count, peak_viewers, unique_viewers, duration_seconds, nft_mint_address, arweave_tx_id, end_reason, last_heartbeat_at, status, scheduled_start_at,
+		       ST_Distance(location, %[1]s) AS distance_meters
 		FROM streams
 		WHERE is_live = true AND is_public = true
-		ORDER BY started_at DESC
-		LIMIT $1 OFFSET $2
-	`
+	`, origin)
+
+	if filters.RadiusKm > 0 {
+		query += fmt.Sprintf(" AND ST_DWithin(location, %s, %s)", origin, arg(filters.RadiusKm*1000))
+	}
+
+	if b := filters.BBox; b != nil {
+		query += fmt.Sprintf(" AND ST_Intersects(location, ST_MakeEnvelope(%s, %s, %s, %s, 4326)::geography)",
+			arg(b.MinLongitude), arg(b.MinLatitude), arg(b.MaxLongitude), arg(b.MaxLatitude))
+	}
+
+	if filters.Sort == SortDistance {
+		query += fmt.Sprintf(" ORDER BY location <-> %s, started_at DESC", origin)
+	} else {
+		query += " ORDER BY started_at DESC"
+	}
+
+	query += fmt.Sprintf(" LIMIT %s OFFSET %s", arg(filters.Limit), arg(filters.Offset))
 
-	rows, err := db.DB.QueryContext(ctx, query, limit, offset)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -222,15 +505,18 @@ func (s *Service) ListLiveStreams(ctx context.Context, limit, offset int) ([]*St
 	streams := []*Stream{}
 	for rows.Next() {
 		stream := &Stream{}
-		var endedAt sql.NullTime
+		var endedAt, lastHeartbeatAt, scheduledStartAt sql.NullTime
 		var durationSeconds sql.NullInt64
-		var mintAddress, arweaveTxID sql.NullString
+		var distance sql.NullFloat64
+		var mintAddress, arweaveTxID, endReason sql.NullString
 		var dbUserID uuid.UUID
 
 		err := rows.Scan(
 			&stream.ID, &dbUserID, &stream.Title, &stream.IsLive, &stream.IsPublic,
 			&stream.StartedAt, &endedAt, &stream.Longitude, &stream.Latitude,
-			&stream.ViewerCount, &durationSeconds, &mintAddress, &arweaveTxID,
+			&stream.ViewerCount, &stream.PeakViewers, &stream.UniqueViewers, &durationSeconds, &mintAddress, &arweaveTxID, &endReason, &lastHeartbeatAt,
+			&stream.Status, &scheduledStartAt,
+			&distance,
 		)
 		if err != nil {
 			return nil, err
@@ -246,6 +532,18 @@ func (s *Service) ListLiveStreams(ctx context.Context, limit, offset int) ([]*St
 		if arweaveTxID.Valid {
 			stream.ArweaveHash = arweaveTxID.String
 		}
+		if endReason.Valid {
+			stream.EndReason = endReason.String
+		}
+		if lastHeartbeatAt.Valid {
+			stream.LastHeartbeatAt = &lastHeartbeatAt.Time
+		}
+		if scheduledStartAt.Valid {
+			stream.ScheduledStartAt = &scheduledStartAt.Time
+		}
+		if distance.Valid {
+			stream.DistanceMeters = &distance.Float64
+		}
 
 		streams = append(streams, stream)
 	}
@@ -0,0 +1,183 @@
+package stream
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Track limits
+const (
+	// MaxTrackBatch is how many samples one request may append
+	MaxTrackBatch = 500
+	// maxTrackPoints caps a track at a few hours of one-second samples
+	maxTrackPoints = 20000
+	// trackClockSkew is how far sample times may be off from the server's
+	trackClockSkew = time.Minute
+)
+
+// Track errors
+var (
+	ErrInvalidTrack = errors.New("invalid location samples")
+	ErrTrackFull    = errors.New("location track is full")
+)
+
+// TrackSample is one GPS fix taken while streaming
+type TrackSample struct {
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// TrackSummary describes where a stream's track starts and ends
+type TrackSummary struct {
+	StartLatitude  float64   `json:"start_latitude"`
+	StartLongitude float64   `json:"start_longitude"`
+	StartedAt      time.Time `json:"started_at"`
+	EndLatitude    float64   `json:"end_latitude"`
+	EndLongitude   float64   `json:"end_longitude"`
+	EndedAt        time.Time `json:"ended_at"`
+	Points         int       `json:"points"`
+	LengthMeters   float64   `json:"length_meters"`
+}
+
+// AppendTrack adds GPS samples to one of the user's live streams and
+// returns the track's point count and length. The track starts at the
+// stream's start location; samples may arrive out of order or repeated and
+// are kept sorted by time, one per timestamp.
+func (s *Service) AppendTrack(ctx context.Context, streamID, userID string, samples []TrackSample) (int, float64, error) {
+	id, err := uuid.Parse(streamID)
+	if err != nil {
+		return 0, 0, ErrNotFound
+	}
+
+	access, err := checkAccess(ctx, id, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := access.requireLive(); err != nil {
+		return 0, 0, err
+	}
+
+	if len(samples) == 0 || len(samples) > MaxTrackBatch {
+		return 0, 0, fmt.Errorf("%w: send 1 to %d samples", ErrInvalidTrack, MaxTrackBatch)
+	}
+
+	latest := time.Now().Add(trackClockSkew)
+	lats := make([]float64, len(samples))
+	lngs := make([]float64, len(samples))
+	times := make([]string, len(samples))
+	for i, sample := range samples {
+		if sample.Latitude < -90 || sample.Latitude > 90 || sample.Longitude < -180 || sample.Longitude > 180 {
+			return 0, 0, fmt.Errorf("%w: coordinates out of range", ErrInvalidTrack)
+		}
+		if sample.Timestamp.Before(access.startedAt.Add(-trackClockSkew)) || sample.Timestamp.After(latest) {
+			return 0, 0, fmt.Errorf("%w: timestamp outside the stream", ErrInvalidTrack)
+		}
+		lats[i] = sample.Latitude
+		lngs[i] = sample.Longitude
+		times[i] = sample.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	// Hold the stream row while merging, so concurrent batches queue up
+	// instead of each merging into the same stored track and one winning
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM streams WHERE id = $1 FOR UPDATE`, id); err != nil {
+		return 0, 0, fmt.Errorf("failed to lock stream: %w", err)
+	}
+
+	// Merge the stored track (or the start point) with the new samples;
+	// stored points win when a timestamp repeats
+	query := `
+		WITH incoming AS (
+			SELECT ST_SetSRID(ST_MakePointM(s.lng, s.lat, EXTRACT(EPOCH FROM s.ts)), 4326) AS pt, 1 AS src
+			FROM unnest($2::float8[], $3::float8[], $4::timestamptz[]) AS s(lng, lat, ts)
+		),
+		stored AS (
+			SELECT (ST_DumpPoints(track)).geom AS pt, 0 AS src
+			FROM streams WHERE id = $1 AND track IS NOT NULL
+			UNION ALL
+			SELECT ST_SetSRID(ST_MakePointM(ST_X(location::geometry), ST_Y(location::geometry), EXTRACT(EPOCH FROM started_at)), 4326), 0
+			FROM streams WHERE id = $1 AND track IS NULL AND location IS NOT NULL
+		),
+		merged AS (
+			SELECT DISTINCT ON (ST_M(pt)) pt
+			FROM (SELECT pt, src FROM stored UNION ALL SELECT pt, src FROM incoming) points
+			ORDER BY ST_M(pt), src
+		),
+		line AS (
+			SELECT ST_MakeLine(pt ORDER BY ST_M(pt)) AS track, COUNT(*) AS points FROM merged
+		)
+		UPDATE streams
+		SET track = CASE WHEN line.points >= 2 THEN line.track ELSE streams.track END
+		FROM line
+		WHERE streams.id = $1 AND streams.is_live = true AND line.points <= $5
+		RETURNING COALESCE(ST_NPoints(streams.track), 0), COALESCE(ST_Length(streams.track::geography), 0)
+	`
+
+	var points int
+	var length float64
+	err = tx.QueryRowContext(ctx, query, id, pq.Array(lngs), pq.Array(lats), pq.Array(times), maxTrackPoints).Scan(&points, &length)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			access, err := checkAccess(ctx, id, userID)
+			if err != nil {
+				return 0, 0, err
+			}
+			if err := access.requireLive(); err != nil {
+				return 0, 0, err
+			}
+			return 0, 0, ErrTrackFull
+		}
+		return 0, 0, fmt.Errorf("failed to append track: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	return points, length, nil
+}
+
+// GetTrackSummary returns where a stream's track starts and ends, or nil
+// if no samples were recorded
+func (s *Service) GetTrackSummary(ctx context.Context, streamID string) (*TrackSummary, error) {
+	id, err := uuid.Parse(streamID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	query := `
+		SELECT ST_Y(ST_StartPoint(track)), ST_X(ST_StartPoint(track)), to_timestamp(ST_M(ST_StartPoint(track))),
+		       ST_Y(ST_EndPoint(track)), ST_X(ST_EndPoint(track)), to_timestamp(ST_M(ST_EndPoint(track))),
+		       ST_NPoints(track), ST_Length(track::geography)
+		FROM streams
+		WHERE id = $1 AND track IS NOT NULL
+	`
+
+	summary := &TrackSummary{}
+	err = db.DB.QueryRowContext(ctx, query, id).Scan(
+		&summary.StartLatitude, &summary.StartLongitude, &summary.StartedAt,
+		&summary.EndLatitude, &summary.EndLongitude, &summary.EndedAt,
+		&summary.Points, &summary.LengthMeters,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return summary, nil
+}
@@ -8,20 +8,16 @@ import (
 	"github.com/google/uuid"
 )
 
-// UpdateStreamMintInfo updates a stream with NFT minting information
-func (s *Service) UpdateStreamMintInfo(ctx context.Context, streamID, mintAddress, arweaveTxID string) error {
+// SetStreamVisibility makes a stream public or private
+func (s *Service) SetStreamVisibility(ctx context.Context, streamID string, isPublic bool) error {
 	id, err := uuid.Parse(streamID)
 	if err != nil {
-		return fmt.Errorf("invalid stream_id: %w", err)
+		return ErrNotFound
 	}
 
-	query := `
-		UPDATE streams
-		SET nft_mint_address = $1, arweave_tx_id = $2
-		WHERE id = $3
-	`
+	query := `UPDATE streams SET is_public = $1 WHERE id = $2`
 
-	result, err := db.DB.ExecContext(ctx, query, mintAddress, arweaveTxID, id)
+	result, err := db.DB.ExecContext(ctx, query, isPublic, id)
 	if err != nil {
 		return fmt.Errorf("failed to update stream: %w", err)
 	}
@@ -32,7 +28,7 @@ func (s *Service) UpdateStreamMintInfo(ctx context.Context, streamID, mintAddres
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("stream not found: %s", streamID)
+		return ErrNotFound
 	}
 
 	return nil
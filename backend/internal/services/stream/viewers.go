@@ -0,0 +1,196 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/lib/pq"
+)
+
+// viewerDelta is the viewer activity on one stream since the last flush
+type viewerDelta struct {
+	// change is the net change in concurrent viewers
+	change int
+	// peak is the highest value change reached, so short spikes between
+	// flushes still count towards peak_viewers
+	peak int
+	// newViewers are users who joined since the last flush
+	newViewers map[string]struct{}
+}
+
+// viewerTracker counts viewers in memory and writes the counts back in
+// batches. It stores deltas rather than totals so several API instances can
+// count viewers of the same stream.
+type viewerTracker struct {
+	mu     sync.Mutex
+	deltas map[string]*viewerDelta
+	// connected is how many viewers are connected to this instance
+	connected map[string]int
+}
+
+func newViewerTracker() *viewerTracker {
+	return &viewerTracker{
+		deltas:    map[string]*viewerDelta{},
+		connected: map[string]int{},
+	}
+}
+
+func (t *viewerTracker) delta(streamID string) *viewerDelta {
+	d, ok := t.deltas[streamID]
+	if !ok {
+		d = &viewerDelta{newViewers: map[string]struct{}{}}
+		t.deltas[streamID] = d
+	}
+	return d
+}
+
+// ViewerJoined records a viewer connecting to a stream
+func (s *Service) ViewerJoined(streamID, userID string) {
+	t := s.viewers
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.connected[streamID]++
+	d := t.delta(streamID)
+	d.change++
+	if d.change > d.peak {
+		d.peak = d.change
+	}
+	if userID != "" {
+		d.newViewers[userID] = struct{}{}
+	}
+}
+
+// ViewerLeft records a viewer disconnecting from a stream
+func (s *Service) ViewerLeft(streamID, userID string) {
+	t := s.viewers
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.connected[streamID]--; t.connected[streamID] <= 0 {
+		delete(t.connected, streamID)
+	}
+	t.delta(streamID).change--
+}
+
+// disconnectAll counts every viewer connected to this instance as gone, so
+// an instance shutting down doesn't leave its viewers counted forever
+func (t *viewerTracker) disconnectAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for streamID, n := range t.connected {
+		t.delta(streamID).change -= n
+	}
+	t.connected = map[string]int{}
+}
+
+// FlushViewerCounts writes the viewer activity since the last flush to the
+// streams. Ended streams keep their peak and unique viewers but drop to zero
+// current viewers.
+func (s *Service) FlushViewerCounts(ctx context.Context) error {
+	t := s.viewers
+	t.mu.Lock()
+	deltas := t.deltas
+	t.deltas = map[string]*viewerDelta{}
+	t.mu.Unlock()
+
+	var failed error
+	for streamID, d := range deltas {
+		if err := flushViewerDelta(ctx, streamID, d); err != nil {
+			failed = err
+			t.restore(streamID, d)
+		}
+	}
+
+	return failed
+}
+
+// restore puts back a delta that failed to flush so it is retried
+func (t *viewerTracker) restore(streamID string, d *viewerDelta) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current := t.delta(streamID)
+	current.peak = max(d.peak, d.change+current.peak)
+	current.change += d.change
+	for userID := range d.newViewers {
+		current.newViewers[userID] = struct{}{}
+	}
+}
+
+func flushViewerDelta(ctx context.Context, streamID string, d *viewerDelta) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(d.newViewers) > 0 {
+		userIDs := make([]string, 0, len(d.newViewers))
+		for userID := range d.newViewers {
+			userIDs = append(userIDs, userID)
+		}
+
+		query := `
+			INSERT INTO stream_viewers (stream_id, user_id)
+			SELECT $1, unnest($2::uuid[])
+			ON CONFLICT DO NOTHING
+		`
+		if _, err := tx.ExecContext(ctx, query, streamID, pq.Array(userIDs)); err != nil {
+			return fmt.Errorf("failed to record viewers: %w", err)
+		}
+	}
+
+	// SET expressions see the row as it was, so peak uses the old count
+	query := `
+		UPDATE streams
+		SET viewer_count = CASE WHEN is_live THEN GREATEST(viewer_count + $2, 0) ELSE 0 END,
+		    peak_viewers = GREATEST(peak_viewers, viewer_count + $3),
+		    unique_viewers = (SELECT COUNT(*) FROM stream_viewers WHERE stream_id = $1)
+		WHERE id = $1
+	`
+	if _, err := tx.ExecContext(ctx, query, streamID, d.change, d.peak); err != nil {
+		return fmt.Errorf("failed to update viewer counts: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// viewerFlushInterval returns how often viewer counts are written back
+func viewerFlushInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("STREAM_VIEWER_FLUSH_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return 5 * time.Second
+	}
+	return interval
+}
+
+// RunViewerFlusher writes viewer counts back every few seconds until ctx is
+// cancelled, then flushes once more with this instance's viewers removed.
+// Each instance runs its own, since the counts it holds are for the viewers
+// connected to it.
+func (s *Service) RunViewerFlusher(ctx context.Context) {
+	ticker := time.NewTicker(viewerFlushInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.viewers.disconnectAll()
+			if err := s.FlushViewerCounts(context.Background()); err != nil {
+				log.Printf("⚠️  Failed to flush viewer counts: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.FlushViewerCounts(ctx); err != nil {
+				log.Printf("⚠️  Failed to flush viewer counts: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/google/uuid"
+)
+
+// Upload statuses
+const (
+	StatusUploading = "uploading"
+	StatusCompleted = "completed"
+	StatusConsumed  = "consumed"
+)
+
+// Upload errors
+var (
+	ErrNotFound           = errors.New("upload not found")
+	ErrExpired            = errors.New("upload expired")
+	ErrInvalidUpload      = errors.New("invalid upload")
+	ErrTooLarge           = errors.New("upload too large")
+	ErrChunkTooLarge      = errors.New("chunk too large")
+	ErrOffsetMismatch     = errors.New("offset does not match the stored upload offset")
+	ErrChecksumMismatch   = errors.New("chunk checksum mismatch")
+	ErrUnsupportedHash    = errors.New("unsupported checksum algorithm")
+	ErrNotComplete        = errors.New("upload is not complete")
+	ErrAlreadyConsumed    = errors.New("upload was already used")
+	ErrUnsupportedContent = errors.New("only mp4 and mov videos supported")
+)
+
+// Config holds the upload limits
+type Config struct {
+	Dir          string
+	Expiry       time.Duration
+	MaxSize      int64
+	MaxChunkSize int64
+}
+
+// Service handles resumable recording uploads
+type Service struct {
+	config Config
+}
+
+// NewService creates a new upload service
+func NewService() *Service {
+	return &Service{config: loadConfig()}
+}
+
+// loadConfig reads the upload limits from the environment
+func loadConfig() Config {
+	config := Config{
+		Dir:          getEnv("UPLOAD_DIR", "/tmp/nowink-videos/uploads"),
+		Expiry:       24 * time.Hour,
+		MaxSize:      1 << 30,
+		MaxChunkSize: 4 << 20,
+	}
+
+	if expiry, err := time.ParseDuration(os.Getenv("UPLOAD_EXPIRY")); err == nil && expiry > 0 {
+		config.Expiry = expiry
+	}
+	if size, err := strconv.ParseInt(os.Getenv("UPLOAD_MAX_SIZE"), 10, 64); err == nil && size > 0 {
+		config.MaxSize = size
+	}
+	if size, err := strconv.ParseInt(os.Getenv("UPLOAD_MAX_CHUNK_SIZE"), 10, 64); err == nil && size > 0 {
+		config.MaxChunkSize = size
+	}
+
+	return config
+}
+
+// Config returns the upload limits
+func (s *Service) Config() Config {
+	return s.config
+}
+
+// Upload is a resumable upload of a stream's recording
+type Upload struct {
+	ID          uuid.UUID  `json:"id"`
+	StreamID    uuid.UUID  `json:"stream_id"`
+	UserID      uuid.UUID  `json:"user_id"`
+	Size        int64      `json:"size"`
+	Offset      int64      `json:"offset"`
+	ContentType string     `json:"content_type"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	FilePath    string     `json:"-"`
+}
+
+// Create starts an upload of size bytes for a stream. The caller must have
+// checked that userID owns the stream.
+func (s *Service) Create(ctx context.Context, streamID, userID string, size int64, contentType string) (*Upload, error) {
+	if contentType != "video/mp4" && contentType != "video/quicktime" {
+		return nil, ErrUnsupportedContent
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("%w: size must be positive", ErrInvalidUpload)
+	}
+	if size > s.config.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	streamUUID, err := uuid.Parse(streamID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid stream_id", ErrInvalidUpload)
+	}
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid user_id", ErrInvalidUpload)
+	}
+
+	if err := os.MkdirAll(s.config.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	now := time.Now()
+	upload := &Upload{
+		ID:          uuid.New(),
+		StreamID:    streamUUID,
+		UserID:      userUUID,
+		Size:        size,
+		ContentType: contentType,
+		Status:      StatusUploading,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.config.Expiry),
+	}
+	upload.FilePath = filepath.Join(s.config.Dir, upload.ID.String()+".part")
+
+	file, err := os.OpenFile(upload.FilePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	file.Close()
+
+	query := `
+		INSERT INTO stream_uploads (id, stream_id, user_id, size, content_type, file_path, status, created_at, updated_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8, $9)
+	`
+
+	_, err = db.DB.ExecContext(ctx, query,
+		upload.ID,
+		upload.StreamID,
+		upload.UserID,
+		upload.Size,
+		upload.ContentType,
+		upload.FilePath,
+		upload.Status,
+		upload.CreatedAt,
+		upload.ExpiresAt,
+	)
+	if err != nil {
+		os.Remove(upload.FilePath)
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+
+	return upload, nil
+}
+
+// Get returns one of a user's uploads for a stream
+func (s *Service) Get(ctx context.Context, uploadID, streamID, userID string) (*Upload, error) {
+	id, err := uuid.Parse(uploadID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	upload, err := scanUpload(db.DB.QueryRowContext(ctx, selectUpload+` WHERE id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkUpload(upload, streamID, userID); err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// WriteChunk appends data at offset. The offset must equal the stored
+// offset, so a client that lost a response asks for the offset and resumes
+// from there. checksum, if set, is "<algorithm> <base64 digest>" as in the
+// tus checksum extension (sha256 or sha1).
+func (s *Service) WriteChunk(ctx context.Context, uploadID, streamID, userID string, offset int64, data []byte, checksum string) (*Upload, error) {
+	id, err := uuid.Parse(uploadID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	if int64(len(data)) > s.config.MaxChunkSize {
+		return nil, ErrChunkTooLarge
+	}
+
+	if checksum != "" {
+		if err := verifyChecksum(data, checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so concurrent chunks for one upload are written in turn
+	upload, err := scanUpload(tx.QueryRowContext(ctx, selectUpload+` WHERE id = $1 FOR UPDATE`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkUpload(upload, streamID, userID); err != nil {
+		return nil, err
+	}
+	if upload.Status != StatusUploading || offset != upload.Offset {
+		return nil, ErrOffsetMismatch
+	}
+	if offset+int64(len(data)) > upload.Size {
+		return nil, ErrTooLarge
+	}
+
+	if err := writeAt(upload.FilePath, offset, data); err != nil {
+		return nil, err
+	}
+
+	upload.Offset += int64(len(data))
+	var completedAt sql.NullTime
+	if upload.Offset == upload.Size {
+		upload.Status = StatusCompleted
+		now := time.Now()
+		upload.CompletedAt = &now
+		completedAt = sql.NullTime{Time: now, Valid: true}
+	}
+
+	query := `
+		UPDATE stream_uploads
+		SET upload_offset = $1, status = $2, completed_at = $3, updated_at = NOW()
+		WHERE id = $4
+	`
+	if _, err := tx.ExecContext(ctx, query, upload.Offset, upload.Status, completedAt, upload.ID); err != nil {
+		return nil, fmt.Errorf("failed to update upload: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// CompletedFile returns the file of a finished upload so it can be saved as
+// the stream's recording
+func (s *Service) CompletedFile(ctx context.Context, uploadID, streamID, userID string) (*Upload, error) {
+	upload, err := s.Get(ctx, uploadID, streamID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch upload.Status {
+	case StatusConsumed:
+		return nil, ErrAlreadyConsumed
+	case StatusUploading:
+		return nil, ErrNotComplete
+	}
+
+	return upload, nil
+}
+
+// MarkConsumed records that an upload's file was handed to the mint path.
+// Consumed uploads don't expire while the mint job needs the file; a job
+// that fails hands the upload back as completed, so it can be saved again.
+func (s *Service) MarkConsumed(ctx context.Context, uploadID uuid.UUID) error {
+	query := `UPDATE stream_uploads SET status = $1, updated_at = NOW() WHERE id = $2 AND status = $3`
+
+	result, err := db.DB.ExecContext(ctx, query, StatusConsumed, uploadID, StatusCompleted)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrAlreadyConsumed
+	}
+
+	return nil
+}
+
+// CleanExpired deletes expired uploads that were never used, and their
+// files. A consumed upload is deleted once its mint job is minted, since the
+// video is stored on Arweave by then, or once it expired without a job that
+// still uses it.
+func (s *Service) CleanExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM stream_uploads u
+		WHERE (u.status <> $1 AND u.expires_at < NOW())
+		   OR (u.status = $1 AND EXISTS (
+		           SELECT 1 FROM mint_jobs j
+		           WHERE j.request->>'video_url' = u.file_path AND j.status = 'minted'
+		       ))
+		   OR (u.status = $1 AND u.expires_at < NOW() AND NOT EXISTS (
+		           SELECT 1 FROM mint_jobs j
+		           WHERE j.request->>'video_url' = u.file_path AND j.status <> 'failed'
+		       ))
+		RETURNING u.file_path
+	`
+
+	rows, err := db.DB.QueryContext(ctx, query, StatusConsumed)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var removed int64
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return removed, err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, rows.Err()
+}
+
+const selectUpload = `
+	SELECT id, stream_id, user_id, size, upload_offset, content_type, file_path, status, created_at, expires_at, completed_at
+	FROM stream_uploads`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUpload(row rowScanner) (*Upload, error) {
+	upload := &Upload{}
+	var completedAt sql.NullTime
+
+	err := row.Scan(
+		&upload.ID,
+		&upload.StreamID,
+		&upload.UserID,
+		&upload.Size,
+		&upload.Offset,
+		&upload.ContentType,
+		&upload.FilePath,
+		&upload.Status,
+		&upload.CreatedAt,
+		&upload.ExpiresAt,
+		&completedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if completedAt.Valid {
+		upload.CompletedAt = &completedAt.Time
+	}
+
+	return upload, nil
+}
+
+// checkUpload hides other users' uploads and rejects expired ones
+func checkUpload(upload *Upload, streamID, userID string) error {
+	if upload.StreamID.String() != streamID || upload.UserID.String() != userID {
+		return ErrNotFound
+	}
+	if upload.Status != StatusConsumed && time.Now().After(upload.ExpiresAt) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// verifyChecksum checks data against a tus Upload-Checksum value
+func verifyChecksum(data []byte, checksum string) error {
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(checksum), " ")
+	if !ok {
+		return fmt.Errorf("%w: checksum must be \"<algorithm> <base64 digest>\"", ErrInvalidUpload)
+	}
+
+	var h hash.Hash
+	switch strings.ToLower(algorithm) {
+	case "sha256":
+		h = sha256.New()
+	case "sha1":
+		h = sha1.New()
+	default:
+		return ErrUnsupportedHash
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: checksum is not base64", ErrInvalidUpload)
+	}
+
+	h.Write(data)
+	if subtle.ConstantTimeCompare(h.Sum(nil), expected) != 1 {
+		return ErrChecksumMismatch
+	}
+
+	return nil
+}
+
+// writeAt writes a chunk at offset, dropping anything past it first. Bytes
+// beyond the stored offset are left over from a chunk whose offset update
+// never committed.
+func writeAt(path string, offset int64, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer file.Close()
+
+	if err := file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+	if _, err := file.WriteAt(data, offset); err != nil {
+		return fmt.Errorf("failed to write chunk: %w", err)
+	}
+
+	return file.Sync()
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,245 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/alexcolls/now.ink/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// apiKeyPrefix marks now.ink API keys so they are easy to spot in leaks
+const apiKeyPrefix = "nowink_"
+
+// API key errors
+var (
+	ErrInvalidAPIKey   = errors.New("invalid, expired or revoked API key")
+	ErrAPIKeyNotFound  = errors.New("API key not found")
+	ErrScopeNotAllowed = errors.New("scope not allowed for this account")
+)
+
+// APIKeyOwner is an authenticated API key together with the account it acts for
+type APIKeyOwner struct {
+	Key           *models.APIKey
+	WalletAddress string
+	Role          string
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey creates a named key for a user and returns the plaintext key.
+// Scopes may only be a subset of what the user's role grants.
+func (s *Service) CreateAPIKey(ctx context.Context, account *models.User, req *models.CreateAPIKeyRequest) (string, *models.APIKey, error) {
+	allowed := models.DefaultScopes(account.Role)
+	for _, scope := range req.Scopes {
+		if !slices.Contains(allowed, scope) {
+			return "", nil, fmt.Errorf("%w: %s", ErrScopeNotAllowed, scope)
+		}
+	}
+
+	prefixBytes := make([]byte, 6)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", nil, err
+	}
+
+	prefix := apiKeyPrefix + hex.EncodeToString(prefixBytes)[:8]
+	key := prefix + "_" + base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	apiKey := &models.APIKey{
+		ID:                 uuid.New(),
+		UserID:             account.ID,
+		Name:               req.Name,
+		Prefix:             prefix,
+		Scopes:             req.Scopes,
+		RateLimitPerMinute: 600,
+		CreatedAt:          time.Now().UTC(),
+		ExpiresAt:          req.ExpiresAt,
+	}
+	if apiKey.Scopes == nil {
+		apiKey.Scopes = []string{}
+	}
+
+	query := `
+		INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, rate_limit_per_minute, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	_, err := db.DB.ExecContext(ctx, query,
+		apiKey.ID,
+		apiKey.UserID,
+		apiKey.Name,
+		apiKey.Prefix,
+		hashAPIKey(key),
+		pq.Array(apiKey.Scopes),
+		apiKey.RateLimitPerMinute,
+		apiKey.CreatedAt,
+		apiKey.ExpiresAt,
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to store API key: %w", err)
+	}
+
+	return key, apiKey, nil
+}
+
+// ListAPIKeys lists a user's API keys, including revoked ones
+func (s *Service) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]*models.APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, scopes, rate_limit_per_minute, created_at, expires_at, last_used_at, revoked_at
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := db.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes one of a user's API keys
+func (s *Service) RevokeAPIKey(ctx context.Context, userID uuid.UUID, keyID string) error {
+	id, err := uuid.Parse(keyID)
+	if err != nil {
+		return ErrAPIKeyNotFound
+	}
+
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	result, err := db.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// AuthenticateAPIKey looks up an active key and the account it belongs to
+func (s *Service) AuthenticateAPIKey(ctx context.Context, key string) (*APIKeyOwner, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	query := `
+		SELECT k.id, k.user_id, k.name, k.prefix, k.scopes, k.rate_limit_per_minute,
+		       k.created_at, k.expires_at, k.last_used_at, k.revoked_at,
+		       u.wallet_address, u.role
+		FROM api_keys k
+		INNER JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1
+		  AND k.revoked_at IS NULL
+		  AND (k.expires_at IS NULL OR k.expires_at > NOW())
+	`
+
+	owner := &APIKeyOwner{}
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	apiKey := &models.APIKey{}
+
+	err := db.DB.QueryRowContext(ctx, query, hashAPIKey(key)).Scan(
+		&apiKey.ID,
+		&apiKey.UserID,
+		&apiKey.Name,
+		&apiKey.Prefix,
+		pq.Array(&apiKey.Scopes),
+		&apiKey.RateLimitPerMinute,
+		&apiKey.CreatedAt,
+		&expiresAt,
+		&lastUsedAt,
+		&revokedAt,
+		&owner.WalletAddress,
+		&owner.Role,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	if expiresAt.Valid {
+		apiKey.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		apiKey.LastUsedAt = &lastUsedAt.Time
+	}
+
+	// Only write last_used_at about once a minute per key
+	if !lastUsedAt.Valid || time.Since(lastUsedAt.Time) > time.Minute {
+		_, _ = db.DB.ExecContext(ctx, `UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`, apiKey.ID)
+	}
+
+	owner.Key = apiKey
+	return owner, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Scopes),
+		&key.RateLimitPerMinute,
+		&key.CreatedAt,
+		&expiresAt,
+		&lastUsedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+
+	return key, nil
+}
@@ -0,0 +1,111 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/models"
+)
+
+// SIWSVersion is the only Sign-In-With-Solana message version we issue
+const SIWSVersion = "1"
+
+const siwsHeaderSuffix = " wants you to sign in with your Solana account:"
+
+// FormatSIWSMessage renders a Sign-In-With-Solana (CAIP-122) message. The
+// same function is used when issuing the nonce and when verifying the
+// signature, so both sides always agree byte for byte.
+func FormatSIWSMessage(m *models.SIWSMessage) string {
+	var b strings.Builder
+
+	b.WriteString(m.Domain + siwsHeaderSuffix + "\n")
+	b.WriteString(m.Address + "\n")
+	if m.Statement != "" {
+		b.WriteString("\n" + m.Statement + "\n")
+	}
+	b.WriteString("\n")
+	b.WriteString("URI: " + m.URI + "\n")
+	b.WriteString("Version: " + m.Version + "\n")
+	b.WriteString("Chain ID: " + m.ChainID + "\n")
+	b.WriteString("Nonce: " + m.Nonce + "\n")
+	b.WriteString("Issued At: " + m.IssuedAt.UTC().Format(time.RFC3339))
+	if m.ExpirationTime != nil {
+		b.WriteString("\nExpiration Time: " + m.ExpirationTime.UTC().Format(time.RFC3339))
+	}
+	if m.RequestID != "" {
+		b.WriteString("\nRequest ID: " + m.RequestID)
+	}
+
+	return b.String()
+}
+
+// ParseSIWSMessage parses a message produced by FormatSIWSMessage
+func ParseSIWSMessage(text string) (*models.SIWSMessage, error) {
+	lines := strings.Split(text, "\n")
+	if len(lines) < 3 {
+		return nil, errors.New("message too short")
+	}
+
+	m := &models.SIWSMessage{}
+
+	domain, ok := strings.CutSuffix(lines[0], siwsHeaderSuffix)
+	if !ok || domain == "" {
+		return nil, errors.New("missing sign-in header")
+	}
+	m.Domain = domain
+	m.Address = lines[1]
+
+	rest := lines[2:]
+	if len(rest) == 0 || rest[0] != "" {
+		return nil, errors.New("missing blank line after address")
+	}
+	rest = rest[1:]
+
+	// Optional statement, followed by another blank line
+	if len(rest) > 1 && !strings.HasPrefix(rest[0], "URI: ") && rest[1] == "" {
+		m.Statement = rest[0]
+		rest = rest[2:]
+	}
+
+	for _, line := range rest {
+		key, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			return nil, fmt.Errorf("malformed line %q", line)
+		}
+
+		switch key {
+		case "URI":
+			m.URI = value
+		case "Version":
+			m.Version = value
+		case "Chain ID":
+			m.ChainID = value
+		case "Nonce":
+			m.Nonce = value
+		case "Issued At":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid issued at: %w", err)
+			}
+			m.IssuedAt = t
+		case "Expiration Time":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expiration time: %w", err)
+			}
+			m.ExpirationTime = &t
+		case "Request ID":
+			m.RequestID = value
+		default:
+			return nil, fmt.Errorf("unknown field %q", key)
+		}
+	}
+
+	if m.URI == "" || m.Version == "" || m.ChainID == "" || m.Nonce == "" || m.IssuedAt.IsZero() {
+		return nil, errors.New("missing required field")
+	}
+
+	return m, nil
+}
@@ -0,0 +1,131 @@
+package user
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/models"
+)
+
+const (
+	testWallet = "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU"
+	testNonce  = "9f86d081884c7d659a2feaa0c55ad015"
+)
+
+func testLoginService() *Service {
+	return &Service{login: LoginConfig{
+		Domain:    "now.ink",
+		URI:       "https://now.ink",
+		Statement: "Sign in to now.ink.",
+		ChainID:   "devnet",
+		NonceTTL:  5 * time.Minute,
+	}}
+}
+
+// testMessage is a message as GenerateNonce issues it at issuedAt
+func testMessage(s *Service, issuedAt time.Time) *models.SIWSMessage {
+	expires := issuedAt.Add(s.login.NonceTTL)
+	return &models.SIWSMessage{
+		Domain:         s.login.Domain,
+		Address:        testWallet,
+		Statement:      s.login.Statement,
+		URI:            s.login.URI,
+		Version:        SIWSVersion,
+		ChainID:        s.login.ChainID,
+		Nonce:          testNonce,
+		IssuedAt:       issuedAt,
+		ExpirationTime: &expires,
+	}
+}
+
+func TestSIWSMessageRoundTrip(t *testing.T) {
+	issuedAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	full := testMessage(testLoginService(), issuedAt)
+	full.RequestID = "req-1"
+
+	minimal := testMessage(testLoginService(), issuedAt)
+	minimal.Statement = ""
+	minimal.ExpirationTime = nil
+
+	for name, m := range map[string]*models.SIWSMessage{"full": full, "minimal": minimal} {
+		text := FormatSIWSMessage(m)
+		parsed, err := ParseSIWSMessage(text)
+		if err != nil {
+			t.Fatalf("%s: parse: %v", name, err)
+		}
+		if !reflect.DeepEqual(parsed, m) {
+			t.Errorf("%s: round trip = %+v, want %+v", name, parsed, m)
+		}
+		if again := FormatSIWSMessage(parsed); again != text {
+			t.Errorf("%s: reformatted message differs:\n%s\nwant:\n%s", name, again, text)
+		}
+	}
+}
+
+func TestParseSIWSMessageRejects(t *testing.T) {
+	text := FormatSIWSMessage(testMessage(testLoginService(), time.Now().UTC().Truncate(time.Second)))
+
+	cases := map[string]string{
+		"no header":     strings.Replace(text, siwsHeaderSuffix, "", 1),
+		"no nonce":      strings.Replace(text, "Nonce: "+testNonce+"\n", "", 1),
+		"unknown field": text + "\nResources: none",
+		"bad time":      strings.Replace(text, "Issued At: ", "Issued At: yesterday ", 1),
+		"too short":     "now.ink" + siwsHeaderSuffix,
+	}
+	for name, bad := range cases {
+		if _, err := ParseSIWSMessage(bad); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
+
+func TestCheckMessage(t *testing.T) {
+	s := testLoginService()
+	issuedAt := time.Now().UTC().Truncate(time.Second)
+
+	check := func(m *models.SIWSMessage, now time.Time) error {
+		parsed, err := ParseSIWSMessage(FormatSIWSMessage(m))
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		return s.checkMessage(parsed, testWallet, s.login.Statement, now)
+	}
+
+	valid := testMessage(s, issuedAt)
+	if err := check(valid, issuedAt.Add(time.Minute)); err != nil {
+		t.Errorf("valid message rejected: %v", err)
+	}
+
+	// The nonce survives the round trip, so verifyChallenge consumes the
+	// one that was issued
+	parsed, _ := ParseSIWSMessage(FormatSIWSMessage(valid))
+	if parsed.Nonce != valid.Nonce {
+		t.Errorf("nonce = %q, want %q", parsed.Nonce, valid.Nonce)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(m *models.SIWSMessage)
+		now    time.Time
+		want   string
+	}{
+		{"other domain", func(m *models.SIWSMessage) { m.Domain = "evil.example" }, issuedAt, "domain mismatch"},
+		{"other wallet", func(m *models.SIWSMessage) { m.Address = "11111111111111111111111111111111" }, issuedAt, "address mismatch"},
+		{"other statement", func(m *models.SIWSMessage) { m.Statement = "Send all funds" }, issuedAt, "statement mismatch"},
+		{"other uri", func(m *models.SIWSMessage) { m.URI = "https://evil.example" }, issuedAt, "uri mismatch"},
+		{"other version", func(m *models.SIWSMessage) { m.Version = "2" }, issuedAt, "unsupported version"},
+		{"other chain", func(m *models.SIWSMessage) { m.ChainID = "mainnet-beta" }, issuedAt, "chain id mismatch"},
+		{"issued in the future", func(m *models.SIWSMessage) {}, issuedAt.Add(-2 * maxClockSkew), "issued in the future"},
+		{"expired", func(m *models.SIWSMessage) {}, issuedAt.Add(s.login.NonceTTL + time.Second), "message expired"},
+	}
+	for _, tt := range tests {
+		m := testMessage(s, issuedAt)
+		tt.modify(m)
+		err := check(m, tt.now)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
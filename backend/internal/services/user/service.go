@@ -1,23 +1,85 @@
 package user
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"os"
 	"time"
 
+	"github.com/alexcolls/now.ink/backend/internal/blockchain"
 	"github.com/alexcolls/now.ink/backend/internal/db"
 	"github.com/alexcolls/now.ink/backend/internal/models"
 	"github.com/google/uuid"
 )
 
+// Login errors
+var (
+	ErrInvalidNonce     = errors.New("invalid or expired nonce")
+	ErrInvalidMessage   = errors.New("invalid sign-in message")
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// User lookup errors
+var (
+	ErrInvalidUserID = errors.New("invalid user ID")
+	ErrUserNotFound  = errors.New("user not found")
+)
+
+// maxClockSkew is how far in the future a message's issued-at may be
+const maxClockSkew = time.Minute
+
+// Nonce purposes, so a signature issued for one flow can't be used in another
+const (
+	noncePurposeLogin = "login"
+	noncePurposeLink  = "link"
+)
+
+// LoginConfig describes what a Sign-In-With-Solana message must contain to
+// be accepted by this server
+type LoginConfig struct {
+	Domain    string
+	URI       string
+	Statement string
+	ChainID   string
+	NonceTTL  time.Duration
+}
+
 // Service handles user-related operations
-type Service struct{}
+type Service struct {
+	login LoginConfig
+}
 
 // NewService creates a new user service
 func NewService() *Service {
-	return &Service{}
+	return &Service{login: loadLoginConfig()}
+}
+
+func loadLoginConfig() LoginConfig {
+	domain := getEnv("AUTH_DOMAIN", "now.ink")
+
+	ttl, err := time.ParseDuration(getEnv("AUTH_NONCE_TTL", "5m"))
+	if err != nil {
+		ttl = 5 * time.Minute
+	}
+
+	return LoginConfig{
+		Domain:    domain,
+		URI:       getEnv("AUTH_URI", "https://"+domain),
+		Statement: getEnv("AUTH_STATEMENT", "Sign in to now.ink. This request will not trigger a blockchain transaction or cost any fees."),
+		ChainID:   getEnv("SOLANA_NETWORK", "devnet"),
+		NonceTTL:  ttl,
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
 }
 
 // GetOrCreateUser gets an existing user or creates a new one
@@ -31,7 +93,7 @@ func (s *Service) GetOrCreateUser(walletAddress string) (*models.User, error) {
 		return nil, err
 	}
 
-	// Create new user
+	// Create new user with this wallet as its primary wallet
 	newUser := &models.User{
 		ID:            uuid.New(),
 		WalletAddress: walletAddress,
@@ -40,13 +102,19 @@ func (s *Service) GetOrCreateUser(walletAddress string) (*models.User, error) {
 		UpdatedAt:     time.Now(),
 	}
 
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	query := `
 		INSERT INTO users (id, wallet_address, is_premium, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, wallet_address, username, bio, avatar_url, is_premium, created_at, updated_at
+		RETURNING id, wallet_address, username, bio, avatar_url, is_premium, role, created_at, updated_at
 	`
 
-	err = db.DB.QueryRow(
+	err = tx.QueryRow(
 		query,
 		newUser.ID,
 		newUser.WalletAddress,
@@ -60,20 +128,39 @@ func (s *Service) GetOrCreateUser(walletAddress string) (*models.User, error) {
 		&newUser.Bio,
 		&newUser.AvatarURL,
 		&newUser.IsPremium,
+		&newUser.Role,
 		&newUser.CreatedAt,
 		&newUser.UpdatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	walletQuery := `
+		INSERT INTO user_wallets (wallet_address, user_id, is_primary, created_at)
+		VALUES ($1, $2, TRUE, $3)
+	`
 
-	return newUser, err
+	if _, err := tx.Exec(walletQuery, walletAddress, newUser.ID, newUser.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return newUser, nil
 }
 
-// GetUserByWallet retrieves a user by wallet address
+// GetUserByWallet retrieves a user by any of their linked wallet addresses.
+// The returned WalletAddress is always the user's primary wallet.
 func (s *Service) GetUserByWallet(walletAddress string) (*models.User, error) {
 	user := &models.User{}
 	query := `
-		SELECT id, wallet_address, username, bio, avatar_url, is_premium, created_at, updated_at
-		FROM users
-		WHERE wallet_address = $1
+		SELECT u.id, u.wallet_address, u.username, u.bio, u.avatar_url, u.is_premium, u.role, u.created_at, u.updated_at
+		FROM users u
+		INNER JOIN user_wallets w ON w.user_id = u.id
+		WHERE w.wallet_address = $1
 	`
 
 	err := db.DB.QueryRow(query, walletAddress).Scan(
@@ -83,6 +170,35 @@ func (s *Service) GetUserByWallet(walletAddress string) (*models.User, error) {
 		&user.Bio,
 		&user.AvatarURL,
 		&user.IsPremium,
+		&user.Role,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetUserByID retrieves a user by ID
+func (s *Service) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	user := &models.User{}
+	query := `
+		SELECT id, wallet_address, username, bio, avatar_url, is_premium, role, created_at, updated_at
+		FROM users
+		WHERE id = $1
+	`
+
+	err := db.DB.QueryRowContext(ctx, query, userID).Scan(
+		&user.ID,
+		&user.WalletAddress,
+		&user.Username,
+		&user.Bio,
+		&user.AvatarURL,
+		&user.IsPremium,
+		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -94,53 +210,174 @@ func (s *Service) GetUserByWallet(walletAddress string) (*models.User, error) {
 	return user, nil
 }
 
-// GenerateNonce creates a new authentication nonce
-func (s *Service) GenerateNonce(walletAddress string) (string, error) {
+// SetUserRole changes a user's role. It takes effect on the user's next
+// token refresh.
+func (s *Service) SetUserRole(ctx context.Context, userID, role string) error {
+	if !models.IsValidRole(role) {
+		return fmt.Errorf("invalid role: %s", role)
+	}
+
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return ErrInvalidUserID
+	}
+
+	result, err := db.DB.ExecContext(ctx, `UPDATE users SET role = $1 WHERE id = $2`, role, uid)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// GenerateNonce creates a new authentication nonce and the
+// Sign-In-With-Solana message the wallet has to sign for it
+func (s *Service) GenerateNonce(walletAddress string) (*models.Session, *models.SIWSMessage, error) {
+	return s.issueChallenge(walletAddress, noncePurposeLogin, s.login.Statement)
+}
+
+// VerifyLogin consumes a login nonce and checks that the wallet signed the
+// exact message issued for it. The signed message may be sent back by the
+// client or, when omitted, is taken from the stored session. The nonce is
+// single use even when verification fails.
+func (s *Service) VerifyLogin(walletAddress, nonce, message, signature string) error {
+	return s.verifyChallenge(walletAddress, nonce, message, signature, noncePurposeLogin, s.login.Statement)
+}
+
+// issueChallenge stores a nonce for purpose and builds the message to sign
+func (s *Service) issueChallenge(walletAddress, purpose, statement string) (*models.Session, *models.SIWSMessage, error) {
 	// Generate random nonce
 	nonceBytes := make([]byte, 32)
 	if _, err := rand.Read(nonceBytes); err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	nonce := hex.EncodeToString(nonceBytes)
 
-	// Store in sessions table
-	sessionID := uuid.New()
-	expiresAt := time.Now().Add(5 * time.Minute) // 5 minute expiry
+	// Timestamps are part of the signed message, so keep them at second precision
+	now := time.Now().UTC().Truncate(time.Second)
+	expiresAt := now.Add(s.login.NonceTTL)
+
+	message := &models.SIWSMessage{
+		Domain:         s.login.Domain,
+		Address:        walletAddress,
+		Statement:      statement,
+		URI:            s.login.URI,
+		Version:        SIWSVersion,
+		ChainID:        s.login.ChainID,
+		Nonce:          hex.EncodeToString(nonceBytes),
+		IssuedAt:       now,
+		ExpirationTime: &expiresAt,
+		RequestID:      uuid.New().String(),
+	}
 
+	session := &models.Session{
+		ID:            uuid.New(),
+		WalletAddress: walletAddress,
+		Nonce:         message.Nonce,
+		Message:       FormatSIWSMessage(message),
+		CreatedAt:     now,
+		ExpiresAt:     expiresAt,
+	}
+
+	// Store in sessions table
 	query := `
-		INSERT INTO sessions (id, wallet_address, nonce, created_at, expires_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO sessions (id, wallet_address, nonce, message, purpose, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`
 
-	_, err := db.DB.Exec(query, sessionID, walletAddress, nonce, time.Now(), expiresAt)
+	_, err := db.DB.Exec(query, session.ID, session.WalletAddress, session.Nonce, session.Message, purpose, session.CreatedAt, session.ExpiresAt)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 
-	return nonce, nil
+	return session, message, nil
 }
 
-// ValidateNonce checks if a nonce is valid for a wallet
-func (s *Service) ValidateNonce(walletAddress, nonce string) (bool, error) {
+// verifyChallenge consumes a nonce issued for purpose and verifies the
+// wallet's signature over its message
+func (s *Service) verifyChallenge(walletAddress, nonce, message, signature, purpose, statement string) error {
+	if message != "" {
+		parsed, err := ParseSIWSMessage(message)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+		}
+		if nonce != "" && nonce != parsed.Nonce {
+			return fmt.Errorf("%w: nonce mismatch", ErrInvalidMessage)
+		}
+		nonce = parsed.Nonce
+	}
+
 	query := `
-		SELECT id FROM sessions
-		WHERE wallet_address = $1 AND nonce = $2 AND expires_at > NOW()
+		DELETE FROM sessions
+		WHERE wallet_address = $1 AND nonce = $2 AND purpose = $3 AND expires_at > NOW()
+		RETURNING message
 	`
 
-	var sessionID uuid.UUID
-	err := db.DB.QueryRow(query, walletAddress, nonce).Scan(&sessionID)
+	var stored sql.NullString
+	err := db.DB.QueryRow(query, walletAddress, nonce, purpose).Scan(&stored)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return false, nil
+			return ErrInvalidNonce
 		}
-		return false, err
+		return err
 	}
 
-	// Delete used nonce
-	deleteQuery := `DELETE FROM sessions WHERE id = $1`
-	_, _ = db.DB.Exec(deleteQuery, sessionID)
+	if !stored.Valid {
+		return fmt.Errorf("%w: nonce was issued without a message", ErrInvalidMessage)
+	}
+	if message == "" {
+		message = stored.String
+	}
+	if message != stored.String {
+		return fmt.Errorf("%w: message does not match the issued message", ErrInvalidMessage)
+	}
 
-	return true, nil
+	parsed, err := ParseSIWSMessage(message)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	if err := s.checkMessage(parsed, walletAddress, statement, time.Now()); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+
+	sig, err := blockchain.DecodeSignature(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	valid, err := blockchain.VerifyWalletSignature(walletAddress, []byte(message), sig)
+	if err != nil || !valid {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// checkMessage validates each field of a parsed message against the server config
+func (s *Service) checkMessage(m *models.SIWSMessage, walletAddress, statement string, now time.Time) error {
+	switch {
+	case m.Domain != s.login.Domain:
+		return errors.New("domain mismatch")
+	case m.Address != walletAddress:
+		return errors.New("address mismatch")
+	case m.Statement != statement:
+		return errors.New("statement mismatch")
+	case m.URI != s.login.URI:
+		return errors.New("uri mismatch")
+	case m.Version != SIWSVersion:
+		return errors.New("unsupported version")
+	case m.ChainID != s.login.ChainID:
+		return errors.New("chain id mismatch")
+	case m.IssuedAt.After(now.Add(maxClockSkew)):
+		return errors.New("issued in the future")
+	case m.ExpirationTime != nil && now.After(*m.ExpirationTime):
+		return errors.New("message expired")
+	}
+	return nil
 }
 
 // CleanExpiredSessions removes expired sessions
@@ -0,0 +1,160 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/alexcolls/now.ink/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// ErrSessionNotFound is returned when a session doesn't exist or belongs to
+// another user
+var ErrSessionNotFound = errors.New("session not found")
+
+// DeviceInfo describes the device a login was made from
+type DeviceInfo struct {
+	Name      string
+	UserAgent string
+	IPAddress string
+}
+
+// maxDeviceNameLength bounds the client-supplied device name
+const maxDeviceNameLength = 100
+
+// insertAuthSession records the session for a new token family
+func insertAuthSession(ctx context.Context, conn execer, record *models.RefreshToken, device DeviceInfo) error {
+	name := device.Name
+	if len(name) > maxDeviceNameLength {
+		name = name[:maxDeviceNameLength]
+	}
+
+	query := `
+		INSERT INTO auth_sessions (id, user_id, wallet_address, device_name, user_agent, ip_address, created_at, last_seen_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NOW(), NOW())
+	`
+
+	_, err := conn.ExecContext(ctx, query,
+		record.FamilyID,
+		record.UserID,
+		record.WalletAddress,
+		name,
+		device.UserAgent,
+		device.IPAddress,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+
+	return nil
+}
+
+// ListSessions lists a user's active sessions, most recently used first
+func (s *Service) ListSessions(ctx context.Context, userID uuid.UUID) ([]*models.AuthSession, error) {
+	query := `
+		SELECT id, user_id, wallet_address, device_name, user_agent, ip_address, created_at, last_seen_at, revoked_at
+		FROM auth_sessions
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY last_seen_at DESC
+	`
+
+	rows, err := db.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*models.AuthSession{}
+	for rows.Next() {
+		session := &models.AuthSession{}
+		var deviceName, userAgent, ipAddress sql.NullString
+		var revokedAt sql.NullTime
+
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.WalletAddress,
+			&deviceName,
+			&userAgent,
+			&ipAddress,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&revokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		session.DeviceName = deviceName.String
+		session.UserAgent = userAgent.String
+		session.IPAddress = ipAddress.String
+		if revokedAt.Valid {
+			session.RevokedAt = &revokedAt.Time
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// RevokeSession logs one of a user's sessions out
+func (s *Service) RevokeSession(ctx context.Context, userID uuid.UUID, sessionID string) error {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM auth_sessions WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL)`,
+		id, userID,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrSessionNotFound
+	}
+
+	if err := revokeFamily(ctx, tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TouchSession reports whether the session behind an access token was
+// revoked and, for live sessions, bumps last_seen_at. The write only happens
+// when the stored value is over a minute old, so most requests cost a single
+// indexed read.
+func (s *Service) TouchSession(ctx context.Context, sessionID string) (bool, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return true, nil
+	}
+
+	query := `
+		WITH touched AS (
+			UPDATE auth_sessions SET last_seen_at = NOW()
+			WHERE id = $1 AND revoked_at IS NULL AND last_seen_at < NOW() - INTERVAL '1 minute'
+		)
+		SELECT revoked_at IS NOT NULL FROM auth_sessions WHERE id = $1
+	`
+
+	var revoked bool
+	err = db.DB.QueryRowContext(ctx, query, id).Scan(&revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	return revoked, err
+}
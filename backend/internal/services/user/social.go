@@ -11,12 +11,14 @@ import (
 	"github.com/google/uuid"
 )
 
-// UserProfile represents a user profile with stats
+// UserProfile represents a user profile with stats combined across all
+// linked wallets
 type UserProfile struct {
 	models.User
-	FollowersCount int `json:"followers_count"`
-	FollowingCount int `json:"following_count"`
-	NFTCount       int `json:"nft_count"`
+	Wallets        []*models.UserWallet `json:"wallets"`
+	FollowersCount int                  `json:"followers_count"`
+	FollowingCount int                  `json:"following_count"`
+	NFTCount       int                  `json:"nft_count"`
 }
 
 // FollowUser creates a follow relationship
@@ -70,7 +72,7 @@ func (s *Service) GetFollowers(ctx context.Context, userID string, limit, offset
 	query := `
 		SELECT 
 			u.id, u.wallet_address, u.username, u.bio, u.avatar_url,
-			u.is_premium, u.created_at, u.updated_at
+			u.is_premium, u.role, u.created_at, u.updated_at
 		FROM users u
 		INNER JOIN follows f ON u.id = f.follower_id
 		WHERE f.following_id = $1
@@ -94,6 +96,7 @@ func (s *Service) GetFollowers(ctx context.Context, userID string, limit, offset
 			&user.Bio,
 			&user.AvatarURL,
 			&user.IsPremium,
+			&user.Role,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -115,7 +118,7 @@ func (s *Service) GetFollowing(ctx context.Context, userID string, limit, offset
 	query := `
 		SELECT 
 			u.id, u.wallet_address, u.username, u.bio, u.avatar_url,
-			u.is_premium, u.created_at, u.updated_at
+			u.is_premium, u.role, u.created_at, u.updated_at
 		FROM users u
 		INNER JOIN follows f ON u.id = f.following_id
 		WHERE f.follower_id = $1
@@ -139,6 +142,7 @@ func (s *Service) GetFollowing(ctx context.Context, userID string, limit, offset
 			&user.Bio,
 			&user.AvatarURL,
 			&user.IsPremium,
+			&user.Role,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -160,11 +164,14 @@ func (s *Service) SearchUsers(ctx context.Context, query string, limit, offset i
 	searchQuery := `
 		SELECT 
 			id, wallet_address, username, bio, avatar_url,
-			is_premium, created_at, updated_at
+			is_premium, role, created_at, updated_at
 		FROM users
 		WHERE 
 			username ILIKE '%' || $1 || '%' OR
-			wallet_address ILIKE '%' || $1 || '%'
+			EXISTS (
+				SELECT 1 FROM user_wallets w
+				WHERE w.user_id = users.id AND w.wallet_address ILIKE '%' || $1 || '%'
+			)
 		ORDER BY 
 			CASE 
 				WHEN username = $1 THEN 0
@@ -192,6 +199,7 @@ func (s *Service) SearchUsers(ctx context.Context, query string, limit, offset i
 			&user.Bio,
 			&user.AvatarURL,
 			&user.IsPremium,
+			&user.Role,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -213,14 +221,14 @@ func (s *Service) GetUserProfile(ctx context.Context, userID string) (*UserProfi
 	// Parse UUID
 	uid, err := uuid.Parse(userID)
 	if err != nil {
-		return nil, errors.New("invalid user ID")
+		return nil, ErrInvalidUserID
 	}
 
 	// Get user basic info
 	user := &models.User{}
 	userQuery := `
 		SELECT id, wallet_address, username, bio, avatar_url,
-			   is_premium, created_at, updated_at
+			   is_premium, role, created_at, updated_at
 		FROM users
 		WHERE id = $1
 	`
@@ -232,12 +240,13 @@ func (s *Service) GetUserProfile(ctx context.Context, userID string) (*UserProfi
 		&user.Bio,
 		&user.AvatarURL,
 		&user.IsPremium,
+		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -247,14 +256,16 @@ func (s *Service) GetUserProfile(ctx context.Context, userID string) (*UserProfi
 		SELECT 
 			(SELECT COUNT(*) FROM follows WHERE following_id = $1) as followers_count,
 			(SELECT COUNT(*) FROM follows WHERE follower_id = $1) as following_count,
-			(SELECT COUNT(*) FROM nfts WHERE owner_wallet = $2) as nft_count
+			(SELECT COUNT(*) FROM nfts n
+			 INNER JOIN user_wallets w ON n.creator_wallet = w.wallet_address
+			 WHERE w.user_id = $1 AND n.confirmation_status NOT IN ('failed', 'expired')) as nft_count
 	`
 
 	profile := &UserProfile{
 		User: *user,
 	}
 
-	err = db.DB.QueryRowContext(ctx, statsQuery, uid, user.WalletAddress).Scan(
+	err = db.DB.QueryRowContext(ctx, statsQuery, uid).Scan(
 		&profile.FollowersCount,
 		&profile.FollowingCount,
 		&profile.NFTCount,
@@ -263,5 +274,10 @@ func (s *Service) GetUserProfile(ctx context.Context, userID string) (*UserProfi
 		return nil, err
 	}
 
+	profile.Wallets, err = s.ListWallets(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
 	return profile, nil
 }
@@ -0,0 +1,251 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/alexcolls/now.ink/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// Refresh token errors
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// refreshTokenExpiry returns how long a refresh token stays valid
+func refreshTokenExpiry() time.Duration {
+	expiry, err := time.ParseDuration(getEnv("JWT_REFRESH_EXPIRY", "720h"))
+	if err != nil {
+		return 30 * 24 * time.Hour
+	}
+	return expiry
+}
+
+// hashRefreshToken returns the hex SHA-256 digest stored instead of the token
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateRefreshToken starts a new token family for a fresh login, recording
+// the device it was made from as a session, and returns the plaintext refresh
+// token. Only its hash is stored.
+func (s *Service) CreateRefreshToken(ctx context.Context, user *models.User, walletAddress string, device DeviceInfo) (string, *models.RefreshToken, error) {
+	record := &models.RefreshToken{
+		ID:            uuid.New(),
+		FamilyID:      uuid.New(),
+		UserID:        user.ID,
+		WalletAddress: walletAddress,
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	if err := insertAuthSession(ctx, tx, record, device); err != nil {
+		return "", nil, err
+	}
+
+	token, err := insertRefreshToken(ctx, tx, record)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", nil, err
+	}
+
+	return token, record, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family. Presenting a token that was already rotated means it leaked, so the
+// whole family is revoked and ErrRefreshTokenReused is returned.
+func (s *Service) RotateRefreshToken(ctx context.Context, token string) (string, *models.RefreshToken, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, family_id, user_id, wallet_address, expires_at, rotated_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`
+
+	current := &models.RefreshToken{}
+	var rotatedAt, revokedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, query, hashRefreshToken(token)).Scan(
+		&current.ID,
+		&current.FamilyID,
+		&current.UserID,
+		&current.WalletAddress,
+		&current.ExpiresAt,
+		&rotatedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil, ErrInvalidRefreshToken
+		}
+		return "", nil, err
+	}
+
+	if revokedAt.Valid {
+		return "", nil, ErrInvalidRefreshToken
+	}
+
+	if rotatedAt.Valid {
+		if err := revokeFamily(ctx, tx, current.FamilyID); err != nil {
+			return "", nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return "", nil, err
+		}
+		return "", nil, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(current.ExpiresAt) {
+		return "", nil, ErrInvalidRefreshToken
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET rotated_at = NOW() WHERE id = $1`, current.ID); err != nil {
+		return "", nil, err
+	}
+
+	next := &models.RefreshToken{
+		ID:            uuid.New(),
+		FamilyID:      current.FamilyID,
+		ParentID:      &current.ID,
+		UserID:        current.UserID,
+		WalletAddress: current.WalletAddress,
+	}
+
+	newToken, err := insertRefreshToken(ctx, tx, next)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", nil, err
+	}
+
+	return newToken, next, nil
+}
+
+// RevokeTokenFamily revokes every refresh token of one login, which also
+// invalidates the access tokens issued for it
+func (s *Service) RevokeTokenFamily(ctx context.Context, familyID string) error {
+	id, err := uuid.Parse(familyID)
+	if err != nil {
+		return fmt.Errorf("invalid session id: %w", err)
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := revokeFamily(ctx, tx, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RevokeAllTokens revokes every token family belonging to a user
+func (s *Service) RevokeAllTokens(ctx context.Context, userID uuid.UUID) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE auth_sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// revokeFamily revokes one login's session and refresh tokens
+func revokeFamily(ctx context.Context, conn execer, familyID uuid.UUID) error {
+	if _, err := conn.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL`, familyID); err != nil {
+		return err
+	}
+	_, err := conn.ExecContext(ctx, `UPDATE auth_sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, familyID)
+	return err
+}
+
+// CleanExpiredRefreshTokens removes refresh tokens past their expiry, and the
+// sessions left without any
+func (s *Service) CleanExpiredRefreshTokens() error {
+	query := `DELETE FROM refresh_tokens WHERE expires_at < NOW()`
+	if _, err := db.DB.Exec(query); err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM auth_sessions s
+		WHERE NOT EXISTS (SELECT 1 FROM refresh_tokens t WHERE t.family_id = s.id)
+	`
+	_, err := db.DB.Exec(query)
+	return err
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertRefreshToken generates a random token, stores its hash for record and
+// returns the plaintext token
+func insertRefreshToken(ctx context.Context, conn execer, record *models.RefreshToken) (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	now := time.Now().UTC()
+	record.CreatedAt = now
+	record.ExpiresAt = now.Add(refreshTokenExpiry())
+
+	query := `
+		INSERT INTO refresh_tokens (id, family_id, parent_id, user_id, wallet_address, token_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err := conn.ExecContext(ctx, query,
+		record.ID,
+		record.FamilyID,
+		record.ParentID,
+		record.UserID,
+		record.WalletAddress,
+		hashRefreshToken(token),
+		record.CreatedAt,
+		record.ExpiresAt,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return token, nil
+}
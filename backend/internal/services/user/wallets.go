@@ -0,0 +1,167 @@
+package user
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alexcolls/now.ink/backend/internal/db"
+	"github.com/alexcolls/now.ink/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// Wallet linking errors
+var (
+	ErrWalletLinkedElsewhere = errors.New("wallet is already linked to another account")
+	ErrWalletNotLinked       = errors.New("wallet is not linked to this account")
+	ErrPrimaryWallet         = errors.New("primary wallet cannot be unlinked")
+)
+
+// linkStatement is the statement shown when signing to link a wallet. It
+// names the account so the signature can't be used to link elsewhere.
+func linkStatement(userID uuid.UUID) string {
+	return "Link this wallet to now.ink account " + userID.String() + ". This request will not trigger a blockchain transaction or cost any fees."
+}
+
+// GenerateLinkNonce issues a nonce that proves control of another wallet for userID
+func (s *Service) GenerateLinkNonce(userID uuid.UUID, walletAddress string) (*models.Session, *models.SIWSMessage, error) {
+	return s.issueChallenge(walletAddress, noncePurposeLink, linkStatement(userID))
+}
+
+// LinkWallet verifies the signed link message and attaches the wallet to userID
+func (s *Service) LinkWallet(ctx context.Context, userID uuid.UUID, req *models.LinkWalletRequest) (*models.UserWallet, error) {
+	err := s.verifyChallenge(req.WalletAddress, req.Nonce, req.Message, req.Signature, noncePurposeLink, linkStatement(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO user_wallets (wallet_address, user_id, is_primary, created_at)
+		VALUES ($1, $2, FALSE, NOW())
+		ON CONFLICT (wallet_address) DO UPDATE SET wallet_address = EXCLUDED.wallet_address
+		RETURNING wallet_address, user_id, is_primary, created_at
+	`
+
+	wallet := &models.UserWallet{}
+	err = db.DB.QueryRowContext(ctx, query, req.WalletAddress, userID).Scan(
+		&wallet.WalletAddress,
+		&wallet.UserID,
+		&wallet.IsPrimary,
+		&wallet.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if wallet.UserID != userID {
+		return nil, ErrWalletLinkedElsewhere
+	}
+
+	return wallet, nil
+}
+
+// ListWallets returns every wallet linked to a user, primary first
+func (s *Service) ListWallets(ctx context.Context, userID uuid.UUID) ([]*models.UserWallet, error) {
+	query := `
+		SELECT wallet_address, user_id, is_primary, created_at
+		FROM user_wallets
+		WHERE user_id = $1
+		ORDER BY is_primary DESC, created_at ASC
+	`
+
+	rows, err := db.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	wallets := []*models.UserWallet{}
+	for rows.Next() {
+		wallet := &models.UserWallet{}
+		if err := rows.Scan(&wallet.WalletAddress, &wallet.UserID, &wallet.IsPrimary, &wallet.CreatedAt); err != nil {
+			return nil, err
+		}
+		wallets = append(wallets, wallet)
+	}
+
+	return wallets, rows.Err()
+}
+
+// SetPrimaryWallet makes a linked wallet the one NFTs are minted to
+func (s *Service) SetPrimaryWallet(ctx context.Context, userID uuid.UUID, walletAddress string) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var linked bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM user_wallets WHERE wallet_address = $1 AND user_id = $2)`,
+		walletAddress, userID,
+	).Scan(&linked)
+	if err != nil {
+		return err
+	}
+	if !linked {
+		return ErrWalletNotLinked
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE user_wallets SET is_primary = FALSE WHERE user_id = $1 AND is_primary`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE user_wallets SET is_primary = TRUE WHERE wallet_address = $1`, walletAddress); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET wallet_address = $1 WHERE id = $2`, walletAddress, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UnlinkWallet detaches a non-primary wallet from a user. The primary check
+// is part of the delete, so a concurrent SetPrimaryWallet can't slip between
+// them.
+func (s *Service) UnlinkWallet(ctx context.Context, userID uuid.UUID, walletAddress string) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`DELETE FROM user_wallets WHERE wallet_address = $1 AND user_id = $2 AND is_primary = FALSE`,
+		walletAddress, userID,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		var linked bool
+		err := tx.QueryRowContext(ctx,
+			`SELECT EXISTS(SELECT 1 FROM user_wallets WHERE wallet_address = $1 AND user_id = $2)`,
+			walletAddress, userID,
+		).Scan(&linked)
+		if err != nil {
+			return err
+		}
+		if linked {
+			return ErrPrimaryWallet
+		}
+		return ErrWalletNotLinked
+	}
+
+	// Logins made with the unlinked wallet no longer belong to this account
+	if _, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND wallet_address = $2 AND revoked_at IS NULL`, userID, walletAddress); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE auth_sessions SET revoked_at = NOW() WHERE user_id = $1 AND wallet_address = $2 AND revoked_at IS NULL`, userID, walletAddress); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
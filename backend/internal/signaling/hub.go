@@ -0,0 +1,210 @@
+package signaling
+
+import (
+	"errors"
+
+	"github.com/alexcolls/now.ink/backend/internal/wshub"
+)
+
+// Peer roles
+const (
+	RoleBroadcaster = "broadcaster"
+	RoleViewer      = "viewer"
+)
+
+// Message types. Offers, answers and ICE candidates are relayed between the
+// broadcaster and a viewer; the rest are sent by the server.
+const (
+	TypeOffer        = "offer"
+	TypeAnswer       = "answer"
+	TypeICECandidate = "ice_candidate"
+
+	TypeWelcome           = "welcome"
+	TypeViewerJoined      = "viewer_joined"
+	TypeViewerLeft        = "viewer_left"
+	TypeBroadcasterJoined = "broadcaster_joined"
+	TypeBroadcasterLeft   = "broadcaster_left"
+	TypeStreamEnded       = "stream_ended"
+	TypeError             = "error"
+)
+
+// ErrBroadcasterExists is returned when a stream already has a broadcaster
+var ErrBroadcasterExists = errors.New("stream already has a broadcaster")
+
+// Message is a signaling message. Relayed messages keep every field the
+// sender set (sdp, candidate, ...); the server overwrites "from" with the
+// sender's peer id and the broadcaster sets "to" to the viewer it addresses.
+type Message map[string]interface{}
+
+// Hub relays WebRTC signaling between the broadcaster of a live stream and
+// its viewers. Media flows peer to peer; the hub only passes SDP and ICE.
+type Hub struct {
+	rooms *wshub.Rooms
+	// broadcasters holds each stream's broadcaster; it is guarded by rooms
+	broadcasters map[string]*wshub.Peer
+
+	viewerJoined func(streamID, userID string)
+	viewerLeft   func(streamID, userID string)
+}
+
+// NewHub creates an empty signaling hub
+func NewHub() *Hub {
+	h := &Hub{broadcasters: map[string]*wshub.Peer{}}
+	h.rooms = wshub.NewRooms("Signaling", h.removed)
+	return h
+}
+
+// OnViewerJoined registers fn to run when a viewer connects to a stream. It
+// is called with the hub locked, so it must not block.
+func (h *Hub) OnViewerJoined(fn func(streamID, userID string)) {
+	h.viewerJoined = fn
+}
+
+// OnViewerLeft registers fn to run when a viewer disconnects from a stream.
+// It is called with the hub locked, so it must not block.
+func (h *Hub) OnViewerLeft(fn func(streamID, userID string)) {
+	h.viewerLeft = fn
+}
+
+// HasBroadcaster reports whether a broadcaster is connected to a stream
+func (h *Hub) HasBroadcaster(streamID string) bool {
+	h.rooms.Lock()
+	defer h.rooms.Unlock()
+
+	return h.broadcasters[streamID] != nil
+}
+
+// join adds a peer to its stream's room and introduces it to the others
+func (h *Hub) join(p *wshub.Peer) error {
+	h.rooms.Lock()
+	defer h.rooms.Unlock()
+
+	welcome := Message{
+		"type":      TypeWelcome,
+		"peer_id":   p.ID,
+		"stream_id": p.StreamID,
+		"role":      p.Role,
+	}
+
+	broadcaster := h.broadcasters[p.StreamID]
+	switch p.Role {
+	case RoleBroadcaster:
+		if broadcaster != nil {
+			return ErrBroadcasterExists
+		}
+		h.broadcasters[p.StreamID] = p
+
+		viewerIDs := []string{}
+		for id, viewer := range h.rooms.PeersLocked(p.StreamID) {
+			viewerIDs = append(viewerIDs, id)
+			h.rooms.SendLocked(viewer, Message{"type": TypeBroadcasterJoined, "from": p.ID})
+		}
+		welcome["viewers"] = viewerIDs
+		h.rooms.AddLocked(p)
+
+	case RoleViewer:
+		h.rooms.AddLocked(p)
+		if h.viewerJoined != nil {
+			h.viewerJoined(p.StreamID, p.UserID)
+		}
+
+		if broadcaster != nil {
+			welcome["broadcaster"] = broadcaster.ID
+			h.rooms.SendLocked(broadcaster, Message{"type": TypeViewerJoined, "from": p.ID, "viewer_count": h.viewerCountLocked(p.StreamID)})
+		}
+	}
+
+	h.rooms.SendLocked(p, welcome)
+	return nil
+}
+
+// leave removes a peer and tells the other side it is gone. It is a no-op if
+// the peer was already removed.
+func (h *Hub) leave(p *wshub.Peer) {
+	h.rooms.Leave(p)
+}
+
+// removed tells the other side of a stream that a peer is gone
+func (h *Hub) removed(p *wshub.Peer) {
+	if h.broadcasters[p.StreamID] == p {
+		delete(h.broadcasters, p.StreamID)
+		for _, viewer := range h.rooms.PeersLocked(p.StreamID) {
+			h.rooms.SendLocked(viewer, Message{"type": TypeBroadcasterLeft, "from": p.ID})
+		}
+		return
+	}
+
+	if h.viewerLeft != nil {
+		h.viewerLeft(p.StreamID, p.UserID)
+	}
+	if broadcaster := h.broadcasters[p.StreamID]; broadcaster != nil {
+		h.rooms.SendLocked(broadcaster, Message{"type": TypeViewerLeft, "from": p.ID, "viewer_count": h.viewerCountLocked(p.StreamID)})
+	}
+}
+
+// viewerCountLocked counts the viewers connected to a stream
+func (h *Hub) viewerCountLocked(streamID string) int {
+	count := len(h.rooms.PeersLocked(streamID))
+	if h.broadcasters[streamID] != nil {
+		count--
+	}
+	return count
+}
+
+// relay forwards an offer, answer or ICE candidate to the other side.
+// Viewers can only talk to the broadcaster; the broadcaster addresses one
+// viewer at a time.
+func (h *Hub) relay(from *wshub.Peer, msg Message) {
+	to, _ := msg["to"].(string)
+
+	h.rooms.Lock()
+	defer h.rooms.Unlock()
+
+	if h.rooms.PeerLocked(from.StreamID, from.ID) != from {
+		return
+	}
+
+	broadcaster := h.broadcasters[from.StreamID]
+	var recipient *wshub.Peer
+	if from == broadcaster {
+		if viewer := h.rooms.PeerLocked(from.StreamID, to); viewer != broadcaster {
+			recipient = viewer
+		}
+	} else if broadcaster != nil && (to == "" || to == broadcaster.ID) {
+		recipient = broadcaster
+	}
+
+	if recipient == nil {
+		h.rooms.SendLocked(from, errorMessage("unknown recipient"))
+		return
+	}
+
+	msg["from"] = from.ID
+	msg["to"] = recipient.ID
+	h.rooms.SendLocked(recipient, msg)
+}
+
+// CloseStream tells every peer of a stream that it ended and disconnects
+// them. It is called when the stream is ended through the API or by the
+// heartbeat reaper.
+func (h *Hub) CloseStream(streamID, reason string) {
+	h.rooms.Lock()
+	defer h.rooms.Unlock()
+
+	broadcaster := h.broadcasters[streamID]
+	delete(h.broadcasters, streamID)
+
+	if h.viewerLeft != nil {
+		for _, p := range h.rooms.PeersLocked(streamID) {
+			if p != broadcaster {
+				h.viewerLeft(streamID, p.UserID)
+			}
+		}
+	}
+
+	h.rooms.CloseLocked(streamID, Message{"type": TypeStreamEnded, "reason": reason})
+}
+
+func errorMessage(text string) Message {
+	return Message{"type": TypeError, "error": text}
+}
@@ -0,0 +1,206 @@
+package signaling
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/alexcolls/now.ink/backend/internal/wshub"
+)
+
+// newTestPeer creates a peer without a connection; the test reads what the
+// hub queues for it from its outbox
+func newTestPeer(streamID, userID, role string, buffer int) *wshub.Peer {
+	return wshub.NewPeer(nil, streamID, userID, role, buffer)
+}
+
+// next returns the next message queued for a peer
+func next(t *testing.T, p *wshub.Peer) Message {
+	t.Helper()
+
+	select {
+	case data, ok := <-p.Outbox():
+		if !ok {
+			t.Fatalf("peer %s was closed", p.ID)
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("undecodable message %s: %v", data, err)
+		}
+		return msg
+	default:
+		t.Fatalf("no message queued for peer %s", p.ID)
+		return nil
+	}
+}
+
+// drain drops the messages queued for a peer
+func drain(p *wshub.Peer) {
+	for len(p.Outbox()) > 0 {
+		<-p.Outbox()
+	}
+}
+
+// closed reports whether the hub closed a peer's send channel, draining
+// anything queued before
+func closed(p *wshub.Peer) bool {
+	for {
+		select {
+		case _, ok := <-p.Outbox():
+			if !ok {
+				return true
+			}
+		default:
+			return false
+		}
+	}
+}
+
+func TestHubJoinAndLeave(t *testing.T) {
+	hub := NewHub()
+	var joined, left []string
+	hub.OnViewerJoined(func(streamID, userID string) { joined = append(joined, userID) })
+	hub.OnViewerLeft(func(streamID, userID string) { left = append(left, userID) })
+
+	viewer := newTestPeer("stream-1", "viewer-user", RoleViewer, 8)
+	if err := hub.join(viewer); err != nil {
+		t.Fatalf("viewer join: %v", err)
+	}
+	if msg := next(t, viewer); msg["type"] != TypeWelcome || msg["broadcaster"] != nil {
+		t.Errorf("viewer welcome = %v, want no broadcaster yet", msg)
+	}
+
+	broadcaster := newTestPeer("stream-1", "owner", RoleBroadcaster, 8)
+	if err := hub.join(broadcaster); err != nil {
+		t.Fatalf("broadcaster join: %v", err)
+	}
+	welcome := next(t, broadcaster)
+	if viewers, _ := welcome["viewers"].([]interface{}); len(viewers) != 1 || viewers[0] != viewer.ID {
+		t.Errorf("broadcaster welcome = %v, want the waiting viewer", welcome)
+	}
+	if msg := next(t, viewer); msg["type"] != TypeBroadcasterJoined || msg["from"] != broadcaster.ID {
+		t.Errorf("viewer got %v, want broadcaster_joined", msg)
+	}
+	if !hub.HasBroadcaster("stream-1") {
+		t.Error("HasBroadcaster = false after the broadcaster joined")
+	}
+
+	second := newTestPeer("stream-1", "owner", RoleBroadcaster, 8)
+	if err := hub.join(second); !errors.Is(err, ErrBroadcasterExists) {
+		t.Errorf("second broadcaster err = %v, want ErrBroadcasterExists", err)
+	}
+
+	hub.leave(viewer)
+	hub.leave(viewer) // no-op
+	if msg := next(t, broadcaster); msg["type"] != TypeViewerLeft || msg["viewer_count"] != float64(0) {
+		t.Errorf("broadcaster got %v, want viewer_left with no viewers", msg)
+	}
+	if !closed(viewer) {
+		t.Error("viewer should be closed after leaving")
+	}
+
+	hub.leave(broadcaster)
+	if hub.HasBroadcaster("stream-1") || hub.rooms.Len() != 0 {
+		t.Error("empty room should be dropped")
+	}
+
+	if len(joined) != 1 || len(left) != 1 || joined[0] != "viewer-user" || left[0] != "viewer-user" {
+		t.Errorf("viewer callbacks joined=%v left=%v", joined, left)
+	}
+}
+
+func TestHubRelay(t *testing.T) {
+	hub := NewHub()
+	broadcaster := newTestPeer("stream-1", "owner", RoleBroadcaster, 8)
+	viewer := newTestPeer("stream-1", "a", RoleViewer, 8)
+	other := newTestPeer("stream-1", "b", RoleViewer, 8)
+	for _, p := range []*wshub.Peer{broadcaster, viewer, other} {
+		if err := hub.join(p); err != nil {
+			t.Fatalf("join: %v", err)
+		}
+	}
+	for _, p := range []*wshub.Peer{broadcaster, viewer, other} {
+		drain(p)
+	}
+
+	// A viewer's offer goes to the broadcaster, stamped with the sender
+	hub.relay(viewer, Message{"type": TypeOffer, "sdp": "v=0", "from": "spoofed"})
+	msg := next(t, broadcaster)
+	if msg["type"] != TypeOffer || msg["sdp"] != "v=0" || msg["from"] != viewer.ID || msg["to"] != broadcaster.ID {
+		t.Errorf("broadcaster got %v", msg)
+	}
+
+	// The broadcaster answers one viewer only
+	hub.relay(broadcaster, Message{"type": TypeAnswer, "sdp": "answer", "to": viewer.ID})
+	if msg := next(t, viewer); msg["type"] != TypeAnswer || msg["from"] != broadcaster.ID {
+		t.Errorf("viewer got %v", msg)
+	}
+	if len(other.Outbox()) != 0 {
+		t.Error("answer leaked to another viewer")
+	}
+
+	// Viewers can't address each other
+	hub.relay(viewer, Message{"type": TypeICECandidate, "to": other.ID})
+	if msg := next(t, viewer); msg["type"] != TypeError {
+		t.Errorf("viewer got %v, want an error", msg)
+	}
+	if len(other.Outbox()) != 0 {
+		t.Error("viewer reached another viewer")
+	}
+}
+
+func TestHubEvictsSlowPeer(t *testing.T) {
+	hub := NewHub()
+	broadcaster := newTestPeer("stream-1", "owner", RoleBroadcaster, 8)
+	slow := newTestPeer("stream-1", "slow", RoleViewer, 1)
+	if err := hub.join(broadcaster); err != nil {
+		t.Fatal(err)
+	}
+	if err := hub.join(slow); err != nil {
+		t.Fatal(err)
+	}
+	drain(broadcaster)
+
+	// The slow viewer's buffer holds its welcome; the next message overflows
+	hub.relay(broadcaster, Message{"type": TypeOffer, "to": slow.ID})
+
+	if !closed(slow) {
+		t.Fatal("slow viewer should be disconnected")
+	}
+	if msg := next(t, broadcaster); msg["type"] != TypeViewerLeft || msg["from"] != slow.ID {
+		t.Errorf("broadcaster got %v, want viewer_left", msg)
+	}
+}
+
+func TestHubCloseStream(t *testing.T) {
+	hub := NewHub()
+	left := 0
+	hub.OnViewerLeft(func(streamID, userID string) { left++ })
+
+	broadcaster := newTestPeer("stream-1", "owner", RoleBroadcaster, 8)
+	viewer := newTestPeer("stream-1", "a", RoleViewer, 8)
+	slow := newTestPeer("stream-1", "slow", RoleViewer, 1)
+	for _, p := range []*wshub.Peer{broadcaster, viewer, slow} {
+		if err := hub.join(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drain(viewer)
+
+	// Closing a full peer must not close its channel twice
+	hub.CloseStream("stream-1", "ended")
+
+	if msg := next(t, viewer); msg["type"] != TypeStreamEnded || msg["reason"] != "ended" {
+		t.Errorf("viewer got %v, want stream_ended", msg)
+	}
+	for _, p := range []*wshub.Peer{broadcaster, viewer, slow} {
+		if !closed(p) {
+			t.Errorf("peer %s should be closed", p.UserID)
+		}
+	}
+	if left != 2 || hub.rooms.Len() != 0 {
+		t.Errorf("viewer_left callbacks = %d, rooms = %d", left, hub.rooms.Len())
+	}
+
+	hub.CloseStream("stream-1", "ended") // no-op
+}
@@ -0,0 +1,50 @@
+package signaling
+
+import (
+	"encoding/json"
+
+	"github.com/alexcolls/now.ink/backend/internal/wshub"
+	"github.com/gofiber/contrib/websocket"
+)
+
+const (
+	// maxMessageSize bounds SDP offers and ICE candidates
+	maxMessageSize = 64 * 1024
+	// sendBuffer is how many messages may queue for a peer
+	sendBuffer = 32
+)
+
+// Serve runs a signaling connection. The route must have set the
+// "stream_id", "user_id" and "signaling_role" locals before upgrading.
+func (h *Hub) Serve(conn *websocket.Conn) {
+	streamID, _ := conn.Locals("stream_id").(string)
+	userID, _ := conn.Locals("user_id").(string)
+	role, _ := conn.Locals("signaling_role").(string)
+
+	p := wshub.NewPeer(conn, streamID, userID, role, sendBuffer)
+	if err := h.join(p); err != nil {
+		p.Reject(errorMessage(err.Error()), websocket.ClosePolicyViolation, err.Error())
+		return
+	}
+
+	h.rooms.Serve(p, maxMessageSize, func(data []byte) {
+		h.handle(p, data)
+	})
+}
+
+// handle relays one message from the peer
+func (h *Hub) handle(p *wshub.Peer, data []byte) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		h.rooms.Reply(p, errorMessage("invalid message"))
+		return
+	}
+
+	msgType, _ := msg["type"].(string)
+	switch msgType {
+	case TypeOffer, TypeAnswer, TypeICECandidate:
+		h.relay(p, msg)
+	default:
+		h.rooms.Reply(p, errorMessage("unsupported message type"))
+	}
+}
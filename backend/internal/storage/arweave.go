@@ -127,6 +127,35 @@ func (a *ArweaveClient) UploadMetadata(ctx context.Context, metadata NFTMetadata
 	return tx.ID, nil
 }
 
+// UploadJSON uploads a JSON document, such as a chat transcript, to Arweave
+func (a *ArweaveClient) UploadJSON(ctx context.Context, data interface{}, dataType string) (string, error) {
+	// For MVP without wallet, return mock transaction ID
+	if a.client == nil {
+		log.Println("📦 Mock Arweave JSON upload:", dataType)
+		mockTxID := fmt.Sprintf("MOCK_AR_JSON_%d", time.Now().Unix())
+		return mockTxID, nil
+	}
+
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal %s: %w", dataType, err)
+	}
+
+	tags := []types.Tag{
+		{Name: "Content-Type", Value: "application/json"},
+		{Name: "App-Name", Value: "now.ink"},
+		{Name: "Type", Value: dataType},
+	}
+
+	tx, err := a.wallet.SendData(jsonData, tags)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload %s to Arweave: %w", dataType, err)
+	}
+
+	log.Printf("✅ Uploaded %s to Arweave: %s", dataType, tx.ID)
+	return tx.ID, nil
+}
+
 // GetTransactionStatus checks if a transaction is confirmed
 func (a *ArweaveClient) GetTransactionStatus(txID string) (string, error) {
 	if a.client == nil {
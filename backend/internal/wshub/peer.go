@@ -0,0 +1,100 @@
+package wshub
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+)
+
+const (
+	// writeWait is how long a single write may take
+	writeWait = 10 * time.Second
+	// pongWait is how long a peer may stay silent before it is dropped
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait
+	pingPeriod = 25 * time.Second
+)
+
+// Peer is one WebSocket connection to a stream's room
+type Peer struct {
+	ID       string
+	StreamID string
+	UserID   string
+	Role     string
+	conn     *websocket.Conn
+	send     chan []byte
+}
+
+// NewPeer creates a peer for conn that may have up to buffer messages
+// queued before it counts as too slow
+func NewPeer(conn *websocket.Conn, streamID, userID, role string, buffer int) *Peer {
+	return &Peer{
+		ID:       uuid.New().String(),
+		StreamID: streamID,
+		UserID:   userID,
+		Role:     role,
+		conn:     conn,
+		send:     make(chan []byte, buffer),
+	}
+}
+
+// Outbox returns the messages queued for the peer; it is closed once the
+// peer is removed. The write pump drains it, or tests in its place.
+func (p *Peer) Outbox() <-chan []byte {
+	return p.send
+}
+
+// Reject tells a peer that couldn't join why and closes its connection
+func (p *Peer) Reject(msg interface{}, closeCode int, reason string) {
+	data, _ := json.Marshal(msg)
+	p.conn.WriteMessage(websocket.TextMessage, data)
+	p.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, reason))
+}
+
+// readPump passes the peer's messages to handle until the connection closes
+func (p *Peer) readPump(readLimit int64, handle func(data []byte)) error {
+	p.conn.SetReadLimit(readLimit)
+	p.conn.SetReadDeadline(time.Now().Add(pongWait))
+	p.conn.SetPongHandler(func(string) error {
+		return p.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := p.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		handle(data)
+	}
+}
+
+// writePump writes queued messages and keepalive pings. It closes the
+// connection once the peer's send channel is closed.
+func (p *Peer) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data, ok := <-p.send:
+			p.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				p.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				p.conn.Close()
+				return
+			}
+			if err := p.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				p.conn.Close()
+				return
+			}
+		case <-ticker.C:
+			p.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := p.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				p.conn.Close()
+				return
+			}
+		}
+	}
+}
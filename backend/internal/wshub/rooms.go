@@ -0,0 +1,161 @@
+package wshub
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+// Rooms groups the WebSocket peers of live streams by stream. Hubs lock it
+// to make several changes at once and call the Locked methods in between;
+// the other methods lock it themselves.
+type Rooms struct {
+	sync.Mutex
+	name     string
+	rooms    map[string]map[string]*Peer
+	onRemove func(p *Peer)
+}
+
+// NewRooms creates empty rooms. name labels log lines ("Chat",
+// "Signaling"). onRemove, if set, runs with the rooms locked whenever a
+// single peer is removed, after it left its room and before its send
+// channel is closed; it doesn't run for CloseLocked.
+func NewRooms(name string, onRemove func(p *Peer)) *Rooms {
+	return &Rooms{
+		name:     name,
+		rooms:    map[string]map[string]*Peer{},
+		onRemove: onRemove,
+	}
+}
+
+// Serve runs a peer's connection once it joined: handle gets every
+// message it sends until the connection closes, then the peer is removed
+func (r *Rooms) Serve(p *Peer, readLimit int64, handle func(data []byte)) {
+	done := make(chan struct{})
+	go func() {
+		p.writePump()
+		close(done)
+	}()
+
+	err := p.readPump(readLimit, handle)
+	if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+		log.Printf("⚠️  %s connection %s closed: %v", r.name, p.ID, err)
+	}
+
+	r.Leave(p)
+	<-done
+}
+
+// Len returns how many streams have peers connected
+func (r *Rooms) Len() int {
+	r.Lock()
+	defer r.Unlock()
+
+	return len(r.rooms)
+}
+
+// AddLocked puts a peer in its stream's room
+func (r *Rooms) AddLocked(p *Peer) {
+	room, ok := r.rooms[p.StreamID]
+	if !ok {
+		room = map[string]*Peer{}
+		r.rooms[p.StreamID] = room
+	}
+	room[p.ID] = p
+}
+
+// PeersLocked returns the peers of a stream keyed by id. The map belongs to
+// the rooms: callers may range over it but must not change it.
+func (r *Rooms) PeersLocked(streamID string) map[string]*Peer {
+	return r.rooms[streamID]
+}
+
+// PeerLocked returns a stream's peer by id, or nil
+func (r *Rooms) PeerLocked(streamID, id string) *Peer {
+	return r.rooms[streamID][id]
+}
+
+// Leave removes a peer. It is a no-op if the peer was already removed.
+func (r *Rooms) Leave(p *Peer) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.RemoveLocked(p)
+}
+
+// RemoveLocked takes a peer out of its room and closes its send channel,
+// which makes its write pump close the connection. It is a no-op if the
+// peer was already removed.
+func (r *Rooms) RemoveLocked(p *Peer) {
+	room, ok := r.rooms[p.StreamID]
+	if !ok || room[p.ID] != p {
+		return
+	}
+
+	delete(room, p.ID)
+	if r.onRemove != nil {
+		r.onRemove(p)
+	}
+	close(p.send)
+
+	if len(room) == 0 {
+		delete(r.rooms, p.StreamID)
+	}
+}
+
+// Reply sends a message back to the peer that caused it, if it is still
+// connected
+func (r *Rooms) Reply(p *Peer, msg interface{}) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.rooms[p.StreamID][p.ID] == p {
+		r.SendLocked(p, msg)
+	}
+}
+
+// Broadcast sends a message to every peer of a stream
+func (r *Rooms) Broadcast(streamID string, msg interface{}) {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, p := range r.rooms[streamID] {
+		r.SendLocked(p, msg)
+	}
+}
+
+// CloseLocked sends a last message to every peer of a stream and
+// disconnects them all
+func (r *Rooms) CloseLocked(streamID string, msg interface{}) {
+	room, ok := r.rooms[streamID]
+	if !ok {
+		return
+	}
+
+	// Drop the room first so a slow peer isn't removed (and closed) twice
+	delete(r.rooms, streamID)
+
+	for _, p := range room {
+		r.SendLocked(p, msg)
+		close(p.send)
+	}
+}
+
+// SendLocked queues a message for a peer. A peer too slow to keep up is
+// removed rather than allowed to block the hub.
+func (r *Rooms) SendLocked(p *Peer, msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("⚠️  Failed to encode %s message: %v", strings.ToLower(r.name), err)
+		return
+	}
+
+	select {
+	case p.send <- data:
+	default:
+		r.RemoveLocked(p)
+	}
+}
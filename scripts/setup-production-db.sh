@@ -74,8 +74,11 @@ echo ""
 
 echo "📊 Running migrations..."
 
-# Run migrations
-sudo -u postgres psql -U nowink_user -d nowink -f /home/nowink/now.ink/backend/internal/db/migrations/001_initial_schema.sql
+# Run every migration in order (the numbered file names sort correctly)
+for migration in /home/nowink/now.ink/backend/internal/db/migrations/*.sql; do
+    echo "   → $(basename "$migration")"
+    sudo -u postgres psql -U nowink_user -d nowink -v ON_ERROR_STOP=1 -f "$migration"
+done
 
 echo "✅ Migrations complete"
 echo ""